@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -22,6 +25,12 @@ const (
 
 	GetGuildListAPI = "/users/@me/guilds"
 
+	GetGuildAPI = "/guilds/%s" // guild_id
+
+	GetChannelsAPI = "/guilds/%s/channels" // guild_id
+
+	GetGuildMemberAPI = "/guilds/%s/members/%s" // guild_id, user_id
+
 	CreateGroupMessageAPI = "/v2/groups/%s/messages" // group_openid
 
 	CreateUserMessageAPI = "/v2/users/%s/messages" // openid
@@ -107,6 +116,19 @@ type API struct {
 
 	BotID    string
 	Handlers map[string]EventHandler
+
+	// State 可选的频道状态缓存，通过 EnableState 开启
+	State *StateCache
+
+	// Recorder 可选的网关消息录制
+	Recorder *Recorder
+
+	// Logger 可选的日志，为空时使用 DefaultLogger，输出前会隐藏 token
+	Logger Logger
+	logger atomic.Pointer[apiLogger] // 由 Log 创建，隐藏 token 的 Logger
+
+	// Metrics 可选的指标，通过 EnableMetrics 开启
+	Metrics *Metrics
 }
 
 func BotToken(ticket Ticket) string {
@@ -153,25 +175,53 @@ func (a *API) doSimpleRequest(method, api string, request, response interface{})
 	if err != nil {
 		return err
 	}
-	log.Println("doSimpleRequest.Request", api, string(reqData))
+	logger := WithFields(a.Log(), "method", method, "api", api)
+	// 请求与返回的内容只在 debug 级别输出
+	logger.Debug("rest_request", "body", reqData)
 
 	cli := a.Client
 	if cli == nil {
 		cli = http.DefaultClient
 	}
+	start := time.Now()
 	resp, err := cli.Do(req)
 	if err != nil {
-		log.Println("doSimpleRequet.Response", api, err)
+		logger.Warn("rest_response", "err", err)
+		if a.Metrics != nil {
+			endpoint := Endpoint(api)
+			a.Metrics.RESTLatency.ObserveSince(start, method, endpoint)
+			a.Metrics.RESTErrors.Inc(method, endpoint, "transport")
+		}
 		return err
 	}
 
-	var b = make([]byte, 0, resp.ContentLength)
+	defer resp.Body.Close()
+
+	var b []byte
+	if resp.ContentLength > 0 {
+		b = make([]byte, 0, resp.ContentLength)
+	}
 	b, err = ReadAll(b, resp.Body)
 	if err != nil {
 		return fmt.Errorf("readAll: %w", err)
 	}
-	log.Println("doSimpleRequet.Response", api, resp.StatusCode, string(b))
+	if resp.StatusCode >= http.StatusBadRequest {
+		logger.Warn("rest_response", "status", resp.StatusCode, "body", b)
+	} else {
+		logger.Debug("rest_response", "status", resp.StatusCode, "body", b)
+	}
+	if a.Metrics != nil {
+		a.observeResponse(method, api, start, resp.StatusCode, b)
+	}
 
+	// 失败时返回内容可能为空，或者不是调用方期望的结构，不能当作成功
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("status: %d, body: %s", resp.StatusCode, b)
+	}
+	// 部分接口成功时没有返回内容，如 204 No Content
+	if response == nil || len(b) == 0 {
+		return nil
+	}
 	if err := json.Unmarshal(b, response); err != nil {
 		return err
 	}
@@ -179,6 +229,25 @@ func (a *API) doSimpleRequest(method, api string, request, response interface{})
 	return nil
 }
 
+// observeResponse 记录接口耗时，HTTP 状态码或返回中的 code 表示失败时记录错误码
+func (a *API) observeResponse(method, api string, start time.Time, status int, body []byte) {
+	endpoint := Endpoint(api)
+	a.Metrics.RESTLatency.ObserveSince(start, method, endpoint)
+
+	var result struct {
+		Code int `json:"code"`
+	}
+	if len(body) > 0 && body[0] == '{' {
+		json.Unmarshal(body, &result)
+	}
+	switch {
+	case result.Code != 0:
+		a.Metrics.RESTErrors.Inc(method, endpoint, strconv.Itoa(result.Code))
+	case status >= http.StatusBadRequest:
+		a.Metrics.RESTErrors.Inc(method, endpoint, strconv.Itoa(status))
+	}
+}
+
 func (a *API) CreateChannel(guildID string, request ChannelInfo) (*Channel, error) {
 	method := http.MethodPost
 	api := fmt.Sprintf(CreateChannelAPI, guildID)
@@ -210,15 +279,73 @@ type Guild struct {
 
 func (a *API) GetGuildList(request GuildListRequest) ([]Guild, error) {
 	method := http.MethodGet
+	// GET 请求的参数通过 query 传递
+	query := url.Values{}
+	if len(request.Before) > 0 {
+		query.Set("before", request.Before)
+	}
+	if len(request.After) > 0 {
+		query.Set("after", request.After)
+	}
+	if request.Limit > 0 {
+		query.Set("limit", strconv.Itoa(request.Limit))
+	}
 	api := GetGuildListAPI
+	if len(query) > 0 {
+		api += "?" + query.Encode()
+	}
 	var result []Guild
-	if err := a.doSimpleRequest(method, api, &request, &result); err != nil {
+	if err := a.doSimpleRequest(method, api, nil, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (a *API) GetGuild(guildID string) (*Guild, error) {
+	method := http.MethodGet
+	api := fmt.Sprintf(GetGuildAPI, guildID)
+	var result Guild
+	if err := a.doSimpleRequest(method, api, nil, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (a *API) GetChannels(guildID string) ([]Channel, error) {
+	method := http.MethodGet
+	api := fmt.Sprintf(GetChannelsAPI, guildID)
+	var result []Channel
+	if err := a.doSimpleRequest(method, api, nil, &result); err != nil {
 		return nil, err
 	}
 
 	return result, nil
 }
 
+// GuildMember 频道成员，也是 GUILD_MEMBER_ADD/GUILD_MEMBER_UPDATE/GUILD_MEMBER_REMOVE 的数据
+type GuildMember struct {
+	GuildID string `json:"guild_id"`
+	User    User   `json:"user"`
+	Member
+	OpUserID string `json:"op_user_id,omitempty"` // 事件的操作人
+}
+
+func (a *API) GetGuildMember(guildID, userID string) (*GuildMember, error) {
+	method := http.MethodGet
+	api := fmt.Sprintf(GetGuildMemberAPI, guildID, userID)
+	var result GuildMember
+	if err := a.doSimpleRequest(method, api, nil, &result); err != nil {
+		return nil, err
+	}
+	if len(result.GuildID) == 0 {
+		result.GuildID = guildID
+	}
+
+	return &result, nil
+}
+
 type GatewayInfo struct {
 	URL               string `json:"url"`
 	Shards            int    `json:"shards"`
@@ -263,6 +390,123 @@ type CreateMessageRequest struct {
 	MsgID   string `json:"msg_id"`
 	EventID string `json:"event_id,omitempty"`
 	MsgSeq  int    `json:"msg_seq,omitempty"`
+
+	MessageReference *MessageReference `json:"message_reference,omitempty"` // 引用消息，仅频道支持
+
+	Markdown *Markdown `json:"markdown,omitempty"` // msg_type 为 MsgTypeMarkdown 时有效
+	Keyboard *Keyboard `json:"keyboard,omitempty"` // 消息按钮，需要与 markdown 一起发送
+	Ark      *Ark      `json:"ark,omitempty"`      // msg_type 为 MsgTypeArk 时有效
+}
+
+// Markdown 原生 markdown 内容
+type Markdown struct {
+	Content string `json:"content"`
+}
+
+// Ark 模板消息，需要申请对应模板的权限
+type Ark struct {
+	TemplateID int     `json:"template_id"`
+	KV         []ArkKV `json:"kv"`
+}
+
+type ArkKV struct {
+	Key   string   `json:"key"`
+	Value string   `json:"value,omitempty"`
+	Obj   []ArkObj `json:"obj,omitempty"`
+}
+
+type ArkObj struct {
+	ObjKV []ArkObjKV `json:"obj_kv"`
+}
+
+type ArkObjKV struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ArkTemplateList 23 号模板，一段描述加一个文本列表
+const ArkTemplateList = 23
+
+// NewListArk 23 号模板的消息，prompt 为消息列表中展示的提示，items 为列表中的每一行
+func NewListArk(desc, prompt string, items ...string) *Ark {
+	list := ArkKV{Key: "#LIST#"}
+	for _, item := range items {
+		list.Obj = append(list.Obj, ArkObj{ObjKV: []ArkObjKV{{Key: "desc", Value: item}}})
+	}
+	return &Ark{
+		TemplateID: ArkTemplateList,
+		KV: []ArkKV{
+			{Key: "#DESC#", Value: desc},
+			{Key: "#PROMPT#", Value: prompt},
+			list,
+		},
+	}
+}
+
+// Keyboard 自定义消息按钮
+type Keyboard struct {
+	Content struct {
+		Rows []KeyboardRow `json:"rows"`
+	} `json:"content"`
+}
+
+type KeyboardRow struct {
+	Buttons []Button `json:"buttons"`
+}
+
+// 按钮的操作类型
+const (
+	ButtonActionLink     = 0 // 跳转链接
+	ButtonActionCallback = 1 // 回调，触发 INTERACTION_CREATE 事件
+	ButtonActionCommand  = 2 // 指令，在输入框中插入@机器人和 data
+)
+
+// 按钮的权限类型
+const (
+	ButtonPermissionUsers    = 0 // 指定用户
+	ButtonPermissionAdmin    = 1 // 仅管理者
+	ButtonPermissionEveryone = 2 // 所有人
+	ButtonPermissionRoles    = 3 // 指定身份组，仅频道
+)
+
+type Button struct {
+	ID         string `json:"id"`
+	RenderData struct {
+		Label        string `json:"label"`
+		VisitedLabel string `json:"visited_label"`
+		Style        int    `json:"style"` // 0 灰色线框，1 蓝色线框
+	} `json:"render_data"`
+	Action struct {
+		Type       int `json:"type"`
+		Permission struct {
+			Type int `json:"type"`
+		} `json:"permission"`
+		Data          string `json:"data"`
+		UnsupportTips string `json:"unsupport_tips"`
+	} `json:"action"`
+}
+
+// NewCallbackButton 所有人都可以点击的回调按钮
+func NewCallbackButton(id, label, data string) Button {
+	var b Button
+	b.ID = id
+	b.RenderData.Label = label
+	b.RenderData.VisitedLabel = label
+	b.RenderData.Style = 1
+	b.Action.Type = ButtonActionCallback
+	b.Action.Permission.Type = ButtonPermissionEveryone
+	b.Action.Data = data
+	b.Action.UnsupportTips = "当前版本不支持，请升级客户端"
+	return b
+}
+
+// NewKeyboard 每个参数为一行按钮
+func NewKeyboard(rows ...[]Button) *Keyboard {
+	var k Keyboard
+	for _, buttons := range rows {
+		k.Content.Rows = append(k.Content.Rows, KeyboardRow{Buttons: buttons})
+	}
+	return &k
 }
 
 type CreateMessageResposne struct {
@@ -1,32 +1,32 @@
 package sgroupbot_test
 
 import (
+	"context"
+	"net/http"
 	"sgroupbot"
+	"sgroupbot/sgroupbottest"
+	"strings"
 	"testing"
+	"time"
 )
 
-var ticket = sgroupbot.Ticket{
-	AppID: 00,
-	Token: "xxxx",
-}
-
-var api = sgroupbot.API{
-	Target: sgroupbot.SandboxSgroupTarget,
-	Ticket: ticket,
-}
-
 func TestCreateChannel(t *testing.T) {
+	srv := sgroupbottest.NewServer()
+	defer srv.Close()
+	srv.AddGuild(sgroupbot.Guild{ID: "17581271750430039607", Name: "成语接龙"})
+
+	api := srv.API()
 
 	var listReq = sgroupbot.GuildListRequest{
 		Limit: 100,
 	}
 	list, err := api.GetGuildList(listReq)
 	if err != nil {
-		t.Log(err)
-		return
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("guild list: %+v", list)
 	}
-
-	t.Log(list)
 
 	guildID := "17581271750430039607"
 
@@ -37,13 +37,114 @@ func TestCreateChannel(t *testing.T) {
 
 	channel, err := api.CreateChannel(guildID, channelInfo)
 	if err != nil {
-		t.Log(err)
-		return
+		t.Fatal(err)
+	}
+	if channel.GuildID != guildID || channel.Name != channelInfo.Name || len(channel.ID) == 0 {
+		t.Errorf("channel: %+v", channel)
 	}
 
-	t.Log(channel)
+	channels, err := api.GetChannels(guildID)
+	if err != nil || len(channels) != 1 || channels[0].ID != channel.ID {
+		t.Errorf("channels: %+v %v", channels, err)
+	}
 
+	if err := (&sgroupbot.API{Target: srv.URL}).CreateGroupMessage("G1", sgroupbot.CreateMessageRequest{}); err == nil {
+		t.Error("wrong token should fail")
+	}
 }
 
 func TestWs(t *testing.T) {
+	srv := sgroupbottest.NewServer()
+	defer srv.Close()
+	srv.HeartbeatInterval = 50 * time.Millisecond
+
+	api := srv.API()
+	api.Intents = sgroupbot.IntentGroupAndC2CEvent
+	var received = make(chan string, 16)
+	api.Handlers[sgroupbot.EventC2CMessageCreate] = func(wm sgroupbot.WsMessage) {
+		received <- wm.ID
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- api.StartWs(ctx)
+	}()
+
+	if err := srv.WaitReady(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("event: got %s, want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %s not received", want)
+		}
+	}
+
+	msg := map[string]interface{}{"author": map[string]string{"user_openid": "U1"}, "content": "成语接龙"}
+	if err := srv.Dispatch(sgroupbot.EventC2CMessageCreate, msg); err != nil {
+		t.Fatal(err)
+	}
+	expect("C2C_MESSAGE_CREATE:2")
+
+	// 没有订阅的事件不会下发
+	srv.Dispatch(sgroupbot.EventAtMessageCreate, msg)
+
+	// 断线期间的事件在 resume 之后补发
+	srv.Disconnect()
+	srv.Dispatch(sgroupbot.EventC2CMessageCreate, msg)
+	if err := srv.WaitReady(3 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expect("C2C_MESSAGE_CREATE:4")
+	if srv.Resumes() != 1 || srv.Identifies() != 1 {
+		t.Errorf("resumes %d, identifies %d", srv.Resumes(), srv.Identifies())
+	}
+
+	// 会话失效之后重新 identify
+	srv.InvalidateSessions()
+	if err := srv.WaitReady(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Identifies() != 2 {
+		t.Errorf("identifies %d", srv.Identifies())
+	}
+	if api.BotID != sgroupbottest.BotUser.ID {
+		t.Errorf("bot id: %s", api.BotID)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRequestStatusError(t *testing.T) {
+	srv := sgroupbottest.NewServer()
+	defer srv.Close()
+	api := srv.API()
+	emoji := sgroupbot.ReactionEmoji{ID: sgroupbot.EmojiThumbsUp, Type: sgroupbot.EmojiTypeEmoji}
+
+	// 成功时没有返回内容
+	if err := api.PutReaction("100010", "m1", emoji); err != nil {
+		t.Fatal(err)
+	}
+	// 失败时没有返回内容，也不能当作成功
+	srv.FailNext(1, http.StatusBadGateway, 0)
+	if err := api.PutReaction("100010", "m1", emoji); err == nil || !strings.Contains(err.Error(), "status: 502") {
+		t.Errorf("empty 502: %v", err)
+	}
+	srv.FailNext(1, http.StatusInternalServerError, 0)
+	if err := api.AckInteraction("e1", sgroupbot.InteractionOK); err == nil {
+		t.Error("empty 500 ack should fail")
+	}
+	srv.FailNext(1, http.StatusForbidden, 11264)
+	if err := api.PutReaction("100010", "m1", emoji); err == nil || !strings.Contains(err.Error(), "11264") {
+		t.Errorf("403 with code: %v", err)
+	}
 }
@@ -0,0 +1,118 @@
+package sgroupbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	AudioControlAPI = "/channels/%s/audio" // channel_id
+	MicAPI          = "/channels/%s/mic"   // channel_id
+)
+
+// 音频的播放状态
+const (
+	AudioStart  = 0 // 开始播放
+	AudioPause  = 1 // 暂停播放
+	AudioResume = 2 // 继续播放
+	AudioStop   = 3 // 停止播放
+)
+
+// AudioControl 控制语音子频道的音频播放
+type AudioControl struct {
+	URL    string `json:"audio_url"` // 音频地址，仅 AudioStart 需要
+	Text   string `json:"text"`      // 状态文本，比如：简单爱-周杰伦，仅 AudioStart 需要
+	Status int    `json:"status"`
+}
+
+//	{
+//	    "guild_id": "18700000000001",
+//	    "channel_id": "100010",
+//	    "audio_url": "https://xxx.mp3",
+//	    "text": "简单爱-周杰伦"
+//	}
+//
+// AudioAction AUDIO_START/AUDIO_FINISH/AUDIO_ON_MIC/AUDIO_OFF_MIC 的数据
+type AudioAction struct {
+	GuildID   string `json:"guild_id"`
+	ChannelID string `json:"channel_id"`
+	URL       string `json:"audio_url,omitempty"` // 仅 AUDIO_START/AUDIO_FINISH 有
+	Text      string `json:"text,omitempty"`
+}
+
+// AudioHandler 处理音频事件
+type AudioHandler func(eventType string, action *AudioAction)
+
+// AudioEvents 音频的全部事件
+var AudioEvents = []string{EventAudioStart, EventAudioFinish, EventAudioOnMic, EventAudioOffMic}
+
+// HandleAudio 将 AudioHandler 包装为 EventHandler，需要设置 IntentAudioAction
+func HandleAudio(h AudioHandler) EventHandler {
+	return func(wm WsMessage) {
+		var action AudioAction
+		if err := json.Unmarshal(wm.Data, &action); err != nil {
+			DefaultLogger.Warn("audio_event", "event", wm.Type, "err", err)
+			return
+		}
+		h(wm.Type, &action)
+	}
+}
+
+// PostAudio 控制语音子频道的音频播放，机器人需要先上麦
+func (a *API) PostAudio(channelID string, control AudioControl) error {
+	method := http.MethodPost
+	api := fmt.Sprintf(AudioControlAPI, channelID)
+	var result CreateMessageResposne
+	if err := a.doSimpleRequest(method, api, &control, &result); err != nil {
+		return err
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("code: %d, msg: %s", result.Code, result.Message)
+	}
+
+	return nil
+}
+
+// PlayAudio 开始播放音频
+func (a *API) PlayAudio(channelID, url, text string) error {
+	return a.PostAudio(channelID, AudioControl{URL: url, Text: text, Status: AudioStart})
+}
+
+// PauseAudio 暂停播放
+func (a *API) PauseAudio(channelID string) error {
+	return a.PostAudio(channelID, AudioControl{Status: AudioPause})
+}
+
+// ResumeAudio 继续播放
+func (a *API) ResumeAudio(channelID string) error {
+	return a.PostAudio(channelID, AudioControl{Status: AudioResume})
+}
+
+// StopAudio 停止播放
+func (a *API) StopAudio(channelID string) error {
+	return a.PostAudio(channelID, AudioControl{Status: AudioStop})
+}
+
+// OnMic 机器人在语音子频道上麦
+func (a *API) OnMic(channelID string) error {
+	return a.mic(http.MethodPut, channelID)
+}
+
+// OffMic 机器人在语音子频道下麦
+func (a *API) OffMic(channelID string) error {
+	return a.mic(http.MethodDelete, channelID)
+}
+
+func (a *API) mic(method, channelID string) error {
+	api := fmt.Sprintf(MicAPI, channelID)
+	var result CreateMessageResposne
+	if err := a.doSimpleRequest(method, api, &struct{}{}, &result); err != nil {
+		return err
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("code: %d, msg: %s", result.Code, result.Message)
+	}
+
+	return nil
+}
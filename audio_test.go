@@ -0,0 +1,105 @@
+package sgroupbot_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sgroupbot"
+	"sync"
+	"testing"
+)
+
+func TestHandleAudio(t *testing.T) {
+	type call struct {
+		event  string
+		action sgroupbot.AudioAction
+	}
+	var calls []call
+	handler := sgroupbot.HandleAudio(func(eventType string, action *sgroupbot.AudioAction) {
+		calls = append(calls, call{eventType, *action})
+	})
+	event := func(eventType, data string) {
+		var wm sgroupbot.WsMessage
+		wm.Type = eventType
+		wm.Data = json.RawMessage(data)
+		handler(wm)
+	}
+
+	event(sgroupbot.EventAudioStart, `{"guild_id":"18700000000001","channel_id":"100010","audio_url":"https://example.com/a.mp3","text":"简单爱-周杰伦"}`)
+	event(sgroupbot.EventAudioOnMic, `{"guild_id":"18700000000001","channel_id":"100010"}`)
+	event(sgroupbot.EventAudioFinish, `[]`)
+
+	want := []call{
+		{sgroupbot.EventAudioStart, sgroupbot.AudioAction{GuildID: "18700000000001", ChannelID: "100010", URL: "https://example.com/a.mp3", Text: "简单爱-周杰伦"}},
+		{sgroupbot.EventAudioOnMic, sgroupbot.AudioAction{GuildID: "18700000000001", ChannelID: "100010"}},
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("calls: %+v", calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("call %d: %+v, want %+v", i, calls[i], want[i])
+		}
+	}
+}
+
+func TestAudioControl(t *testing.T) {
+	type request struct {
+		method string
+		body   string
+	}
+	var mu sync.Mutex
+	var requests []request
+	record := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, request{r.Method, string(body)})
+		mu.Unlock()
+		w.Write([]byte(`{}`))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/channels/100010/audio", record)
+	mux.HandleFunc("/channels/100010/mic", record)
+	mux.HandleFunc("/channels/100011/audio", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code":304035,"message":"bot not on mic"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	api := sgroupbot.API{Target: srv.URL}
+	for _, f := range []func() error{
+		func() error { return api.OnMic("100010") },
+		func() error { return api.PlayAudio("100010", "https://example.com/a.mp3", "简单爱-周杰伦") },
+		func() error { return api.PauseAudio("100010") },
+		func() error { return api.ResumeAudio("100010") },
+		func() error { return api.StopAudio("100010") },
+		func() error { return api.OffMic("100010") },
+	} {
+		if err := f(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []request{
+		{http.MethodPut, `{}`},
+		{http.MethodPost, `{"audio_url":"https://example.com/a.mp3","text":"简单爱-周杰伦","status":0}`},
+		{http.MethodPost, `{"audio_url":"","text":"","status":1}`},
+		{http.MethodPost, `{"audio_url":"","text":"","status":2}`},
+		{http.MethodPost, `{"audio_url":"","text":"","status":3}`},
+		{http.MethodDelete, `{}`},
+	}
+	if len(requests) != len(want) {
+		t.Fatalf("requests: %+v", requests)
+	}
+	for i := range want {
+		if requests[i] != want[i] {
+			t.Errorf("request %d: %+v, want %+v", i, requests[i], want[i])
+		}
+	}
+
+	// 没有上麦时平台返回错误码
+	if err := api.PauseAudio("100011"); err == nil {
+		t.Error("want error")
+	}
+}
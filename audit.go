@@ -0,0 +1,172 @@
+package sgroupbot
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrAuditRejected = errors.New("message audit rejected")
+	ErrAuditTimeout  = errors.New("message audit timeout")
+)
+
+//	{
+//	    "audit_id": "b3a84d00-37b5-11ec-a67b-525400cd2e1a",
+//	    "audit_time": "2021-10-26T16:30:47+08:00",
+//	    "channel_id": "1419773",
+//	    "create_time": "2021-10-26T16:30:43+08:00",
+//	    "guild_id": "3729451079003766849",
+//	    "message_id": "0812d3b5c8b7d5cf9a0110fdd39a011a2d...",
+//	    "seq_in_channel": "3"
+//	}
+type MessageAudited struct {
+	AuditID      string    `json:"audit_id"`
+	MessageID    string    `json:"message_id"` // 审核通过才有
+	GuildID      string    `json:"guild_id"`
+	ChannelID    string    `json:"channel_id"`
+	AuditTime    Timestamp `json:"audit_time"`
+	CreateTime   Timestamp `json:"create_time"`
+	SeqInChannel string    `json:"seq_in_channel"`
+}
+
+// AuditResult 消息审核的最终结果，Err 为 nil 表示审核通过
+type AuditResult struct {
+	AuditID   string
+	MessageID string // 审核通过之后的消息ID
+	Event     *MessageAudited
+	Err       error // ErrAuditRejected 或 ErrAuditTimeout
+}
+
+type AuditCallback func(AuditResult)
+
+type pendingAudit struct {
+	callback AuditCallback
+	result   *AuditResult // 审核事件先于注册到达时，暂存结果
+	timer    *time.Timer
+}
+
+// AuditTracker 关联发送消息时返回的 MessageAuditError 与后续的审核事件
+type AuditTracker struct {
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pendingAudit
+}
+
+// NewAuditTracker 创建审核跟踪，超过 timeout 没有收到审核事件，按 ErrAuditTimeout 结束
+func NewAuditTracker(timeout time.Duration) *AuditTracker {
+	return &AuditTracker{
+		timeout: timeout,
+		pending: make(map[string]*pendingAudit),
+	}
+}
+
+// Register 注册审核事件的处理函数，需要同时设置 IntentMessageAudit
+func (t *AuditTracker) Register(api *API) {
+	if api.Handlers == nil {
+		api.Handlers = make(map[string]EventHandler)
+	}
+	api.Handlers[EventMessageAuditPass] = t.HandleEvent
+	api.Handlers[EventMessageAuditReject] = t.HandleEvent
+	api.Intents |= IntentMessageAudit
+}
+
+// Track 跟踪审核ID，审核结束或超时后调用 callback，callback 只会被调用一次
+func (t *AuditTracker) Track(auditID string, callback AuditCallback) {
+	t.mu.Lock()
+	p, ok := t.pending[auditID]
+	if ok && p.result != nil {
+		// 审核事件已经先到了
+		delete(t.pending, auditID)
+		p.timer.Stop()
+		t.mu.Unlock()
+		callback(*p.result)
+		return
+	}
+	if ok {
+		// 重复注册，以最后一次为准
+		p.callback = callback
+		t.mu.Unlock()
+		return
+	}
+	p = &pendingAudit{callback: callback}
+	p.timer = time.AfterFunc(t.timeout, func() {
+		t.resolve(AuditResult{AuditID: auditID, Err: ErrAuditTimeout}, false)
+	})
+	t.pending[auditID] = p
+	t.mu.Unlock()
+}
+
+// Wait 跟踪审核ID，返回只会写入一次结果的 channel
+func (t *AuditTracker) Wait(auditID string) <-chan AuditResult {
+	ch := make(chan AuditResult, 1)
+	t.Track(auditID, func(r AuditResult) {
+		ch <- r
+	})
+	return ch
+}
+
+// TrackError 如果 err 是 MessageAuditError 则开始跟踪，返回是否在跟踪
+func (t *AuditTracker) TrackError(err error, callback AuditCallback) bool {
+	var auditErr *MessageAuditError
+	if !errors.As(err, &auditErr) {
+		return false
+	}
+	t.Track(auditErr.AuditID, callback)
+	return true
+}
+
+// HandleEvent 处理 MESSAGE_AUDIT_PASS 与 MESSAGE_AUDIT_REJECT 事件
+func (t *AuditTracker) HandleEvent(wm WsMessage) {
+	var event MessageAudited
+	if err := json.Unmarshal(wm.Data, &event); err != nil {
+		DefaultLogger.Warn("audit_event", "event", wm.Type, "err", err)
+		return
+	}
+
+	result := AuditResult{
+		AuditID: event.AuditID,
+		Event:   &event,
+	}
+	switch wm.Type {
+	case EventMessageAuditPass:
+		result.MessageID = event.MessageID
+	case EventMessageAuditReject:
+		result.Err = ErrAuditRejected
+	default:
+		return
+	}
+	t.resolve(result, true)
+}
+
+// resolve 结束审核，early 为 true 时，如果还没有注册则暂存结果等待注册
+func (t *AuditTracker) resolve(result AuditResult, early bool) {
+	t.mu.Lock()
+	p, ok := t.pending[result.AuditID]
+	if !ok {
+		if early {
+			p = &pendingAudit{result: &result}
+			p.timer = time.AfterFunc(t.timeout, func() {
+				t.mu.Lock()
+				delete(t.pending, result.AuditID)
+				t.mu.Unlock()
+			})
+			t.pending[result.AuditID] = p
+		}
+		t.mu.Unlock()
+		return
+	}
+	if p.callback == nil {
+		// 重复的审核事件，覆盖暂存的结果
+		p.result = &result
+		t.mu.Unlock()
+		return
+	}
+	delete(t.pending, result.AuditID)
+	p.timer.Stop()
+	t.mu.Unlock()
+
+	p.callback(result)
+}
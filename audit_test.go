@@ -0,0 +1,54 @@
+package sgroupbot_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"sgroupbot"
+	"testing"
+	"time"
+)
+
+func auditEvent(t string, auditID, messageID string) sgroupbot.WsMessage {
+	var wm sgroupbot.WsMessage
+	wm.Type = t
+	wm.Data = json.RawMessage(fmt.Sprintf(`{"audit_id":%q,"message_id":%q,"channel_id":"1419773"}`, auditID, messageID))
+	return wm
+}
+
+func TestAuditTracker(t *testing.T) {
+	tracker := sgroupbot.NewAuditTracker(50 * time.Millisecond)
+
+	t.Run("pass", func(t *testing.T) {
+		ch := tracker.Wait("a1")
+		tracker.HandleEvent(auditEvent(sgroupbot.EventMessageAuditPass, "a1", "m1"))
+		r := <-ch
+		if r.Err != nil || r.MessageID != "m1" {
+			t.Errorf("result: %+v", r)
+		}
+	})
+
+	t.Run("reject before track", func(t *testing.T) {
+		tracker.HandleEvent(auditEvent(sgroupbot.EventMessageAuditReject, "a2", ""))
+		err := &sgroupbot.MessageAuditError{AuditID: "a2"}
+		var got sgroupbot.AuditResult
+		if !tracker.TrackError(fmt.Errorf("send: %w", err), func(r sgroupbot.AuditResult) { got = r }) {
+			t.Fatal("audit error not tracked")
+		}
+		if got.Err != sgroupbot.ErrAuditRejected || got.Event.ChannelID != "1419773" {
+			t.Errorf("result: %+v", got)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		r := <-tracker.Wait("a3")
+		if r.Err != sgroupbot.ErrAuditTimeout {
+			t.Errorf("result: %+v", r)
+		}
+		// 超时之后的审核事件被忽略
+		tracker.HandleEvent(auditEvent(sgroupbot.EventMessageAuditPass, "a3", "m3"))
+	})
+
+	if tracker.TrackError(fmt.Errorf("code: 1, msg: x"), nil) {
+		t.Error("plain error should not be tracked")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// walkLimit 随机接龙最多接多少个成语
+const walkLimit = 200
+
+// runAnalyze 分析成语库的接龙图并输出报告
+func runAnalyze(args []string) error {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	top := fs.Int("top", 10, "列出最难接的成语数量")
+	samples := fs.Int("samples", 1000, "随机接龙的次数，用于估计接龙的长度")
+	cfg, err := LoadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: analyze [-top n] [-samples n] [-config file]")
+	}
+	// 分析不连接平台，不需要凭证
+	if err := cfg.validate(false); err != nil {
+		return err
+	}
+
+	idioms, err := LoadIdioms(cfg.IdiomsPath)
+	if err != nil {
+		return err
+	}
+	is := NewIdiomsSolitaire(idioms, cfg.Game.ExpiredTime)
+	is.SetRules(cfg.Game.MaxTurn, cfg.Game.MaxMiss)
+
+	seed := cfg.Game.Seed
+	if seed == 0 {
+		seed = defaultSeed()
+	}
+	is.Report(os.Stdout, *top, *samples, rand.New(rand.NewSource(seed)))
+	return nil
+}
+
+// Report 输出接龙图的报告：整体统计、随机接龙的长度分布、最难接的成语与死胡同
+func (is *IdiomsSolitaire) Report(w io.Writer, top, samples int, rnd *rand.Rand) {
+	g := is.graph
+	sum := g.Summary()
+	fmt.Fprintf(w, "成语: %d，首尾字: %d，接龙关系: %d\n", sum.Idioms, sum.Chars, sum.Edges)
+	fmt.Fprintf(w, "死胡同: %d (%.1f%%)\n", sum.DeadEnds, percent(sum.DeadEnds, sum.Idioms))
+	fmt.Fprintf(w, "包含环的强连通分量: %d，最大的包含 %d 个成语\n", sum.Components, sum.LargestComponent)
+	fmt.Fprintf(w, "可以完成 %d 轮的开局: %d\n", is.maxTurn, len(is.openings))
+
+	if samples > 0 && len(is.openings) > 0 {
+		lengths := make([]int, samples)
+		for i := range lengths {
+			lengths[i] = is.randomWalk(is.openings[rnd.Intn(len(is.openings))], rnd)
+		}
+		sort.Ints(lengths)
+		fmt.Fprintf(w, "随机接龙长度 (%d 次): 中位数 %d，P90 %d，最长 %d\n",
+			samples, lengths[samples/2], lengths[samples*9/10], lengths[samples-1])
+	}
+
+	var hard, dead []string
+	for word, s := range g.stats {
+		if s.DeadEnd {
+			dead = append(dead, word)
+		} else {
+			hard = append(hard, word)
+		}
+	}
+	sort.Slice(hard, func(i, j int) bool {
+		a, b := g.stats[hard[i]], g.stats[hard[j]]
+		if a.Difficulty != b.Difficulty {
+			return a.Difficulty > b.Difficulty
+		}
+		return hard[i] < hard[j]
+	})
+	sort.Strings(dead)
+
+	fmt.Fprintln(w, "最难接的成语:")
+	for _, word := range hard[:minInt(top, len(hard))] {
+		s := g.stats[word]
+		fmt.Fprintf(w, "  %s\t出度 %d\t可达 %d\t难度 %.2f\n", word, s.OutDegree, s.Reach, s.Difficulty)
+	}
+	fmt.Fprintln(w, "死胡同:")
+	for _, word := range dead[:minInt(top, len(dead))] {
+		fmt.Fprintf(w, "  %s\n", word)
+	}
+}
+
+// randomWalk 从 start 开始随机不重复地接龙，返回接上的成语数量
+func (is *IdiomsSolitaire) randomWalk(start string, rnd *rand.Rand) int {
+	used := map[string]struct{}{start: {}}
+	word := start
+	for n := 0; n < walkLimit; n++ {
+		var next []string
+		for _, w := range is.dict[is.idioms[word]] {
+			if _, ok := used[w]; !ok {
+				next = append(next, w)
+			}
+		}
+		if len(next) == 0 {
+			return n
+		}
+		word = next[rnd.Intn(len(next))]
+		used[word] = struct{}{}
+	}
+	return walkLimit
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -4,24 +4,58 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"sgroupbot"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/panjf2000/ants/v2"
 )
 
 type ApiServer struct {
-	api  *sgroupbot.API
-	is   *IdiomsSolitaire
-	pool *ants.PoolWithFunc
+	api   *sgroupbot.API
+	is    *IdiomsSolitaire
+	pool  *ants.PoolWithFunc
+	audit *sgroupbot.AuditTracker
+	subs  *sgroupbot.Subscriptions
+
+	// 默认使用 api 的 Logger，会隐藏 token
+	logger sgroupbot.Logger
+
+	// 可选的指标，通过 EnableMetrics 开启
+	metrics *serverMetrics
+
+	// 用户的长期战绩，默认保存在内存中
+	stats *Stats
+
+	// 按发送对象限制下行消息的频率，为空时不限制
+	limiter *rateLimiter
+
+	// 清理过期会话的周期，为 0 时不清理
+	janitorInterval time.Duration
+
+	// 是否在成语接龙的消息中附带“提示/跳过/答案/退出”按钮，需要开通 markdown 与按钮权限
+	keyboard bool
+
+	// 结算消息的格式
+	settlement SettlementFormat
+
+	// 消息中的昵称与用户名，结算时展示
+	names *nameCache
+
+	// 同步处理消息，不投递到线程池，回放时保证处理顺序
+	sync bool
 }
 
 func NewApiServer(api *sgroupbot.API, is *IdiomsSolitaire) *ApiServer {
 	s := &ApiServer{
-		api: api,
-		is:  is,
+		api:    api,
+		is:     is,
+		logger: api.Log(),
+		stats:  NewStats(NewMemoryStatsRepository()),
+
+		settlement: SettlementText,
+		names:      newNameCache(),
 	}
 
 	// 注册消息函数
@@ -32,6 +66,25 @@ func NewApiServer(api *sgroupbot.API, is *IdiomsSolitaire) *ApiServer {
 	api.Handlers[sgroupbot.EventDirectMessageCreate] = s.HandleMessage
 	api.Handlers[sgroupbot.EventAtMessageCreate] = s.HandleMessage
 
+	// 按钮回调，与文字指令走相同的逻辑
+	router := sgroupbot.NewInteractionRouter()
+	router.Handle("提示", s.HandleButton)
+	router.Handle("跳过", s.HandleButton)
+	router.Handle("答案", s.HandleButton)
+	router.Handle("退出", s.HandleButton)
+	api.Handlers[sgroupbot.EventInteractionCreate] = api.HandleInteraction(router.Route)
+	api.Intents |= sgroupbot.IntentInteraction
+
+	// 记录群与用户的主动推送状态，新加入的群发送使用说明
+	s.subs = sgroupbot.NewSubscriptions()
+	s.subs.Register(api)
+	api.Handlers[sgroupbot.EventGroupAddRobot] = s.HandleGroupAdd
+	api.Handlers[sgroupbot.EventFriendAdd] = s.HandleFriendAdd
+
+	// 跟踪被审核的消息
+	s.audit = sgroupbot.NewAuditTracker(5 * time.Minute)
+	s.audit.Register(api)
+
 	s.pool, _ = ants.NewPoolWithFunc(128, func(i interface{}) {
 		if msg, ok := i.(Message); ok {
 			s.handleMessage(msg)
@@ -41,8 +94,37 @@ func NewApiServer(api *sgroupbot.API, is *IdiomsSolitaire) *ApiServer {
 	return s
 }
 
+// Configure 应用配置中的订阅事件、线程池大小、限流与按钮
+func (s *ApiServer) Configure(cfg *Config) {
+	s.api.Intents |= cfg.Intents
+	s.pool.Tune(cfg.PoolSize)
+	s.keyboard = cfg.Keyboard
+	s.settlement, _ = parseSettlementFormat(cfg.Settlement) // 已经通过 Validate 检查
+	s.janitorInterval = time.Duration(cfg.Game.JanitorInterval) * time.Second
+	s.limiter = nil
+	if cfg.RateLimit > 0 {
+		s.limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	}
+}
+
+// SetStats 设置战绩的存储，需要在处理消息之前设置
+func (s *ApiServer) SetStats(stats *Stats) {
+	s.stats = stats
+}
+
 type MessageSender func(string, sgroupbot.CreateMessageRequest) error
 
+// limited 发送前按发送对象限流，频道的限制是每个子频道每秒 5 条
+func (s *ApiServer) limited(send MessageSender) MessageSender {
+	if s.limiter == nil {
+		return send
+	}
+	return func(to string, msg sgroupbot.CreateMessageRequest) error {
+		s.limiter.Wait(to)
+		return send(to, msg)
+	}
+}
+
 func (s *ApiServer) handleMessage(msg Message) {
 	var to string
 	var sendMsg MessageSender
@@ -58,106 +140,490 @@ func (s *ApiServer) handleMessage(msg Message) {
 		sendMsg = s.api.CreateUserMessage
 	case sgroupbot.EventAtMessageCreate: // 频道 at 消息
 		to = msg.ChannelID
-		userID = msg.Author.Username
+		userID = msg.Author.ID
 		sendMsg = s.api.CreateChannelMessage
-		atPrefix := fmt.Sprintf("<@!%s>", s.api.BotID)
-		if strings.HasPrefix(msg.Content, atPrefix) {
-			msg.Content = msg.Content[len(atPrefix)+1:]
-		}
 	case sgroupbot.EventDirectMessageCreate: // 频道私聊
 		to = msg.GuildID
-		userID = msg.Author.Username
+		userID = msg.Author.ID
 		sendMsg = s.api.CreateDirectMessage
 	default:
 		// fmt.Println("drop", msg.MsgType)
 		// 不符合的消息类型，丢弃
 		return
 	}
+	sendMsg = s.limited(sendMsg)
+	s.names.put(userID, msg.DisplayName(), time.Now())
+
+	// 每条消息附带事件类型、回复对象与消息ID，便于串联同一条消息的日志
+	traceID := msg.ID
+	if len(traceID) == 0 {
+		traceID = msg.EventID
+	}
+	logger := sgroupbot.WithFields(s.logger, "event", msg.MsgType, "target", to, "trace_id", traceID)
 
-	// 默认重复接收到的内容
+	// 去掉消息中@机器人的部分
+	content := sgroupbot.ParseContent(msg.Content).PlainText(s.api.BotID)
+	logger.Debug("message_received", "user", userID, "content", content)
+
+	// 默认重复接收到的内容，转义之后发送，避免用户借机器人发出@全体成员等内嵌格式
 	var rspMsg sgroupbot.CreateMessageRequest
-	rspMsg.Content = msg.Content
+	rspMsg.Content = sgroupbot.EscapeContent(content)
 	rspMsg.MsgType = sgroupbot.MsgTypeText
 	rspMsg.MsgID = msg.ID
+	rspMsg.EventID = msg.EventID
 
-	// 处理成语接龙的逻辑
-	key := to
-	if msg.MsgType == sgroupbot.EventDirectMessageCreate {
-		key = msg.Author.UserOpenID
+	// 频道消息引用用户的原始消息，按钮转换的消息没有原始消息
+	kind := sgroupbot.TargetKindOf(msg.MsgType)
+	if kind == sgroupbot.TargetChannel && len(msg.ID) > 0 {
+		rspMsg.MessageReference = &sgroupbot.MessageReference{
+			MessageID:             msg.ID,
+			IgnoreGetMessageError: true,
+		}
 	}
 
-	content := strings.TrimSpace(msg.Content)
-	switch content {
-	case "成语接龙": // 进入情景
-		if ss, loaded := s.is.SessionOrCreate(key); loaded {
-			rspMsg.Content = "成语接龙正在进行中，想想这个成语怎么接，" + ss.Idiom()
+	// 处理成语接龙的逻辑，会话按回复的对象区分，频道私信为私信会话的 guild_id，每个用户各不相同
+	key := to
+
+	opts, start := parseStart(content)
+	switch {
+	case start: // 进入情景
+		opts.Kind, opts.To, opts.Guild = kind, to, msg.GuildID
+		if ss, loaded := s.is.SessionOrCreateWith(key, opts); loaded {
+			rspMsg.Content = ss.Mode().Name() + "正在进行中，想想这个成语怎么接，" + ss.Idiom()
 		} else {
-			log.Println("solitaire_create", key, ss.current, ss.lastRune)
-			rspMsg.Content = "成语接龙开始了哦，想想这个成语怎么接，" + ss.Idiom()
+			logger.Info("solitaire_create", "key", key, "idiom", ss.current, "mode", ss.Mode().Name(), "strategy", s.is.strategyFor(ss).Name())
+			if s.metrics != nil {
+				s.metrics.games.Inc()
+			}
+			rspMsg.Content = ss.Mode().Name() + "开始了哦，想想这个成语怎么接，" + ss.Idiom()
+		}
+		s.withKeyboard(&rspMsg)
+	case content == "提示": // 分阶段提示一个接法
+		if ss := s.is.Session(key); ss != nil {
+			switch ret, hint := s.is.Hint(ss, userID); ret {
+			case SolitaireSucceed:
+				rspMsg.Content = "可以试试这个，" + hint
+			case SolitaireTimeout:
+				s.settleTimeout(&rspMsg, ss, logger)
+			case SolitaireCanceled, SolitaireConflict, SolitaireStoreError:
+				rspMsg.Content = unsavedText(ret)
+			default:
+				rspMsg.Content = "我也想不出来了"
+			}
+		}
+	case content == "答案": // 列出可以接上的成语
+		if ss := s.is.Session(key); ss != nil {
+			ret, answers := s.is.Answers(ss, userID)
+			switch {
+			case ret == SolitaireTimeout:
+				s.settleTimeout(&rspMsg, ss, logger)
+			case ret != SolitaireSucceed:
+				rspMsg.Content = unsavedText(ret)
+			case len(answers) > 0:
+				rspMsg.Content = "这些都可以接，" + strings.Join(answers, "、")
+			default:
+				rspMsg.Content = "我也想不出来了"
+			}
 		}
-	case "退出": // 退出情景
-		if _, exists := s.is.SessionAndDelete(key); exists {
+	case content == "跳过": // 放弃这一轮
+		if ss := s.is.Session(key); ss != nil {
+			ret, next := s.is.Skip(ss, userID)
+			logger.Info("solitaire_skip", "key", key, "user", userID, "ret", ret, "next", next)
+			if s.metrics != nil {
+				s.metrics.observeResult(ret)
+			}
+			var settle bool
+			switch ret {
+			case SolitaireSkipped:
+				rspMsg.Content = "好吧，可以接这个，" + next + "，我们继续"
+			case SolitaireFailComplete:
+				rspMsg.Content = "接龙结束了，最后一个词可以接这个，" + next
+				settle = true
+			case SolitaireEnd:
+				rspMsg.Content = "这个词谁也接不上了，接龙结束"
+				settle = true
+			case SolitaireTimeout:
+				rspMsg.Content = timeoutText
+				settle = true
+			case SolitaireCanceled, SolitaireConflict, SolitaireStoreError:
+				rspMsg.Content = unsavedText(ret)
+			}
+			if settle {
+				s.settle(&rspMsg, ss)
+				s.recordGame(ss, logger)
+			}
+		}
+	case content == "退出": // 退出情景
+		if ss, exists := s.is.SessionAndDelete(key); exists {
 			// 退出，输出结算
 			// ss.Hits()
 			rspMsg.Content = "成语接龙已结束"
-			log.Println("solitaire_end", key, userID)
+			logger.Info("solitaire_end", "key", key, "user", userID)
+			s.recordGame(ss, logger)
 		}
+	case isStatsCommand(content): // 查询战绩与排行榜
+		rspMsg.Content = s.statsReply(content, kind, to, msg.GuildID, userID)
 	default: // 接龙
-		if ss := s.is.Session(key); ss != nil {
+		if ss := s.is.Session(key); ss != nil && len(content) == 0 && len(msg.Images()) > 0 {
+			// 只发了图片，提示用文字作答
+			rspMsg.Content = "暂时看不懂图片哦，请用文字回答，" + ss.Idiom()
+		} else if ss != nil {
 			// 1. 接龙失败，返回提示
 			// 2. 多次接龙失败，直接进入到下一轮，返回新的词
 			// 3. 接龙成功，进入下一轮，返回新的词
-			// 4. 接龙成功，接不下去了，直接结束，返回结算数据
+			// 4. 接龙成功，或答错次数用完后接不下去了，直接结束，返回结算数据
 			// 5. 接龙完成，会话结束，返回结算数据
 			// 6. 会话已过期，或被其它人退出了
+			// 7. 更新冲突或存储出错，没有保存，提示重试
 			ret, next := s.is.Solitaire(ss, content, userID)
-			log.Println("solitaire_result", key, content, userID, ret, next)
+			logger.Info("solitaire_result", "key", key, "user", userID, "ret", ret, "next", next)
+			if s.metrics != nil {
+				s.metrics.observeResult(ret)
+			}
 			var settle bool
 			switch ret {
 			case SolitaireFailed:
 				rspMsg.Content = "不是这个词哦，再想想"
+			case SolitaireRepeated:
+				rspMsg.Content = "这个成语已经用过了，换一个吧"
 			case SolitaireFailedToNext:
 				rspMsg.Content = "还是不对哦，让我告诉你吧，" + next
 			case SolitaireSucceed:
-				rspMsg.Content = "你答对了，我接这个词，" + next
+				rspMsg.Content = s.mention(kind, msg) + "你答对了，我接这个词，" + next
+				if s.markCorrect(kind, msg) {
+					rspMsg.Content = "我接这个词，" + next
+				}
 			case SolitaireEnd: // 输出结算
-				rspMsg.Content = "你真厉害，我接不上来了，接龙结束"
+				rspMsg.Content = s.mention(kind, msg) + "你真厉害，我接不上来了，接龙结束"
+				if s.is.outOfMisses(ss) { // 答错次数用完，但这个词谁也接不上了
+					rspMsg.Content = "还是不对哦，这个词谁也接不上了，接龙结束"
+				}
 				settle = true
 			case SolitaireCompleted: // 输出结算
-				rspMsg.Content = "你真厉害，全部完成了哦"
+				rspMsg.Content = s.mention(kind, msg) + "你真厉害，全部完成了哦"
 				settle = true
 			case SolitaireFailComplete: // 输出结算
 				rspMsg.Content = "接龙结束了，最后一个词可以接这个，" + next
 				settle = true
+			case SolitaireTimeout: // 清理之前会话已经过期，输出结算
+				rspMsg.Content = timeoutText
+				settle = true
+			case SolitaireCanceled, SolitaireConflict, SolitaireStoreError: // 回答没有保存，不能重复用户的内容
+				rspMsg.Content = unsavedText(ret)
 			default:
 			}
 
 			if settle {
-				if hits := ss.Hits(); len(hits) > 0 && hits[0].Count > 0 {
-					var sb strings.Builder
-					sb.WriteString(rspMsg.Content)
-					sb.WriteByte('\n')
-					sb.WriteString("接龙排行\n")
-					for i := 0; i < len(hits) && i < 3 && hits[i].Count > 0; i++ {
-						sb.WriteString(hits[i].Name)
-						sb.WriteByte(' ')
-						sb.WriteString(strconv.Itoa(hits[i].Count))
-						sb.WriteByte('\n')
-					}
-					rspMsg.Content = sb.String()
-				}
+				s.settle(&rspMsg, ss)
+				s.recordGame(ss, logger)
 			}
 		}
 	}
 
 	// 发送消息
 	if err := sendMsg(to, rspMsg); err != nil {
-		fmt.Println("sendMsg", err)
+		if s.audit.TrackError(err, func(r sgroupbot.AuditResult) {
+			logger.Info("sendMsg_audit", "audit_id", r.AuditID, "message_id", r.MessageID, "err", r.Err)
+			if r.Err == sgroupbot.ErrAuditRejected {
+				// 审核不通过，告知用户
+				var notice sgroupbot.CreateMessageRequest
+				notice.Content = "消息未通过审核，换个说法试试吧"
+				notice.MsgType = sgroupbot.MsgTypeText
+				notice.MsgID = msg.ID
+				notice.MessageReference = rspMsg.MessageReference
+				if err := sendMsg(to, notice); err != nil {
+					logger.Warn("sendMsg_notice", "err", err)
+				}
+			}
+		}) {
+			return
+		}
+		logger.Warn("sendMsg", "err", err)
+	}
+}
+
+// parseStart 解析开始接龙的指令，指令之后可以附带难度，如“成语接龙 困难”
+func parseStart(content string) (SessionOptions, bool) {
+	var opts SessionOptions
+	fields := strings.Fields(content)
+	if len(fields) == 0 || len(fields) > 2 {
+		return opts, false
+	}
+	mode, ok := chainCommands[fields[0]]
+	if !ok {
+		return opts, false
+	}
+	opts.Mode = mode
+	if len(fields) == 2 {
+		name, ok := strategyAliases[fields[1]]
+		if !ok {
+			return opts, false
+		}
+		opts.Strategy, _ = NewStrategy(name, defaultSeed())
+	}
+	return opts, true
+}
+
+const timeoutText = "接龙超时结束"
+
+// unsavedText 操作没有保存到会话时的回复：会话已经被退出或结束，或者更新冲突、存储出错需要重试
+func unsavedText(ret int) string {
+	if ret == SolitaireCanceled {
+		return "接龙会话已结束"
+	}
+	return "刚才没有记下来，请重试"
+}
+
+// sender 返回发送对象对应的发送函数
+func (s *ApiServer) sender(kind sgroupbot.TargetKind) MessageSender {
+	switch kind {
+	case sgroupbot.TargetGroup:
+		return s.api.CreateGroupMessage
+	case sgroupbot.TargetUser:
+		return s.api.CreateUserMessage
+	case sgroupbot.TargetDirect:
+		return s.api.CreateDirectMessage
+	default:
+		return s.api.CreateChannelMessage
 	}
 }
 
+// RunJanitor 每隔 interval 清理过期的会话，并向会话所在的聊天推送结算，ctx 取消后返回
+func (s *ApiServer) RunJanitor(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.sweep()
+		}
+	}
+}
+
+// sweep 清理一次过期的会话
+func (s *ApiServer) sweep() {
+	for _, ss := range s.is.ClearExpiredSession() {
+		if s.metrics != nil {
+			s.metrics.observeResult(SolitaireTimeout)
+		}
+		kind, to := ss.Conversation()
+		logger := sgroupbot.WithFields(s.logger, "target", to, "key", ss.key)
+		logger.Info("solitaire_timeout")
+		s.recordGame(ss, logger)
+		// 超时推送的是主动消息，群与用户关闭主动消息之后不再推送
+		if len(to) == 0 || !s.canPush(kind, to) {
+			continue
+		}
+
+		var msg sgroupbot.CreateMessageRequest
+		msg.Content = timeoutText
+		msg.MsgType = sgroupbot.MsgTypeText
+		s.settle(&msg, ss)
+		if err := s.limited(s.sender(kind))(to, msg); err != nil {
+			logger.Warn("sendMsg_timeout", "err", err)
+		}
+	}
+	if s.limiter != nil {
+		s.limiter.cleanup(time.Now())
+	}
+	s.names.cleanup(time.Now())
+}
+
+// settleTimeout 提示或查看答案时发现会话已经超时，回复超时并输出结算
+func (s *ApiServer) settleTimeout(msg *sgroupbot.CreateMessageRequest, ss *Session, logger sgroupbot.Logger) {
+	msg.Content = timeoutText
+	s.settle(msg, ss)
+	s.recordGame(ss, logger)
+}
+
+// recordGame 记录结束的对局
+func (s *ApiServer) recordGame(ss *Session, logger sgroupbot.Logger) {
+	if err := s.stats.Record(ss); err != nil {
+		logger.Warn("stats_record", "key", ss.key, "err", err)
+	}
+}
+
+// isStatsCommand 是否为查询战绩或排行榜的指令
+func isStatsCommand(content string) bool {
+	fields := strings.Fields(content)
+	return len(fields) > 0 && (fields[0] == "我的战绩" || fields[0] == "排行榜")
+}
+
+// statsReply 回复“我的战绩”与“排行榜”，默认查询当前聊天的全部战绩
+func (s *ApiServer) statsReply(content string, kind sgroupbot.TargetKind, to, guild, userID string) string {
+	fields := strings.Fields(content)
+	q, ok := parseStatsQuery(fields[1:])
+	if !ok {
+		return "可以这样查：" + fields[0] + " 本周 全局，时间可选 今日、本周、全部，范围可选 本群、频道、全局"
+	}
+	scope := Scope{Kind: q.scope}
+	switch q.scope {
+	case ScopeConversation:
+		scope.Target, scope.ID = kind, to
+	case ScopeGuild:
+		scope.ID = guild
+	}
+	title := fields[0] + "（" + scopeName(q.scope, kind) + "·" + q.window.Name() + "）"
+
+	if fields[0] == "我的战绩" {
+		p, err := s.stats.Player(scope, q.window, userID)
+		if err != nil {
+			s.logger.Warn("stats_query", "target", to, "err", err)
+		}
+		if p.Games == 0 {
+			return "还没有战绩哦，发送“成语接龙”开始游戏吧"
+		}
+		return title + "\n" + formatPlayerStats(p)
+	}
+
+	list, err := s.stats.Leaderboard(scope, q.window, leaderboardSize)
+	if err != nil {
+		s.logger.Warn("stats_query", "target", to, "err", err)
+	}
+	if len(list) == 0 {
+		return "排行榜还是空的哦"
+	}
+	var sb strings.Builder
+	sb.WriteString(title)
+	for i, p := range list {
+		fmt.Fprintf(&sb, "\n%d. %s 答对%d次 胜率%.0f%%", i+1, s.displayName(kind, guild, p.ID), p.Correct, p.WinRate()*100)
+	}
+	return sb.String()
+}
+
+const usageText = `你好，我是成语接龙机器人
+@我并发送“成语接龙”开始游戏，我会先出一个成语，@我接上它就可以了
+发送“同音接龙”或“同音同调接龙”，首字读音与上一个成语的尾字相同也可以接上
+指令之后可以附带难度：简单、困难、对抗，如“成语接龙 困难”
+游戏中发送“提示”获取提示，发送“答案”查看接法，发送“跳过”进入下一轮，使用后会扣分
+发送“退出”结束游戏
+发送“我的战绩”或“排行榜”查看战绩，可以附带 今日、本周、全局，如“排行榜 本周 全局”`
+
+// HandleGroupAdd 机器人被添加到群聊，发送使用说明
+func (s *ApiServer) HandleGroupAdd(wm sgroupbot.WsMessage) {
+	s.subs.HandleEvent(wm)
+
+	var event sgroupbot.GroupEvent
+	if err := json.Unmarshal(wm.Data, &event); err != nil {
+		s.logger.Warn("unmarshal", "event", wm.Type, "trace_id", wm.ID, "err", err)
+		return
+	}
+	if !s.canPush(sgroupbot.TargetGroup, event.GroupOpenID) {
+		return
+	}
+
+	var msg sgroupbot.CreateMessageRequest
+	msg.Content = usageText
+	msg.MsgType = sgroupbot.MsgTypeText
+	msg.EventID = wm.ID
+	if err := s.limited(s.api.CreateGroupMessage)(event.GroupOpenID, msg); err != nil {
+		s.logger.Warn("sendMsg_usage", "event", wm.Type, "target", event.GroupOpenID, "trace_id", wm.ID, "err", err)
+	}
+}
+
+// HandleFriendAdd 用户添加机器人，发送使用说明
+func (s *ApiServer) HandleFriendAdd(wm sgroupbot.WsMessage) {
+	s.subs.HandleEvent(wm)
+
+	var event sgroupbot.FriendEvent
+	if err := json.Unmarshal(wm.Data, &event); err != nil {
+		s.logger.Warn("unmarshal", "event", wm.Type, "trace_id", wm.ID, "err", err)
+		return
+	}
+	if !s.canPush(sgroupbot.TargetUser, event.OpenID) {
+		return
+	}
+
+	var msg sgroupbot.CreateMessageRequest
+	msg.Content = usageText
+	msg.MsgType = sgroupbot.MsgTypeText
+	msg.EventID = wm.ID
+	if err := s.limited(s.api.CreateUserMessage)(event.OpenID, msg); err != nil {
+		s.logger.Warn("sendMsg_usage", "event", wm.Type, "target", event.OpenID, "trace_id", wm.ID, "err", err)
+	}
+}
+
+// canPush 是否可以主动推送消息，群管理员或用户关闭了主动消息之后不再推送
+func (s *ApiServer) canPush(kind sgroupbot.TargetKind, to string) bool {
+	switch kind {
+	case sgroupbot.TargetGroup:
+		return s.subs.GroupAllowed(to)
+	case sgroupbot.TargetUser:
+		return s.subs.UserAllowed(to)
+	default:
+		return true
+	}
+}
+
+// withKeyboard 将文本消息转换为带“提示/跳过/答案/退出”按钮的 markdown 消息
+func (s *ApiServer) withKeyboard(msg *sgroupbot.CreateMessageRequest) {
+	if !s.keyboard {
+		return
+	}
+	msg.MsgType = sgroupbot.MsgTypeMarkdown
+	msg.Markdown = &sgroupbot.Markdown{Content: msg.Content}
+	msg.Content = ""
+	msg.Keyboard = sgroupbot.NewKeyboard([]sgroupbot.Button{
+		sgroupbot.NewCallbackButton("1", "提示", "提示"),
+		sgroupbot.NewCallbackButton("2", "跳过", "跳过"),
+		sgroupbot.NewCallbackButton("3", "答案", "答案"),
+		sgroupbot.NewCallbackButton("4", "退出", "退出"),
+	})
+}
+
+// HandleButton 将按钮回调转换为对应的文字指令处理
+func (s *ApiServer) HandleButton(i *sgroupbot.Interaction) int {
+	var msg Message
+	msg.EventID = i.ID
+	msg.Content = i.ButtonData()
+	switch i.Scene {
+	case sgroupbot.SceneGroup:
+		msg.MsgType = sgroupbot.EventGroupAtMessageCreate
+		msg.GroupOpenID = i.GroupOpenID
+		msg.Author.MemberOpenID = i.GroupMemberOpenID
+	case sgroupbot.SceneC2C:
+		msg.MsgType = sgroupbot.EventC2CMessageCreate
+		msg.Author.UserOpenID = i.UserOpenID
+	case sgroupbot.SceneGuild:
+		msg.MsgType = sgroupbot.EventAtMessageCreate
+		msg.GuildID = i.GuildID
+		msg.ChannelID = i.ChannelID
+		msg.Author.ID = i.OperatorID()
+	default:
+		return sgroupbot.InteractionFailed
+	}
+
+	// 尽快回应按钮，消息投递到线程池处理，回放时同步处理保证顺序
+	s.dispatch(msg)
+	return sgroupbot.InteractionOK
+}
+
+// markCorrect 频道中用 ✅ 表态标记答对的消息，成功后回复里不再重复提示答对了
+func (s *ApiServer) markCorrect(kind sgroupbot.TargetKind, msg Message) bool {
+	if kind != sgroupbot.TargetChannel || len(msg.ID) == 0 {
+		return false
+	}
+	emoji := sgroupbot.ReactionEmoji{ID: sgroupbot.EmojiCheckMark, Type: sgroupbot.EmojiTypeEmoji}
+	if err := s.api.PutReaction(msg.ChannelID, msg.ID, emoji); err != nil {
+		s.logger.Warn("put_reaction", "target", msg.ChannelID, "trace_id", msg.ID, "err", err)
+		return false
+	}
+	return true
+}
+
+// mention @答对的用户，只有频道支持，其它场景返回空
+func (s *ApiServer) mention(kind sgroupbot.TargetKind, msg Message) string {
+	if kind != sgroupbot.TargetChannel || len(msg.Author.ID) == 0 {
+		return ""
+	}
+	return sgroupbot.MentionUser(msg.Author.ID) + " "
+}
+
 type Message struct {
 	MsgType string
+	EventID string // 由互动事件转换的消息，使用事件ID回复
 	sgroupbot.Message
 }
 
@@ -168,19 +634,54 @@ func (s *ApiServer) HandleMessage(wm sgroupbot.WsMessage) {
 	var msg Message
 	msg.MsgType = wm.Type
 	if err := json.Unmarshal(wm.Data, &msg); err != nil {
-		log.Println("unmarsahl", err)
+		s.logger.Warn("unmarshal", "event", wm.Type, "trace_id", wm.ID, "err", err)
 		return
 	}
-	// 投递到线程池
+	s.dispatch(msg)
+}
+
+// dispatch 同步模式下直接处理，否则投递到线程池
+func (s *ApiServer) dispatch(msg Message) {
+	if s.sync {
+		s.handleMessage(msg)
+		return
+	}
+	s.invoke(msg)
+}
+
+// invoke 投递到线程池，线程池已满时同步执行
+func (s *ApiServer) invoke(msg Message) {
 	if err := s.pool.Invoke(msg); err != nil {
-		// 线程池已满，同步执行
+		if s.metrics != nil {
+			s.metrics.syncFallbacks.Inc()
+		}
 		s.handleMessage(msg)
 	}
 }
 
 func (s *ApiServer) Start() error {
-	if err := s.api.StartWs(context.Background()); err != nil {
-		return err
+	return s.Run(context.Background())
+}
+
+// Run 连接网关并定时清理过期的会话，ctx 取消后等待清理与线程池中的消息处理完成再返回
+func (s *ApiServer) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	if s.janitorInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.RunJanitor(ctx, s.janitorInterval)
+		}()
+	}
+
+	err := s.api.StartWs(ctx)
+	cancel()
+	wg.Wait()
+	if err := s.pool.ReleaseTimeout(5 * time.Second); err != nil {
+		s.logger.Warn("pool_release", "err", err)
 	}
-	return nil
+	return err
 }
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sgroupbot"
+	"sgroupbot/sgroupbottest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIdioms 构造一个小的成语库，其中 心想事成 -> 成千上万 -> 万众一心 成环，保证总能接下去
+func testIdioms() []Idiom {
+	words := []string{"一马当先", "先发制人", "人山人海", "海阔天空", "空前绝后", "后来居上", "上下一心", "心想事成", "成千上万", "万众一心"}
+	idioms := make([]Idiom, 0, len(words))
+	for _, w := range words {
+		runes := []rune(w)
+		idioms = append(idioms, Idiom{
+			Word:      w,
+			First:     string(runes[0]),
+			Last:      string(runes[len(runes)-1]),
+			FirstRune: runes[0],
+			LastRune:  runes[len(runes)-1],
+		})
+	}
+	return idioms
+}
+
+// answerFor 在测试成语库中找到一个可以接上 idiom 的成语
+func answerFor(idiom string) string {
+	runes := []rune(idiom)
+	last := runes[len(runes)-1]
+	for _, i := range testIdioms() {
+		if i.FirstRune == last && i.Word != idiom {
+			return i.Word
+		}
+	}
+	return ""
+}
+
+func startTestServer(t *testing.T) (*sgroupbottest.Server, *ApiServer) {
+	srv := sgroupbottest.NewServer()
+	t.Cleanup(srv.Close)
+
+	api := srv.API()
+	s := NewApiServer(api, NewIdiomsSolitaire(testIdioms(), 60))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go api.StartWs(ctx)
+	if err := srv.WaitReady(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return srv, s
+}
+
+// say 以单聊用户的身份给机器人发消息，返回机器人的回复
+func say(t *testing.T, srv *sgroupbottest.Server, content string) string {
+	t.Helper()
+	msg := map[string]interface{}{
+		"author":    map[string]string{"user_openid": "U1"},
+		"content":   content,
+		"id":        "ROBOT1.0_test",
+		"timestamp": "2024-09-04T13:12:43+08:00",
+	}
+	if err := srv.Dispatch(sgroupbot.EventC2CMessageCreate, msg); err != nil {
+		t.Fatal(err)
+	}
+	req, err := srv.NextRequest(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Path != "/v2/users/U1/messages" {
+		t.Fatalf("unexpected request %s %s", req.Method, req.Path)
+	}
+	rsp, err := req.Message()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.MsgID != "ROBOT1.0_test" {
+		t.Errorf("reply should be passive, msg_id %q", rsp.MsgID)
+	}
+	return rsp.Content
+}
+
+func TestApiServerSolitaire(t *testing.T) {
+	srv, s := startTestServer(t)
+	reg := sgroupbot.NewRegistry()
+	s.EnableMetrics(reg)
+
+	rsp := say(t, srv, "成语接龙")
+	prefix := "成语接龙开始了哦，想想这个成语怎么接，"
+	if !strings.HasPrefix(rsp, prefix) {
+		t.Fatalf("start: %s", rsp)
+	}
+	current := strings.TrimPrefix(rsp, prefix)
+
+	if rsp := say(t, srv, "不是成语"); rsp != "不是这个词哦，再想想" {
+		t.Errorf("wrong answer: %s", rsp)
+	}
+
+	rsp = say(t, srv, answerFor(current))
+	if !strings.HasPrefix(rsp, "你答对了，我接这个词，") {
+		t.Errorf("right answer: %s", rsp)
+	}
+
+	if rsp := say(t, srv, "退出"); rsp != "成语接龙已结束" {
+		t.Errorf("quit: %s", rsp)
+	}
+
+	// 不在接龙中，重复用户的内容
+	if rsp := say(t, srv, "你好"); rsp != "你好" {
+		t.Errorf("echo: %s", rsp)
+	}
+	// 重复的内容转义后发送，不能借机器人@全体成员
+	for _, content := range []string{"<@everyone>", "&lt;@everyone&gt;"} {
+		if rsp := say(t, srv, content); rsp != "&lt;@everyone&gt;" {
+			t.Errorf("echo %s: %s", content, rsp)
+		}
+	}
+
+	var sb strings.Builder
+	reg.WriteTo(&sb)
+	for _, want := range []string{
+		"sgroupbot_solitaire_games_total 1\n",
+		"sgroupbot_solitaire_rounds_total 1\n",
+		`sgroupbot_solitaire_results_total{result="failed"} 1`,
+		`sgroupbot_solitaire_results_total{result="succeed"} 1`,
+		"sgroupbot_solitaire_sessions 0\n",
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, sb.String())
+		}
+	}
+}
+
+func TestApiServerAssist(t *testing.T) {
+	srv, s := startTestServer(t)
+	s.is.SetRules(2, 3)
+
+	rsp := say(t, srv, "成语接龙")
+	current := strings.TrimPrefix(rsp, "成语接龙开始了哦，想想这个成语怎么接，")
+	answer := answerFor(current)
+
+	if rsp := say(t, srv, "提示"); rsp != "可以试试这个，"+string([]rune(answer)[0])+"＿＿＿" {
+		t.Errorf("hint: %s", rsp)
+	}
+	if rsp := say(t, srv, "答案"); rsp != "这些都可以接，"+answer {
+		t.Errorf("answers: %s", rsp)
+	}
+	prefix := "好吧，可以接这个，" + answer
+	if rsp := say(t, srv, "跳过"); !strings.HasPrefix(rsp, prefix) {
+		t.Errorf("skip: %s", rsp)
+	}
+	// 第二轮跳过之后完成，没有人接对，不输出排行
+	if rsp := say(t, srv, "跳过"); !strings.HasPrefix(rsp, "接龙结束了，最后一个词可以接这个，") || strings.Contains(rsp, "接龙排行") {
+		t.Errorf("skip to complete: %s", rsp)
+	}
+}
+
+func TestApiServerAuditReject(t *testing.T) {
+	srv, _ := startTestServer(t)
+
+	auditID := srv.HoldForAudit()
+	msg := map[string]interface{}{
+		"author":     map[string]string{"id": "1234", "username": "张三"},
+		"channel_id": "100010",
+		"guild_id":   "18700000000001",
+		"content":    "<@!" + sgroupbottest.BotUser.ID + "> 你好",
+		"id":         "08e092eeb983afef9e0110f9fb2718dfb3b3a506480f5a",
+	}
+	if err := srv.Dispatch(sgroupbot.EventAtMessageCreate, msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.NextRequest(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// 审核不通过，告知用户
+	if err := srv.RejectAudit(auditID, "100010"); err != nil {
+		t.Fatal(err)
+	}
+	req, err := srv.NextRequest(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, _ := req.Message()
+	if req.Path != "/channels/100010/messages" || !strings.Contains(rsp.Content, "未通过审核") {
+		t.Errorf("notice: %s %+v", req.Path, rsp)
+	}
+	if rsp.MessageReference == nil || rsp.MessageReference.MessageID != msg["id"] {
+		t.Errorf("notice should quote the message: %+v", rsp.MessageReference)
+	}
+}
+
+// expire 将会话的访问时间改为很久以前
+func expire(t *testing.T, s *ApiServer, key string) {
+	t.Helper()
+	ss := s.is.Session(key)
+	if ss == nil {
+		t.Fatalf("no session %s", key)
+	}
+	ss.lastAccess = 0
+	if ok, err := s.is.store.CompareAndSwap(ss, ss.version); !ok || err != nil {
+		t.Fatalf("expire %s: %v", key, err)
+	}
+}
+
+func TestApiServerJanitor(t *testing.T) {
+	srv, s := startTestServer(t)
+
+	rsp := say(t, srv, "成语接龙")
+	current := strings.TrimPrefix(rsp, "成语接龙开始了哦，想想这个成语怎么接，")
+	say(t, srv, answerFor(current))
+
+	expire(t, s, "U1")
+	s.sweep()
+	if s.is.Session("U1") != nil {
+		t.Fatal("session should be cleared")
+	}
+	req, err := srv.NextRequest(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := req.Message()
+	if req.Path != "/v2/users/U1/messages" || !strings.HasPrefix(msg.Content, "接龙超时结束\n接龙排行\n1. 玩家U1 答对1次 得分10") {
+		t.Errorf("timeout: %s %q", req.Path, msg.Content)
+	}
+	if len(msg.MsgID) > 0 || len(msg.EventID) > 0 {
+		t.Errorf("timeout should be an active message: %+v", msg)
+	}
+}
+
+func TestApiServerTimeout(t *testing.T) {
+	srv, s := startTestServer(t)
+
+	say(t, srv, "成语接龙")
+	// 清理之前收到回答
+	expire(t, s, "U1")
+	if rsp := say(t, srv, "一马当先"); rsp != "接龙超时结束" {
+		t.Errorf("timeout: %q", rsp)
+	}
+
+	// 超时之后提示或查看答案也是结算，而不是继续提示
+	for _, content := range []string{"提示", "答案"} {
+		say(t, srv, "成语接龙")
+		expire(t, s, "U1")
+		if rsp := say(t, srv, content); rsp != "接龙超时结束" {
+			t.Errorf("%s timeout: %q", content, rsp)
+		}
+		if s.is.Session("U1") != nil {
+			t.Errorf("%s: session should be ended", content)
+		}
+	}
+}
+
+func TestApiServerMissDeadEnd(t *testing.T) {
+	srv, s := startTestServer(t)
+	s.is.SetRules(10, 1)
+
+	say(t, srv, "成语接龙")
+	ss := s.is.Session("U1")
+	ss.used = nil
+	ss.current, ss.lastRune = "先发制人", '人'
+	ss.use("人山人海")
+	ss.use(ss.current)
+	if ok, err := s.is.store.CompareAndSwap(ss, ss.version); !ok || err != nil {
+		t.Fatalf("set current: %v", err)
+	}
+
+	// 答错次数用完也接不下去时结束并结算，而不是重复用户的回答
+	if rsp := say(t, srv, "不是成语"); rsp != "还是不对哦，这个词谁也接不上了，接龙结束" {
+		t.Errorf("dead end: %q", rsp)
+	}
+	if s.is.Session("U1") != nil {
+		t.Error("session should be ended")
+	}
+}
+
+func TestApiServerUnsaved(t *testing.T) {
+	srv, s := startTestServer(t)
+
+	rsp := say(t, srv, "成语接龙")
+	current := strings.TrimPrefix(rsp, "成语接龙开始了哦，想想这个成语怎么接，")
+	store := &faultyStore{SessionStore: s.is.store}
+	s.is.SetStore(store)
+
+	// 没有保存的回答提示重试，不能重复用户的内容
+	store.err = errors.New("disk full")
+	if rsp := say(t, srv, answerFor(current)); rsp != "刚才没有记下来，请重试" {
+		t.Errorf("store error: %q", rsp)
+	}
+	store.err, store.conflict = nil, true
+	if rsp := say(t, srv, "跳过"); rsp != "刚才没有记下来，请重试" {
+		t.Errorf("conflict: %q", rsp)
+	}
+	if rsp := say(t, srv, "提示"); rsp != "刚才没有记下来，请重试" {
+		t.Errorf("hint conflict: %q", rsp)
+	}
+}
+
+func TestApiServerButtonSync(t *testing.T) {
+	srv, s := startTestServer(t)
+	s.sync = true
+
+	var i sgroupbot.Interaction
+	i.ID = "e1"
+	i.Scene = sgroupbot.SceneC2C
+	i.UserOpenID = "U1"
+	i.Data.Resolved.ButtonData = "提示"
+	if code := s.HandleButton(&i); code != sgroupbot.InteractionOK {
+		t.Fatalf("button: %d", code)
+	}
+	// 同步模式下返回时已经回复，回放录制的按钮点击时顺序确定
+	reqs := srv.Requests()
+	if len(reqs) != 1 || reqs[0].Path != "/v2/users/U1/messages" {
+		t.Fatalf("requests: %+v", reqs)
+	}
+	if msg, _ := reqs[0].Message(); msg.EventID != "e1" {
+		t.Errorf("reply: %+v", msg)
+	}
+}
+
+func TestApiServerGuildButton(t *testing.T) {
+	srv, s := startTestServer(t)
+	s.sync = true
+
+	var i sgroupbot.Interaction
+	i.ID = "e2"
+	i.Scene = sgroupbot.SceneGuild
+	i.GuildID = "g1"
+	i.ChannelID = "c1"
+	i.Data.Resolved.ButtonData = "提示"
+	if code := s.HandleButton(&i); code != sgroupbot.InteractionOK {
+		t.Fatalf("button: %d", code)
+	}
+	// 按钮没有原始消息，回复时不能引用空的消息ID
+	reqs := srv.Requests()
+	if len(reqs) != 1 || reqs[0].Path != "/channels/c1/messages" {
+		t.Fatalf("requests: %+v", reqs)
+	}
+	if msg, _ := reqs[0].Message(); msg.EventID != "e2" || msg.MessageReference != nil {
+		t.Errorf("reply: %+v", msg)
+	}
+}
+
+func TestApiServerDirectMessage(t *testing.T) {
+	srv, s := startTestServer(t)
+	dm := func(guildID, userID, content string) string {
+		t.Helper()
+		msg := map[string]interface{}{
+			"author":       map[string]string{"id": userID, "username": "用户" + userID},
+			"guild_id":     guildID,
+			"channel_id":   "dm-channel",
+			"src_guild_id": "18700000000001",
+			"content":      content,
+			"id":           "dm-msg",
+			"timestamp":    "2024-09-04T13:12:43+08:00",
+		}
+		if err := srv.Dispatch(sgroupbot.EventDirectMessageCreate, msg); err != nil {
+			t.Fatal(err)
+		}
+		req, err := srv.NextRequest(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Path != "/dms/"+guildID+"/messages" {
+			t.Fatalf("unexpected request %s %s", req.Method, req.Path)
+		}
+		rsp, _ := req.Message()
+		return rsp.Content
+	}
+
+	// 两个用户的私信会话互不影响
+	first := dm("dm1", "u1", "成语接龙")
+	second := dm("dm2", "u2", "成语接龙")
+	if !strings.HasPrefix(first, "成语接龙开始了哦") || !strings.HasPrefix(second, "成语接龙开始了哦") {
+		t.Fatalf("start: %s / %s", first, second)
+	}
+	if s.is.Session("dm1") == nil || s.is.Session("dm2") == nil || s.is.Session("") != nil {
+		t.Fatal("sessions should be keyed by dm guild")
+	}
+
+	// 超时的结算推送给会话所在的私信
+	expire(t, s, "dm2")
+	s.sweep()
+	req, err := srv.NextRequest(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Path != "/dms/dm2/messages" || s.is.Session("dm1") == nil {
+		t.Errorf("timeout: %s", req.Path)
+	}
+}
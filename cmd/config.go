@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sgroupbot"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config 成语接龙机器人的配置，优先级：命令行参数 > 环境变量 > 配置文件 > 默认值
+type Config struct {
+	// 机器人凭证，可以通过环境变量 SGROUPBOT_APP_ID、SGROUPBOT_TOKEN、SGROUPBOT_SECRET 设置
+	AppID  uint64 `json:"app_id"`
+	Token  string `json:"token"`
+	Secret string `json:"secret"`
+
+	// Sandbox 是否连接沙箱环境
+	Sandbox bool `json:"sandbox"`
+	// Intents 额外订阅的事件，会与处理函数需要的事件合并
+	Intents int `json:"intents"`
+
+	IdiomsPath string `json:"idioms_path"`
+	// PoolSize 处理消息的线程池大小
+	PoolSize int `json:"pool_size"`
+	// RateLimit 每个群、用户或子频道每秒最多发送的消息数，0 为不限制
+	RateLimit float64 `json:"rate_limit"`
+	// RateBurst 允许瞬间发送的消息数
+	RateBurst int `json:"rate_burst"`
+	// Keyboard 是否附带“提示/跳过/答案/退出”按钮，需要开通 markdown 与按钮权限
+	Keyboard bool `json:"keyboard"`
+	// Settlement 结算消息的格式：text、markdown、ark，auto 为群与单聊使用 markdown、频道使用 ark
+	Settlement string `json:"settlement"`
+
+	LogLevel    string `json:"log_level"`
+	MetricsAddr string `json:"metrics_addr"`
+	Record      string `json:"record"`
+
+	// SessionStore 会话的存储：memory 为内存，file:dir 保存在目录中，重启后继续游戏，也可以多个实例共享
+	SessionStore string `json:"session_store"`
+	// Snapshot 退出时保存正在进行的会话，启动时恢复，为空时不保存
+	Snapshot string `json:"snapshot"`
+	// StatsPath 保存用户战绩的 jsonl 文件，为空时只保存在内存中
+	StatsPath string `json:"stats_path"`
+	// StatsRetention 战绩保留多少天，更早的对局定期删除，0 为全部保留
+	StatsRetention int `json:"stats_retention"`
+
+	Game GameConfig `json:"game"`
+}
+
+// GameConfig 成语接龙的规则
+type GameConfig struct {
+	ExpiredTime int64 `json:"expired_time"` // 会话多长时间无人回答过期，单位秒
+	MaxTurn     int   `json:"max_turn"`     // 单次会话持续多少轮
+	MaxMiss     int   `json:"max_miss"`     // 每一轮最大失败多少次，0 为不限制
+
+	JanitorInterval int64 `json:"janitor_interval"` // 多长时间清理一次过期的会话，单位秒
+
+	Strategy string `json:"strategy"` // 默认的选词策略：random、easy、hard、adversarial
+	Seed     int64  `json:"seed"`     // 选词的随机数种子，0 为使用当前时间
+}
+
+func defaultConfig() Config {
+	return Config{
+		Sandbox:    true,
+		IdiomsPath: idiomsPath,
+		PoolSize:   128,
+		RateLimit:  5,
+		RateBurst:  5,
+		LogLevel:   "info",
+		Settlement: string(SettlementText),
+
+		SessionStore:   "memory",
+		StatsRetention: 365,
+		Game: GameConfig{
+			ExpiredTime: 60 * 5,
+			MaxTurn:     5,
+			MaxMiss:     3,
+
+			JanitorInterval: 30,
+			Strategy:        StrategyRandom,
+		},
+	}
+}
+
+// LoadConfig 在 fs 上注册配置相关的参数并解析 args，依次加载配置文件、环境变量与命令行参数，
+// 返回前不检查配置，由调用方调用 Validate
+func LoadConfig(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := defaultConfig()
+	path := fs.String("config", os.Getenv("SGROUPBOT_CONFIG"), "配置文件路径，json 或 yaml 格式")
+	fs.Uint64Var(&cfg.AppID, "app-id", cfg.AppID, "机器人 AppID")
+	fs.BoolVar(&cfg.Sandbox, "sandbox", cfg.Sandbox, "是否连接沙箱环境")
+	fs.IntVar(&cfg.Intents, "intents", cfg.Intents, "额外订阅的事件")
+	fs.StringVar(&cfg.IdiomsPath, "idioms", cfg.IdiomsPath, "成语库路径")
+	fs.IntVar(&cfg.PoolSize, "pool-size", cfg.PoolSize, "处理消息的线程池大小")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "每个发送对象每秒最多发送的消息数，0 为不限制")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", cfg.RateBurst, "每个发送对象允许瞬间发送的消息数")
+	fs.BoolVar(&cfg.Keyboard, "keyboard", cfg.Keyboard, "是否附带“提示/跳过/答案/退出”按钮")
+	fs.StringVar(&cfg.Settlement, "settlement", cfg.Settlement, "结算消息的格式：text、markdown、ark、auto")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "日志级别：debug、info、warn、error")
+	fs.StringVar(&cfg.MetricsAddr, "metrics", cfg.MetricsAddr, "指标的监听地址，如 :9100，通过 /metrics 获取")
+	fs.StringVar(&cfg.Record, "record", cfg.Record, "录制网关消息到指定的 jsonl 文件")
+	fs.StringVar(&cfg.SessionStore, "session-store", cfg.SessionStore, "会话的存储：memory 或 file:dir")
+	fs.StringVar(&cfg.Snapshot, "snapshot", cfg.Snapshot, "退出时保存会话、启动时恢复的快照文件")
+	fs.StringVar(&cfg.StatsPath, "stats", cfg.StatsPath, "保存用户战绩的 jsonl 文件，为空时只保存在内存中")
+	fs.IntVar(&cfg.StatsRetention, "stats-retention", cfg.StatsRetention, "战绩保留多少天，0 为全部保留")
+	fs.Int64Var(&cfg.Game.ExpiredTime, "expired-time", cfg.Game.ExpiredTime, "会话多长时间无人回答过期，单位秒")
+	fs.IntVar(&cfg.Game.MaxTurn, "max-turn", cfg.Game.MaxTurn, "单次会话持续多少轮")
+	fs.IntVar(&cfg.Game.MaxMiss, "max-miss", cfg.Game.MaxMiss, "每一轮最大失败多少次，0 为不限制")
+	fs.StringVar(&cfg.Game.Strategy, "strategy", cfg.Game.Strategy, "默认的选词策略：random、easy、hard、adversarial")
+	fs.Int64Var(&cfg.Game.Seed, "seed", cfg.Game.Seed, "选词的随机数种子，0 为使用当前时间")
+	fs.Int64Var(&cfg.Game.JanitorInterval, "janitor-interval", cfg.Game.JanitorInterval, "多长时间清理一次过期的会话，单位秒")
+	// token 与 secret 不提供命令行参数，避免出现在进程列表中
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// 命令行参数的优先级最高，加载完文件与环境变量之后重新设置一次
+	var set [][2]string
+	fs.Visit(func(f *flag.Flag) {
+		set = append(set, [2]string{f.Name, f.Value.String()})
+	})
+
+	if len(*path) > 0 {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	for _, kv := range set {
+		if err := fs.Set(kv[0], kv[1]); err != nil {
+			return nil, err
+		}
+	}
+	return &cfg, nil
+}
+
+// loadFile 按扩展名加载配置文件，.yaml 与 .yml 为 yaml 格式，其它为 json 格式
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// 转换为 json 之后解析，两种格式使用相同的字段名，并且同样不允许未知的字段
+		var v interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("config %s: %w", path, err)
+		}
+		if v == nil { // 空文件
+			return nil
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv 从 SGROUPBOT_ 开头的环境变量加载配置
+func (c *Config) loadEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	str := func(name string, p *string) {
+		if v, ok := lookup(name); ok {
+			*p = v
+		}
+	}
+	num := func(name string, set func(string) error) {
+		if v, ok := lookup(name); ok {
+			if err := set(v); err != nil {
+				errs = append(errs, fmt.Errorf("env %s: %w", name, err))
+			}
+		}
+	}
+
+	num("SGROUPBOT_APP_ID", func(v string) (err error) {
+		c.AppID, err = strconv.ParseUint(v, 10, 64)
+		return
+	})
+	str("SGROUPBOT_TOKEN", &c.Token)
+	str("SGROUPBOT_SECRET", &c.Secret)
+	num("SGROUPBOT_SANDBOX", func(v string) (err error) {
+		c.Sandbox, err = strconv.ParseBool(v)
+		return
+	})
+	num("SGROUPBOT_INTENTS", func(v string) (err error) {
+		c.Intents, err = strconv.Atoi(v)
+		return
+	})
+	str("SGROUPBOT_IDIOMS_PATH", &c.IdiomsPath)
+	num("SGROUPBOT_POOL_SIZE", func(v string) (err error) {
+		c.PoolSize, err = strconv.Atoi(v)
+		return
+	})
+	num("SGROUPBOT_RATE_LIMIT", func(v string) (err error) {
+		c.RateLimit, err = strconv.ParseFloat(v, 64)
+		return
+	})
+	num("SGROUPBOT_RATE_BURST", func(v string) (err error) {
+		c.RateBurst, err = strconv.Atoi(v)
+		return
+	})
+	num("SGROUPBOT_KEYBOARD", func(v string) (err error) {
+		c.Keyboard, err = strconv.ParseBool(v)
+		return
+	})
+	str("SGROUPBOT_LOG_LEVEL", &c.LogLevel)
+	str("SGROUPBOT_METRICS_ADDR", &c.MetricsAddr)
+	str("SGROUPBOT_RECORD", &c.Record)
+	str("SGROUPBOT_SETTLEMENT", &c.Settlement)
+	str("SGROUPBOT_SESSION_STORE", &c.SessionStore)
+	str("SGROUPBOT_SNAPSHOT", &c.Snapshot)
+	str("SGROUPBOT_STATS_PATH", &c.StatsPath)
+	num("SGROUPBOT_STATS_RETENTION", func(v string) (err error) {
+		c.StatsRetention, err = strconv.Atoi(v)
+		return
+	})
+	num("SGROUPBOT_EXPIRED_TIME", func(v string) (err error) {
+		c.Game.ExpiredTime, err = strconv.ParseInt(v, 10, 64)
+		return
+	})
+	num("SGROUPBOT_MAX_TURN", func(v string) (err error) {
+		c.Game.MaxTurn, err = strconv.Atoi(v)
+		return
+	})
+	num("SGROUPBOT_MAX_MISS", func(v string) (err error) {
+		c.Game.MaxMiss, err = strconv.Atoi(v)
+		return
+	})
+	str("SGROUPBOT_STRATEGY", &c.Game.Strategy)
+	num("SGROUPBOT_SEED", func(v string) (err error) {
+		c.Game.Seed, err = strconv.ParseInt(v, 10, 64)
+		return
+	})
+	num("SGROUPBOT_JANITOR_INTERVAL", func(v string) (err error) {
+		c.Game.JanitorInterval, err = strconv.ParseInt(v, 10, 64)
+		return
+	})
+	return errors.Join(errs...)
+}
+
+// Validate 检查配置是否有效，返回全部的错误
+func (c *Config) Validate() error {
+	return c.validate(true)
+}
+
+// validate ticket 为 false 时不检查凭证，用于不连接平台的回放
+func (c *Config) validate(ticket bool) error {
+	var errs []error
+	if ticket && c.AppID == 0 {
+		errs = append(errs, errors.New("app_id is required"))
+	}
+	if ticket && len(c.Token) == 0 {
+		errs = append(errs, errors.New("token is required"))
+	}
+	if c.Intents < 0 {
+		errs = append(errs, fmt.Errorf("intents must not be negative, got %d", c.Intents))
+	}
+	if len(c.IdiomsPath) == 0 {
+		errs = append(errs, errors.New("idioms_path is required"))
+	}
+	if c.PoolSize <= 0 {
+		errs = append(errs, fmt.Errorf("pool_size must be positive, got %d", c.PoolSize))
+	}
+	if c.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit must not be negative, got %v", c.RateLimit))
+	}
+	if c.RateLimit > 0 && c.RateBurst <= 0 {
+		errs = append(errs, fmt.Errorf("rate_burst must be positive, got %d", c.RateBurst))
+	}
+	if _, err := sgroupbot.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseSettlementFormat(c.Settlement); err != nil {
+		errs = append(errs, err)
+	}
+	if _, _, err := parseSessionStore(c.SessionStore); err != nil {
+		errs = append(errs, err)
+	}
+	if c.StatsRetention < 0 {
+		errs = append(errs, fmt.Errorf("stats_retention must not be negative, got %d", c.StatsRetention))
+	}
+	if c.Game.ExpiredTime <= 0 {
+		errs = append(errs, fmt.Errorf("game.expired_time must be positive, got %d", c.Game.ExpiredTime))
+	}
+	if c.Game.MaxTurn <= 0 {
+		errs = append(errs, fmt.Errorf("game.max_turn must be positive, got %d", c.Game.MaxTurn))
+	}
+	if c.Game.MaxMiss < 0 {
+		errs = append(errs, fmt.Errorf("game.max_miss must not be negative, got %d", c.Game.MaxMiss))
+	}
+	if _, err := NewStrategy(c.Game.Strategy, 0); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Game.JanitorInterval <= 0 {
+		errs = append(errs, fmt.Errorf("game.janitor_interval must be positive, got %d", c.Game.JanitorInterval))
+	}
+	return errors.Join(errs...)
+}
+
+// NewStrategy 创建默认的选词策略
+func (c *GameConfig) NewStrategy() Strategy {
+	seed := c.Seed
+	if seed == 0 {
+		seed = defaultSeed()
+	}
+	strategy, err := NewStrategy(c.Strategy, seed)
+	if err != nil {
+		// 已经通过 Validate 检查
+		panic(err)
+	}
+	return strategy
+}
+
+// Ticket 机器人凭证
+func (c *Config) Ticket() sgroupbot.Ticket {
+	return sgroupbot.Ticket{AppID: c.AppID, Token: c.Token, Secret: c.Secret}
+}
+
+// Target 接口地址
+func (c *Config) Target() string {
+	if c.Sandbox {
+		return sgroupbot.SandboxSgroupTarget
+	}
+	return sgroupbot.SgroupTarget
+}
+
+// String 输出生效的配置，隐藏 token 与 secret
+func (c Config) String() string {
+	c.Token = mask(c.Token)
+	c.Secret = mask(c.Secret)
+	b, _ := json.Marshal(&c)
+	return string(b)
+}
+
+func mask(s string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return strings.Repeat("*", 6)
+}
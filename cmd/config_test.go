@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"app_id": 102000000, "token": "file-token", "pool_size": 64, "game": {"max_turn": 8, "max_miss": 2}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SGROUPBOT_TOKEN", "env-token")
+	t.Setenv("SGROUPBOT_POOL_SIZE", "32")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := LoadConfig(fs, []string{"-config", path, "-pool-size", "16", "-sandbox=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.AppID != 102000000 || cfg.Game.MaxTurn != 8 || cfg.Game.MaxMiss != 2 {
+		t.Errorf("file not loaded: %+v", cfg)
+	}
+	if cfg.Game.ExpiredTime != 300 {
+		t.Errorf("default expired_time: %d", cfg.Game.ExpiredTime)
+	}
+	if cfg.Token != "env-token" {
+		t.Errorf("env should override file: %s", cfg.Token)
+	}
+	if cfg.PoolSize != 16 || cfg.Sandbox {
+		t.Errorf("flag should override env: %+v", cfg)
+	}
+
+	if s := cfg.String(); strings.Contains(s, "env-token") || !strings.Contains(s, `"pool_size":16`) {
+		t.Errorf("effective config: %s", s)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.PoolSize = 0
+	cfg.LogLevel = "verbose"
+	cfg.Game.Strategy = "cheat"
+	cfg.SessionStore = "redis://localhost"
+	cfg.Settlement = "html"
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("want error")
+	}
+	for _, want := range []string{"app_id", "token", "pool_size", "verbose", "cheat", "session_store", "settlement"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in %v", want, err)
+		}
+	}
+
+	// 回放不需要凭证
+	cfg = defaultConfig()
+	if err := cfg.validate(false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	// 两种格式的示例配置保持一致
+	load := func(path string) *Config {
+		t.Helper()
+		cfg := defaultConfig()
+		if err := cfg.loadFile(path); err != nil {
+			t.Fatal(err)
+		}
+		return &cfg
+	}
+	if j, y := load("config.example.json"), load("config.example.yaml"); *j != *y {
+		t.Errorf("examples differ:\njson %+v\nyaml %+v", j, y)
+	}
+
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	cfg := load(write("config.yml", "pool_size: 64\ngame:\n  max_turn: 8\n"))
+	if cfg.PoolSize != 64 || cfg.Game.MaxTurn != 8 || cfg.Game.MaxMiss != 3 {
+		t.Errorf("yml: %+v", cfg)
+	}
+	if cfg := load(write("empty.yaml", "# 没有配置\n")); cfg.PoolSize != 128 {
+		t.Errorf("empty: %+v", cfg)
+	}
+
+	empty := defaultConfig()
+	if err := empty.loadFile(write("unknown.yaml", "pool_sise: 64\n")); err == nil || !strings.Contains(err.Error(), "pool_sise") {
+		t.Errorf("unknown field: %v", err)
+	}
+	if err := empty.loadFile(write("bad.yaml", "game: [1, 2\n")); err == nil {
+		t.Error("want syntax error")
+	}
+}
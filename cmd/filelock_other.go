@@ -0,0 +1,20 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// fileLocks 没有 flock 的平台上只在进程内串行化，多个进程不能共享同一个会话目录
+var fileLocks sync.Mutex
+
+// lockFile 阻塞直到拿到锁，返回的函数释放锁并关闭文件
+func lockFile(f *os.File) (func(), error) {
+	fileLocks.Lock()
+	return func() {
+		f.Close()
+		fileLocks.Unlock()
+	}, nil
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile 阻塞直到拿到 f 的排它锁，返回的函数释放锁并关闭文件
+func lockFile(f *os.File) (func(), error) {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err == nil {
+			return func() { f.Close() }, nil
+		}
+		if err != syscall.EINTR {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"sort"
+)
+
+// reachLimit 可达长度的上限，超过时都记为 reachLimit
+const reachLimit = 999
+
+// chainBudget 检查开局能否接够轮数时最多搜索的步数，超过时视为不能
+const chainBudget = 2000
+
+// IdiomStats 成语在接龙图中的统计，只考虑同字接龙
+type IdiomStats struct {
+	OutDegree  int     // 可以接在后面的成语数量
+	DeadEnd    bool    // 后面接不了任何成语
+	Component  int     // 所在的强连通分量，-1 表示不在任何环上
+	Reach      int     // 后面最多还能不重复地接多少个成语，是一个上界
+	Difficulty float64 // 接下去的难度，0 到 1，越大越难，死胡同为 1
+}
+
+// GraphSummary 接龙图的整体统计
+type GraphSummary struct {
+	Idioms           int // 成语数量
+	Chars            int // 首字与尾字的数量
+	Edges            int // 接龙关系的数量
+	DeadEnds         int // 死胡同的数量
+	Components       int // 包含环的强连通分量数量
+	LargestComponent int // 最大的强连通分量包含的成语数量
+}
+
+// IdiomGraph 成语接龙的有向图：成语 A 的尾字是成语 B 的首字时 A -> B。
+// 成语之间的连通性等价于以字为节点、成语为边的图，所以强连通分量按字计算：
+// 首字与尾字在同一个分量中的成语都在同一个环上
+type IdiomGraph struct {
+	stats      map[string]*IdiomStats
+	components []int // 各个强连通分量中成语的数量
+	summary    GraphSummary
+}
+
+// analyzeIdioms 根据 IdiomsSolitaire 的 dict 与 idioms 分析接龙图
+func analyzeIdioms(dict map[rune][]string, idioms map[string]rune) *IdiomGraph {
+	g := &IdiomGraph{stats: make(map[string]*IdiomStats, len(idioms))}
+
+	first := make(map[string]rune, len(idioms))
+	chars := make(map[rune]struct{})
+	adj := make(map[rune][]rune)
+	for f, words := range dict {
+		chars[f] = struct{}{}
+		for _, w := range words {
+			first[w] = f
+			chars[idioms[w]] = struct{}{}
+			adj[f] = append(adj[f], idioms[w])
+		}
+	}
+
+	// 1. 出度与死胡同
+	for w, last := range idioms {
+		s := &IdiomStats{OutDegree: len(dict[last]), Component: -1}
+		if first[w] == last {
+			s.OutDegree-- // 不能接自己
+		}
+		s.DeadEnd = s.OutDegree <= 0
+		g.stats[w] = s
+		g.summary.Edges += s.OutDegree
+		if s.DeadEnd {
+			g.summary.DeadEnds++
+		}
+	}
+	g.summary.Idioms = len(idioms)
+	g.summary.Chars = len(chars)
+
+	// 2. 难度：能继续接下去的后继越少越难
+	live := make(map[rune]int)
+	for w, s := range g.stats {
+		if !s.DeadEnd {
+			live[first[w]]++
+		}
+	}
+	for w, s := range g.stats {
+		n := live[idioms[w]]
+		if first[w] == idioms[w] && !s.DeadEnd {
+			n--
+		}
+		s.Difficulty = 1 / float64(1+n)
+	}
+
+	// 3. 强连通分量，按拓扑的逆序编号，后继所在的分量编号更小
+	nodes := make([]rune, 0, len(chars))
+	for c := range chars {
+		nodes = append(nodes, c)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	comp, ncomp := stronglyConnected(nodes, adj)
+
+	internal := make([]int, ncomp)      // 分量内部的成语数量
+	crossing := make([][]string, ncomp) // 从分量出去的成语
+	for w, last := range idioms {
+		c := comp[first[w]]
+		if c == comp[last] {
+			internal[c]++
+		} else {
+			crossing[c] = append(crossing[c], w)
+		}
+	}
+	g.components = internal
+	for w, last := range idioms {
+		// 分量内只有自己时接不回来
+		if c := comp[first[w]]; c == comp[last] && internal[c] > 1 {
+			g.stats[w].Component = c
+		}
+	}
+	for _, n := range internal {
+		if n > 1 {
+			g.summary.Components++
+			if n > g.summary.LargestComponent {
+				g.summary.LargestComponent = n
+			}
+		}
+	}
+
+	// 4. 可达长度：分量内的成语最多全部用上，再从某个成语离开分量
+	best := make([]int, ncomp)
+	for c := 0; c < ncomp; c++ {
+		var out int
+		for _, w := range crossing[c] {
+			if n := 1 + best[comp[idioms[w]]]; n > out {
+				out = n
+			}
+		}
+		best[c] = internal[c] + out
+		if best[c] > reachLimit {
+			best[c] = reachLimit
+		}
+	}
+	for w, last := range idioms {
+		reach := best[comp[last]]
+		if comp[first[w]] == comp[last] && reach > 0 {
+			reach-- // 自己已经用过了
+		}
+		g.stats[w].Reach = reach
+	}
+
+	return g
+}
+
+// stronglyConnected Tarjan 算法，分量按照完成的顺序编号
+func stronglyConnected(nodes []rune, adj map[rune][]rune) (map[rune]int, int) {
+	var (
+		index   = make(map[rune]int, len(nodes))
+		low     = make(map[rune]int, len(nodes))
+		onStack = make(map[rune]bool, len(nodes))
+		comp    = make(map[rune]int, len(nodes))
+		stack   []rune
+		next    int
+		ncomp   int
+	)
+	var visit func(v rune)
+	visit = func(v rune) {
+		index[v], low[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range adj[v] {
+			if _, ok := index[w]; !ok {
+				visit(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			comp[w] = ncomp
+			if w == v {
+				break
+			}
+		}
+		ncomp++
+	}
+	for _, v := range nodes {
+		if _, ok := index[v]; !ok {
+			visit(v)
+		}
+	}
+	return comp, ncomp
+}
+
+// Stats 成语的统计，不是成语时返回 false
+func (g *IdiomGraph) Stats(word string) (IdiomStats, bool) {
+	s, ok := g.stats[word]
+	if !ok {
+		return IdiomStats{}, false
+	}
+	return *s, true
+}
+
+// Summary 接龙图的整体统计
+func (g *IdiomGraph) Summary() GraphSummary {
+	return g.summary
+}
+
+// ComponentSize 强连通分量中成语的数量
+func (g *IdiomGraph) ComponentSize(c int) int {
+	if c < 0 || c >= len(g.components) {
+		return 0
+	}
+	return g.components[c]
+}
+
+// Graph 成语库的接龙图
+func (is *IdiomsSolitaire) Graph() *IdiomGraph {
+	return is.graph
+}
+
+// chainable 同字规则下 idiom 之后能否不重复地再接 n 个成语，
+// 优先尝试可达长度大的后继，搜索超过 chainBudget 步时视为不能
+func (is *IdiomsSolitaire) chainable(idiom string, n int) bool {
+	used := map[string]struct{}{idiom: {}}
+	budget := chainBudget
+	var dfs func(word string, n int) bool
+	dfs = func(word string, n int) bool {
+		if n <= 0 {
+			return true
+		}
+		if budget--; budget < 0 {
+			return false
+		}
+		next := append([]string(nil), is.dict[is.idioms[word]]...)
+		sort.SliceStable(next, func(i, j int) bool {
+			return is.graph.stats[next[i]].Reach > is.graph.stats[next[j]].Reach
+		})
+		for _, w := range next {
+			if _, ok := used[w]; ok {
+				continue
+			}
+			if is.graph.stats[w].Reach < n-1 {
+				break // 后面的更短
+			}
+			used[w] = struct{}{}
+			if dfs(w, n-1) {
+				return true
+			}
+			delete(used, w)
+		}
+		return false
+	}
+	return dfs(idiom, n)
+}
+
+// chainLength 完成 maxTurn 轮需要在开局之后接上的成语数量：用户接 maxTurn 次，机器人接 maxTurn-1 次
+func chainLength(maxTurn int) int {
+	return 2*maxTurn - 1
+}
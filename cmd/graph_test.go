@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestIdiomGraph(t *testing.T) {
+	// 一马当先 -> ... -> 上下一心 -> 心想事成 -> 成千上万 -> 万众一心 -> 心想事成 成环
+	is := NewIdiomsSolitaire(testIdioms(), 60)
+	g := is.Graph()
+
+	sum := g.Summary()
+	if sum.Idioms != 10 || sum.DeadEnds != 0 || sum.Components != 1 || sum.LargestComponent != 3 {
+		t.Errorf("summary: %+v", sum)
+	}
+	for word, want := range map[string]IdiomStats{
+		"一马当先": {OutDegree: 1, Component: -1, Reach: 9, Difficulty: 0.5},
+		"上下一心": {OutDegree: 1, Component: -1, Reach: 3, Difficulty: 0.5},
+		"心想事成": {OutDegree: 1, Reach: 2, Difficulty: 0.5},
+	} {
+		s, ok := g.Stats(word)
+		if !ok {
+			t.Fatalf("missing %s", word)
+		}
+		if want.Component == 0 {
+			// 环上的成语，分量的编号不固定
+			if g.ComponentSize(s.Component) != 3 {
+				t.Errorf("%s component: %d", word, s.Component)
+			}
+			want.Component = s.Component
+		}
+		if s != want {
+			t.Errorf("%s: got %+v, want %+v", word, s, want)
+		}
+	}
+
+	strategyGraph := NewIdiomsSolitaire(strategyIdioms(), 60).Graph()
+	if s, _ := strategyGraph.Stats("空前绝后"); !s.DeadEnd || s.Difficulty != 1 {
+		t.Errorf("dead end: %+v", s)
+	}
+	if s, _ := strategyGraph.Stats("海阔天空"); s.OutDegree != 2 || s.Reach != 1 || s.Difficulty != 1 {
+		t.Errorf("海阔天空: %+v", s)
+	}
+}
+
+func TestGuaranteedOpening(t *testing.T) {
+	is := NewIdiomsSolitaire(testIdioms(), 60)
+
+	// 完成 5 轮需要再接 9 个成语，只有一马当先可以
+	if len(is.openings) != 1 || is.openings[0] != "一马当先" {
+		t.Errorf("openings: %v", is.openings)
+	}
+	if !is.chainable("一马当先", 9) || is.chainable("先发制人", 9) {
+		t.Error("chainable")
+	}
+	for i := 0; i < 5; i++ {
+		if word, _ := is.radomIdiom(); word != "一马当先" {
+			t.Errorf("opening: %s", word)
+		}
+	}
+
+	is.SetRules(1, 3)
+	if len(is.openings) != 10 {
+		t.Errorf("openings: %v", is.openings)
+	}
+	// 没有可以保证轮数的开局时，退回到所有能接上的成语
+	is.SetRules(10, 3)
+	if len(is.openings) != 10 {
+		t.Errorf("fallback openings: %v", is.openings)
+	}
+}
+
+func TestOpeningNotChainable(t *testing.T) {
+	// 开甲 的可达长度按分量计算是 5，但甲乙之间只能来回接 3 个成语；一二 可以一直接到六七
+	var idioms []Idiom
+	for _, w := range []string{"开一甲", "甲一乙", "甲二乙", "甲三乙", "甲四乙", "乙一甲", "一二", "二三", "三四", "四五", "五六", "六七"} {
+		runes := []rune(w)
+		idioms = append(idioms, Idiom{Word: w, FirstRune: runes[0], LastRune: runes[len(runes)-1]})
+	}
+	is := NewIdiomsSolitaire(idioms, 60)
+	is.SetRules(3, 3)
+
+	if s, _ := is.Graph().Stats("开一甲"); s.Reach < chainLength(3) || is.chainable("开一甲", chainLength(3)) {
+		t.Fatalf("开一甲 should look reachable but not be chainable: %+v", s)
+	}
+	if len(is.openings) != 1 || is.openings[0] != "一二" {
+		t.Errorf("openings: %v", is.openings)
+	}
+	for i := 0; i < 20; i++ {
+		if word, _ := is.radomIdiom(); word != "一二" {
+			t.Errorf("opening: %s", word)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	is := NewIdiomsSolitaire(strategyIdioms(), 60)
+	is.SetRules(1, 3)
+
+	var sb strings.Builder
+	is.Report(&sb, 3, 10, rand.New(rand.NewSource(1)))
+	out := sb.String()
+	for _, want := range []string{
+		"成语: 10，",
+		"死胡同: 5 (50.0%)",
+		"可以完成 1 轮的开局: 5",
+		"随机接龙长度 (10 次)",
+		"  关怀备至\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
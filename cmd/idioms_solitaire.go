@@ -1,24 +1,26 @@
 package main
 
 import (
-	"log"
+	"sgroupbot"
 	"sort"
-	"sync"
-	"sync/atomic"
 	"time"
-
-	"github.com/puzpuzpuz/xsync/v3"
 )
 
 // IdiomsSolitaire 实现成语接龙
 type IdiomsSolitaire struct {
-	dict   map[rune][]string // "first_word" -> word_list
-	idioms map[string]rune
-
-	expiredTime int64 // 多长时间过期，单位秒
-	maxTurn     int   // 单次会话持续多少轮
-	maxMiss     int   // 每一轮最大失败多少次
-	sessions    *xsync.MapOf[string, *Session]
+	dict    map[rune][]string // "first_word" -> word_list
+	idioms  map[string]rune
+	pinyin  map[ChainMode]*pinyinIndex // 同音接龙的读音索引
+	reading map[string]string          // word -> 拼音，用于提示
+
+	graph    *IdiomGraph // 接龙图的分析
+	openings []string    // 可以作为开局的成语，按字典序排列
+	strategy Strategy    // 默认的选词策略
+
+	expiredTime int64        // 多长时间过期，单位秒
+	maxTurn     int          // 单次会话持续多少轮
+	maxMiss     int          // 每一轮最大失败多少次
+	store       SessionStore // 会话的存储，默认在内存中
 }
 
 type Idiom struct {
@@ -34,75 +36,239 @@ type Idiom struct {
 func NewIdiomsSolitaire(idioms []Idiom, expiredTime int64) *IdiomsSolitaire {
 	var set = make(map[string]rune)
 	var dict = make(map[rune][]string)
+	var reading = make(map[string]string)
 	for i := range idioms {
 		idiom := &idioms[i]
 		set[idiom.Word] = idiom.LastRune
 		dict[idiom.FirstRune] = append(dict[idiom.FirstRune], idiom.Word)
+		if len(idiom.Pinyin) > 0 {
+			reading[idiom.Word] = idiom.Pinyin
+		} else if len(idiom.PingyinR) > 0 {
+			reading[idiom.Word] = idiom.PingyinR
+		}
 	}
 
 	is := &IdiomsSolitaire{}
 	is.dict = dict
 	is.idioms = set
+	is.reading = reading
+	is.pinyin = buildPinyinIndexes(idioms)
+	is.graph = analyzeIdioms(dict, set)
+	is.strategy = NewRandomStrategy(defaultSeed())
 	is.expiredTime = expiredTime
-	is.sessions = xsync.NewMapOf[string, *Session]()
+	is.store = NewMemoryStore()
 
-	is.maxTurn = 5
-	is.maxMiss = 3
+	is.SetRules(5, 3)
 
 	return is
 }
 
-// radomIdiom 随机获取一个能够被接上的成语
-func (is *IdiomsSolitaire) radomIdiom() (string, rune) {
-	for word, last := range is.idioms {
-		if _, ok := is.dict[last]; ok {
-			return word, last
+// SetRules 设置单次会话的轮数与每一轮最大失败次数，maxMiss 为 0 时不限制
+func (is *IdiomsSolitaire) SetRules(maxTurn, maxMiss int) {
+	is.maxTurn = maxTurn
+	is.maxMiss = maxMiss
+	is.updateOpenings()
+}
+
+// updateOpenings 只选择确实能完成 maxTurn 轮的成语开局：可达长度只是上界，还要搜索检查一遍，
+// 成语库太小没有这样的成语时，退回到所有能被接上的成语
+func (is *IdiomsSolitaire) updateOpenings() {
+	var openings, continuable []string
+	need := chainLength(is.maxTurn)
+	for word, s := range is.graph.stats {
+		if s.DeadEnd {
+			continue
+		}
+		continuable = append(continuable, word)
+		if s.Reach >= need && is.chainable(word, need) {
+			openings = append(openings, word)
 		}
 	}
-	log.Println("random failed")
-	return "", 0
+	if len(openings) == 0 {
+		sgroupbot.DefaultLogger.Warn("no_guaranteed_opening", "max_turn", is.maxTurn, "count", len(continuable))
+		openings = continuable
+	}
+	sort.Strings(openings)
+	is.openings = openings
+}
+
+// SetStore 设置会话的存储，需要在处理消息之前设置
+func (is *IdiomsSolitaire) SetStore(store SessionStore) {
+	is.store = store
+}
+
+// SetStrategy 设置默认的选词策略，创建会话时没有指定策略的会话使用
+func (is *IdiomsSolitaire) SetStrategy(strategy Strategy) {
+	is.strategy = strategy
+}
+
+// radomIdiom 按默认的策略获取一个能够被接上的成语
+func (is *IdiomsSolitaire) radomIdiom() (string, rune) {
+	return is.openingIdiom(is.strategy, ChainChar)
+}
+
+// openingIdiom 按照策略选择开局的成语，开局在加载时已经检查过能完成 maxTurn 轮，
+// 同字能接上的在同音规则下也能接上，所以只按同字检查
+func (is *IdiomsSolitaire) openingIdiom(strategy Strategy, mode ChainMode) (string, rune) {
+	if len(is.openings) == 0 {
+		sgroupbot.DefaultLogger.Error("random_failed", "count", len(is.idioms))
+		return "", 0
+	}
+	word := strategy.Opening(&moves{is: is, mode: mode}, is.openings)
+	return word, is.idioms[word]
+}
+
+// SessionOptions 创建会话的选项
+type SessionOptions struct {
+	Mode     ChainMode            // 接龙的规则
+	Strategy Strategy             // 选词的策略，为空时使用默认的策略
+	Kind     sgroupbot.TargetKind // 会话所在聊天的类型
+	To       string               // 会话所在的聊天，超时后推送结算
+	Guild    string               // 会话所在的频道，群聊与单聊为空
 }
 
-// Session 获取会话
+// Session 获取会话，不存在时按照同字接龙的规则创建
 func (is *IdiomsSolitaire) SessionOrCreate(key string) (*Session, bool) {
-	// 随机选取一个成语
+	return is.SessionOrCreateWith(key, SessionOptions{})
+}
+
+// SessionOrCreateWith 获取会话，不存在时按照 opts 创建，已存在的会话保持原来的选项
+func (is *IdiomsSolitaire) SessionOrCreateWith(key string, opts SessionOptions) (*Session, bool) {
+	// 按照策略选取一个成语
 	ss := &Session{}
 	ss.key = key
-	ss.current, ss.lastRune = is.radomIdiom()
+	ss.mode = opts.Mode
+	ss.strategy = opts.Strategy
+	ss.conv = conversation{kind: opts.Kind, to: opts.To, guild: opts.Guild}
+	ss.current, ss.lastRune = is.openingIdiom(is.strategyFor(ss), ss.mode)
+	ss.use(ss.current)
 	ss.lastAccess = time.Now().Unix()
 
-	var loaded bool
-	ss, loaded = is.sessions.LoadOrStore(key, ss)
-
-	return ss, loaded
+	for {
+		if old := is.Session(key); old != nil {
+			return old, true
+		}
+		ok, err := is.store.CompareAndSwap(ss, 0)
+		if err != nil {
+			sgroupbot.DefaultLogger.Error("session_store", "op", "create", "key", key, "err", err)
+			return ss, false
+		}
+		if ok {
+			return ss, false
+		}
+	}
 }
 
-// Session 获取会话
+// Session 获取会话的副本，不存在时返回 nil
 func (is *IdiomsSolitaire) Session(key string) *Session {
-	ss, _ := is.sessions.Load(key)
+	ss, ok, err := is.store.Load(key)
+	if err != nil {
+		sgroupbot.DefaultLogger.Error("session_store", "op", "load", "key", key, "err", err)
+	}
+	if !ok {
+		return nil
+	}
 	return ss
 }
 
 func (is *IdiomsSolitaire) SessionAndDelete(key string) (*Session, bool) {
-	ss, exists := is.sessions.LoadAndDelete(key)
-	// 修改会话状态
-	if ss != nil {
-		ss.Lock()
-		defer ss.Unlock()
-		ss.finished = true
+	for {
+		ss := is.Session(key)
+		if ss == nil {
+			return nil, false
+		}
+		ok, err := is.store.Delete(key, ss.version)
+		if err != nil {
+			sgroupbot.DefaultLogger.Error("session_store", "op", "delete", "key", key, "err", err)
+			return nil, false
+		}
+		if ok {
+			// 修改会话状态
+			ss.finished = true
+			return ss, true
+		}
 	}
-	return ss, exists
 }
 
-// ClearExpiredSession 清理过期的session
-func (is *IdiomsSolitaire) ClearExpiredSession() {
-	now := time.Now().Unix()
-	is.sessions.Range(func(key string, value *Session) bool {
-		if now-atomic.LoadInt64(&value.lastAccess) >= is.expiredTime {
-			is.sessions.Delete(key)
+// ClearExpiredSession 清理过期的session，返回被清理的会话，用于推送结算
+func (is *IdiomsSolitaire) ClearExpiredSession() []*Session {
+	var candidates []*Session
+	err := is.store.Range(time.Now().Unix()-is.expiredTime+1, func(ss *Session) bool {
+		candidates = append(candidates, ss)
+		return true
+	})
+	if err != nil {
+		sgroupbot.DefaultLogger.Error("session_store", "op", "range", "err", err)
+	}
+
+	var expired []*Session
+	for _, ss := range candidates {
+		// 按版本删除，会话可能刚刚被访问过
+		ok, err := is.store.Delete(ss.key, ss.version)
+		if err != nil {
+			sgroupbot.DefaultLogger.Error("session_store", "op", "delete", "key", ss.key, "err", err)
+			continue
+		}
+		if ok {
+			ss.finished = true
+			expired = append(expired, ss)
 		}
+	}
+	return expired
+}
+
+// SessionCount 正在进行的会话数量
+func (is *IdiomsSolitaire) SessionCount() int {
+	var n int
+	is.store.Range(0, func(*Session) bool {
+		n++
 		return true
 	})
+	return n
+}
+
+// casRetries 更新会话时版本冲突的最大重试次数
+const casRetries = 16
+
+// update 乐观地更新会话：在 ss 的副本上执行 fn，存储中的版本没有变化时保存，
+// 否则读出最新的会话重新执行；fn 结束会话时删除会话。成功后 ss 更新为保存的会话，返回 fn 的结果。
+// 没有保存时 fn 的结果作废：会话已经不存在返回 SolitaireCanceled，重试 casRetries 次仍然冲突返回
+// SolitaireConflict，存储出错返回 SolitaireStoreError
+func (is *IdiomsSolitaire) update(ss *Session, fn func(cur *Session) int) int {
+	cur := ss
+	for i := 0; i < casRetries; i++ {
+		next := cur.clone()
+		ret := fn(next)
+
+		var ok bool
+		var err error
+		if next.finished {
+			ok, err = is.store.Delete(next.key, cur.version)
+		} else {
+			ok, err = is.store.CompareAndSwap(next, cur.version)
+		}
+		if err != nil {
+			sgroupbot.DefaultLogger.Error("session_store", "op", "update", "key", ss.key, "err", err)
+			return SolitaireStoreError
+		}
+		if ok {
+			*ss = *next
+			return ret
+		}
+
+		// 版本冲突，会话已经被其它消息修改或者结束了
+		if cur = is.Session(ss.key); cur == nil {
+			ss.finished = true
+			return SolitaireCanceled
+		}
+	}
+	sgroupbot.DefaultLogger.Warn("session_conflict", "key", ss.key, "retries", casRetries)
+	return SolitaireConflict
+}
+
+// unsaved update 没有保存会话时的结果，这时 fn 中得到的成语也作废
+func unsaved(ret int) bool {
+	return ret == SolitaireCanceled || ret == SolitaireConflict || ret == SolitaireStoreError
 }
 
 // 1. 给到一个字符串，判断其是否为成语
@@ -112,51 +278,83 @@ const (
 	SolitaireFailed       = 1 + iota // 1. 接龙失败，返回提示
 	SolitaireFailedToNext            // 2. 多次接龙失败，直接进入到下一轮，返回新的词
 	SolitaireSucceed                 // 3. 接龙成功，进入下一轮，返回新的词
-	SolitaireEnd                     // 4. 接龙成功，接不下去了，直接结束，返回结算数据
+	SolitaireEnd                     // 4. 接龙成功或失败次数用完后接不下去了，直接结束，返回结算数据
 	SolitaireCompleted               // 5. 接龙完成，会话结束，返回结算数据
 	SolitaireTimeout                 // 6. 会话已过期，
 	SolitaireCanceled                // 7. 会话被退出了
 	SolitaireFailComplete            // 8. 接龙完成，但是最后一轮失败
+	SolitaireRepeated                // 9. 成语在本次会话中已经用过了，不计失败次数
+	SolitaireSkipped                 // 10. 用户跳过了这一轮，进入下一轮，返回新的词
+	SolitaireConflict                // 11. 会话同时被其它消息修改，多次重试仍然冲突，没有保存
+	SolitaireStoreError              // 12. 会话存储出错，没有保存
 )
 
-func (is *IdiomsSolitaire) Solitaire(ss *Session, idiom, id string) (int, string) {
-	ss.Lock()
-	defer ss.Unlock()
+// 结算的得分：每接对一次得分，使用提示、跳过与查看答案扣分
+const (
+	scoreHit    = 10
+	costHint    = 3
+	costSkip    = 2
+	costAnswers = 5
+)
 
-	// 0. 会话已结束
-	if ss.finished {
-		return SolitaireCanceled, ""
-	}
+// maxAnswers 查看答案时最多列出多少个接法
+const maxAnswers = 5
+
+// alive 检查会话是否还在进行中，超时时结束会话，在 update 中调用
+func (is *IdiomsSolitaire) alive(ss *Session) bool {
 	now := time.Now().Unix()
 	if now-ss.lastAccess >= is.expiredTime {
 		ss.finished = true // 会话已经超时，直接退出
-		is.sessions.Delete(ss.key)
+		return false
+	}
+	ss.lastAccess = now
+	return true
+}
+
+func (is *IdiomsSolitaire) Solitaire(ss *Session, idiom, id string) (int, string) {
+	var next string
+	ret := is.update(ss, func(ss *Session) int {
+		var ret int
+		ret, next = is.solitaire(ss, idiom, id)
+		return ret
+	})
+	if unsaved(ret) {
+		return ret, ""
+	}
+	return ret, next
+}
+
+// outOfMisses 这一轮的失败次数是否已经用完，用于区分答错之后接不下去的结束
+func (is *IdiomsSolitaire) outOfMisses(ss *Session) bool {
+	return is.maxMiss > 0 && ss.miss >= is.maxMiss
+}
+
+// solitaire 在会话的副本上接龙，由 update 保存
+func (is *IdiomsSolitaire) solitaire(ss *Session, idiom, id string) (int, string) {
+	// 0. 会话已超时
+	if !is.alive(ss) {
 		return SolitaireTimeout, ""
 	}
-	atomic.StoreInt64(&ss.lastAccess, now)
 
 	// 1. 检查是否在成语库中
-	first := []rune(idiom)[0]
 	_, ok := is.idioms[idiom]
-	if !ok || first != ss.lastRune { // 不是成语，或不匹配
-		log.Println("solitaire", ok, first, ss.lastRune)
+	if !ok || !is.linked(ss.mode, ss.current, idiom) { // 不是成语，或不匹配
+		sgroupbot.DefaultLogger.Debug("solitaire_miss", "key", ss.key, "idiom", idiom, "in_dict", ok, "want", string(ss.lastRune))
 		ss.miss += 1
-		if is.maxMiss > 0 && ss.miss >= is.maxMiss {
+		ss.perfOf(id).missed()
+		if is.outOfMisses(ss) {
 
 			// 超出最大失败次数，给出答案，并进入到下一轮
-			next, last, ok := is.nextIdiom(ss.current)
+			next, last, ok := is.nextIdiom(is.strategyFor(ss), ss.mode, ss.current, ss.used)
 			if !ok { // 找不到下一个成语，提前结束
-				return 0, ""
+				ss.finished = true
+				return SolitaireEnd, ""
 			}
 			ss.trun += 1
-
-			ss.miss = 0
-			ss.current = next
-			ss.lastRune = last
+			ss.advance(next, last)
 
 			if ss.trun >= is.maxTurn { // 接龙完成
 				ss.finished = true
-				is.sessions.Delete(ss.key)
 				return SolitaireFailComplete, next
 			}
 
@@ -165,31 +363,35 @@ func (is *IdiomsSolitaire) Solitaire(ss *Session, idiom, id string) (int, string
 		return SolitaireFailed, ""
 	}
 
+	// 2. 不能重复使用已经接过的成语
+	if _, used := ss.used[idiom]; used {
+		return SolitaireRepeated, ""
+	}
+	ss.use(idiom)
+
 	ss.trun += 1
 	// 接龙成功计数+1
 	if ss.hits == nil {
 		ss.hits = map[string]int{}
 	}
 	ss.hits[id] = ss.hits[id] + 1
+	ss.perfOf(id).hit(time.Now().UnixNano())
 
 	// 3. 检查游戏轮数是否已经完成
 	if ss.trun >= is.maxTurn { // 接龙完成
 		ss.finished = true
-		is.sessions.Delete(ss.key)
 		return SolitaireCompleted, ""
 	}
 
 	// 4. 找到下一个可被接龙成语
-	next, last, ok := is.nextIdiom(idiom)
+	next, last, ok := is.nextIdiom(is.strategyFor(ss), ss.mode, idiom, ss.used)
 	if !ok { // 找不到下一个成语，提前结束
 		ss.finished = true
-		is.sessions.Delete(ss.key)
 		return SolitaireEnd, ""
 	}
 
 	// 返回新的成语
-	ss.current = next
-	ss.lastRune = last
+	ss.advance(next, last)
 
 	return SolitaireSucceed, next
 }
@@ -198,32 +400,123 @@ func (is *IdiomsSolitaire) Solitaire(ss *Session, idiom, id string) (int, string
 
 // }
 
-// nextIdiom 找到可以被接龙的下一个成语（并且不等同于当前词），返回false说明无法再往下接
-func (is *IdiomsSolitaire) nextIdiom(idiom string) (string, rune, bool) {
-	// 检查是否在成语库
-	last, ok := is.idioms[idiom]
-	if !ok {
+// nextIdiom 按照 mode 的规则与策略选择可以被接龙的下一个成语（不等同于当前词，不在 used 中，并且还能继续接下去），返回false说明无法再往下接
+func (is *IdiomsSolitaire) nextIdiom(strategy Strategy, mode ChainMode, idiom string, used map[string]struct{}) (string, rune, bool) {
+	m := &moves{is: is, mode: mode, used: used}
+	var candidates []string
+	for _, next := range m.Next(idiom) {
+		if is.continuable(mode, next) {
+			candidates = append(candidates, next)
+		}
+	}
+	if len(candidates) == 0 {
 		return "", 0, false
 	}
-	// 根据最后一个字，取对应的成语列表
-	list, ok := is.dict[last]
+	next := strategy.Reply(m, candidates)
+	return next, is.idioms[next], true
+}
+
+// Hint 分阶段提示当前成语的一个接法：先露出首字，再给出拼音，没有拼音时露出前两个字，
+// 每次露出新的内容都记在用户 id 上，结算时扣分。返回 SolitaireSucceed 与提示，
+// 会话已经超时返回 SolitaireTimeout，想不出接法时返回 0
+func (is *IdiomsSolitaire) Hint(ss *Session, id string) (int, string) {
+	var answer string
+	var stage int
+	ret := is.update(ss, func(ss *Session) int {
+		if !is.alive(ss) {
+			return SolitaireTimeout
+		}
+		var ok bool
+		if answer, ok = is.hintAnswer(ss); !ok {
+			return 0
+		}
+		if ss.hint.stage < 2 {
+			ss.hint.stage++
+			ss.assistOf(id).Hints++
+		}
+		stage = ss.hint.stage
+		return SolitaireSucceed
+	})
+	if ret != SolitaireSucceed {
+		return ret, ""
+	}
+
+	runes := []rune(answer)
+	shown := 1
+	reading, hasReading := is.reading[answer]
+	if stage > 1 && !hasReading {
+		shown = 2
+	}
+	for i := shown; i < len(runes); i++ {
+		runes[i] = '＿'
+	}
+	if stage > 1 && hasReading {
+		return ret, string(runes) + "（" + reading + "）"
+	}
+	return ret, string(runes)
+}
+
+// hintAnswer 这一轮提示的成语，同一轮的提示都针对同一个成语
+func (is *IdiomsSolitaire) hintAnswer(ss *Session) (string, bool) {
+	if len(ss.hint.answer) > 0 {
+		return ss.hint.answer, true
+	}
+	next, _, ok := is.nextIdiom(is.strategyFor(ss), ss.mode, ss.current, ss.used)
 	if !ok {
-		return "", 0, false
+		return "", false
 	}
+	ss.hint.answer = next
+	return next, true
+}
 
-	// 遍历列表，找到一个可以被接龙的成语
-	for i := range list {
-		next := list[i]
-		if next == idiom {
-			continue
+// Skip 用户 id 放弃这一轮，机器人给出一个接法并进入下一轮
+func (is *IdiomsSolitaire) Skip(ss *Session, id string) (int, string) {
+	var next string
+	ret := is.update(ss, func(ss *Session) int {
+		if !is.alive(ss) {
+			return SolitaireTimeout
 		}
-		last := is.idioms[next]
-		if _, ok := is.dict[last]; !ok {
-			continue
+		var ok bool
+		if next, ok = is.hintAnswer(ss); !ok { // 找不到下一个成语，直接结束
+			ss.finished = true
+			return SolitaireEnd
+		}
+		ss.assistOf(id).Skips++
+		ss.perfOf(id).Streak = 0
+		ss.trun += 1
+		ss.advance(next, is.idioms[next])
+
+		if ss.trun >= is.maxTurn { // 接龙完成
+			ss.finished = true
+			return SolitaireFailComplete
+		}
+		return SolitaireSkipped
+	})
+	if unsaved(ret) {
+		return ret, ""
+	}
+	return ret, next
+}
+
+// Answers 列出最多 maxAnswers 个可以接上当前成语的接法，用户 id 查看答案结算时扣分，这一轮可以继续作答。
+// 会话已经超时返回 SolitaireTimeout
+func (is *IdiomsSolitaire) Answers(ss *Session, id string) (int, []string) {
+	var answers []string
+	ret := is.update(ss, func(ss *Session) int {
+		if !is.alive(ss) {
+			return SolitaireTimeout
+		}
+		m := &moves{is: is, mode: ss.mode, used: ss.used}
+		answers = m.Head(ss.current, maxAnswers)
+		if len(answers) > 0 {
+			ss.assistOf(id).Answers++
 		}
-		return list[i], last, true
+		return SolitaireSucceed
+	})
+	if ret != SolitaireSucceed {
+		return ret, nil
 	}
-	return "", 0, false
+	return ret, answers
 }
 
 func (is *IdiomsSolitaire) isValidIdiom(idiom string) bool {
@@ -233,27 +526,172 @@ func (is *IdiomsSolitaire) isValidIdiom(idiom string) bool {
 
 type Session struct {
 	key      string
-	current  string         // 当前的成语
-	lastRune rune           //
-	miss     int            //  接龙失败了几次
-	hits     map[string]int // 接龙成功的情况
+	current  string                  // 当前的成语
+	lastRune rune                    //
+	mode     ChainMode               // 接龙的规则
+	strategy Strategy                // 选词的策略，为空时使用默认的策略
+	used     map[string]struct{}     // 本次会话已经用过的成语
+	miss     int                     //  接龙失败了几次
+	hits     map[string]int          // 接龙成功的情况
+	assists  map[string]*Assist      // 用户使用提示、跳过与答案的情况
+	perf     map[string]*Performance // 用户答错与连续答对的情况
+	hint     hintState               // 这一轮的提示
 
 	trun int //
 
 	lastAccess int64 // 上次访问的时间
 
-	sync.Mutex
+	conv conversation // 会话所在的聊天，超时后推送结算
 
+	version  uint64 // 存储中的版本，每次保存加一
 	finished bool
 }
 
+// clone 深拷贝会话，存储中的会话不会被修改
+func (s *Session) clone() *Session {
+	c := *s
+	if s.used != nil {
+		c.used = make(map[string]struct{}, len(s.used))
+		for k := range s.used {
+			c.used[k] = struct{}{}
+		}
+	}
+	if s.hits != nil {
+		c.hits = make(map[string]int, len(s.hits))
+		for k, v := range s.hits {
+			c.hits[k] = v
+		}
+	}
+	if s.assists != nil {
+		c.assists = make(map[string]*Assist, len(s.assists))
+		for k, v := range s.assists {
+			a := *v
+			c.assists[k] = &a
+		}
+	}
+	if s.perf != nil {
+		c.perf = make(map[string]*Performance, len(s.perf))
+		for k, v := range s.perf {
+			p := *v
+			c.perf[k] = &p
+		}
+	}
+	return &c
+}
+
 func (s *Session) Idiom() string {
 	return s.current
 }
 
+// use 记录用过的成语
+func (s *Session) use(idiom string) {
+	if s.used == nil {
+		s.used = make(map[string]struct{})
+	}
+	s.used[idiom] = struct{}{}
+}
+
+// advance 进入下一轮
+func (s *Session) advance(next string, last rune) {
+	s.use(next)
+	s.miss = 0
+	s.current = next
+	s.lastRune = last
+	s.hint = hintState{}
+}
+
+// Assist 用户使用提示、跳过与查看答案的次数
+type Assist struct {
+	Hints   int `json:"hints"`
+	Skips   int `json:"skips"`
+	Answers int `json:"answers"`
+}
+
+// cost 结算时扣的分
+func (a Assist) cost() int {
+	return a.Hints*costHint + a.Skips*costSkip + a.Answers*costAnswers
+}
+
+// Performance 用户在本局中答错与连续答对的次数
+type Performance struct {
+	Misses   int   `json:"misses"`
+	Streak   int   `json:"streak"`              // 当前连续答对的次数，答错或跳过时清零
+	Longest  int   `json:"longest"`             // 最长连续答对的次数
+	FirstHit int64 `json:"first_hit,omitempty"` // 第一次答对的时间，单位纳秒，结算时先答对的排在前面
+}
+
+// hit 在 at 时答对
+func (p *Performance) hit(at int64) {
+	if p.FirstHit == 0 {
+		p.FirstHit = at
+	}
+	p.Streak++
+	if p.Streak > p.Longest {
+		p.Longest = p.Streak
+	}
+}
+
+func (p *Performance) missed() {
+	p.Misses++
+	p.Streak = 0
+}
+
+// perfOf 用户在本局中的表现
+func (s *Session) perfOf(id string) *Performance {
+	if s.perf == nil {
+		s.perf = make(map[string]*Performance)
+	}
+	p, ok := s.perf[id]
+	if !ok {
+		p = &Performance{}
+		s.perf[id] = p
+	}
+	return p
+}
+
+// hintState 这一轮提示的成语与已经提示到的阶段
+type hintState struct {
+	answer string
+	stage  int
+}
+
+// assistOf 用户的提示记录
+func (s *Session) assistOf(id string) *Assist {
+	if s.assists == nil {
+		s.assists = make(map[string]*Assist)
+	}
+	a, ok := s.assists[id]
+	if !ok {
+		a = &Assist{}
+		s.assists[id] = a
+	}
+	return a
+}
+
+// Mode 会话接龙的规则
+func (s *Session) Mode() ChainMode {
+	return s.mode
+}
+
+// conversation 会话所在的聊天
+type conversation struct {
+	kind  sgroupbot.TargetKind
+	to    string
+	guild string // 频道中的会话所在的频道
+}
+
+// Conversation 返回会话所在的聊天，没有记录时 to 为空
+func (s *Session) Conversation() (sgroupbot.TargetKind, string) {
+	return s.conv.kind, s.conv.to
+}
+
 type HitCnt struct {
-	Name  string
-	Count int
+	Name     string // 用户ID，展示时通过 ApiServer.displayName 转换为名字
+	Count    int
+	Score    int    // 接对得分减去提示、跳过与答案的扣分
+	Assist   Assist // 使用提示、跳过与答案的次数
+	Misses   int    // 答错的次数
+	FirstHit int64  // 第一次答对的时间
 }
 
 type HitCntList []HitCnt
@@ -261,8 +699,25 @@ type HitCntList []HitCnt
 func (hc HitCntList) Len() int {
 	return len(hc)
 }
+
+// Less 答对多的在前，其次答错少的在前，再其次先答对的在前
 func (hc HitCntList) Less(i, j int) bool {
-	return hc[i].Count < hc[j].Count
+	a, b := hc[i], hc[j]
+	if a.Count != b.Count {
+		return a.Count > b.Count
+	}
+	if a.Misses != b.Misses {
+		return a.Misses < b.Misses
+	}
+	if a.FirstHit != b.FirstHit {
+		return a.FirstHit < b.FirstHit
+	}
+	return a.Name < b.Name
+}
+
+// tied 名次是否并列：答对与答错的次数都相同。每一轮只有一个人答对，第一次答对的时间不会相同，只用于排序
+func (a HitCnt) tied(b HitCnt) bool {
+	return a.Count == b.Count && a.Misses == b.Misses
 }
 
 func (hc HitCntList) Swap(i, j int) {
@@ -271,12 +726,30 @@ func (hc HitCntList) Swap(i, j int) {
 	hc[j] = temp
 }
 
+// Hits 会话中每个用户的成绩，只用过提示、跳过、答案或者只答错过的用户也在其中，得分可能为负
 func (s *Session) Hits() []HitCnt {
-	s.Lock()
-	defer s.Unlock()
-	var hits = make([]HitCnt, 0, len(s.hits))
-	for k, v := range s.hits {
-		hits = append(hits, HitCnt{k, v})
+	ids := make(map[string]struct{}, len(s.hits))
+	for k := range s.hits {
+		ids[k] = struct{}{}
+	}
+	for k := range s.assists {
+		ids[k] = struct{}{}
+	}
+	for k := range s.perf {
+		ids[k] = struct{}{}
+	}
+	var hits = make([]HitCnt, 0, len(ids))
+	for k := range ids {
+		v := s.hits[k]
+		hit := HitCnt{Name: k, Count: v, Score: v * scoreHit}
+		if a, ok := s.assists[k]; ok {
+			hit.Assist = *a
+			hit.Score -= a.cost()
+		}
+		if p, ok := s.perf[k]; ok {
+			hit.Misses, hit.FirstHit = p.Misses, p.FirstHit
+		}
+		hits = append(hits, hit)
 	}
 
 	sort.Sort(HitCntList(hits))
@@ -24,3 +24,149 @@ func TestIdiomsSolitaire(t *testing.T) {
 	})
 
 }
+
+func TestSolitaireRepeat(t *testing.T) {
+	is := NewIdiomsSolitaire(testIdioms(), 60)
+	is.SetRules(10, 3)
+
+	// 心想事成 -> 成千上万 -> 万众一心 -> 心想事成 成环
+	ss, _ := is.SessionOrCreate("g1")
+	// 忽略随机的开局成语
+	ss.used = nil
+	ss.current, ss.lastRune = "上下一心", '心'
+	ss.use(ss.current)
+
+	ret, next := is.Solitaire(ss, "心想事成", "u1")
+	if ret != SolitaireSucceed || next != "成千上万" {
+		t.Fatalf("succeed: %d %s", ret, next)
+	}
+	ret, next = is.Solitaire(ss, "万众一心", "u1")
+	if ret != SolitaireEnd {
+		// 万众一心 之后只能接 心想事成，已经用过了
+		t.Fatalf("end: %d %s", ret, next)
+	}
+
+	ss, _ = is.SessionOrCreate("g2")
+	ss.used = nil
+	ss.current, ss.lastRune = "成千上万", '万'
+	ss.use("万众一心")
+	if ret, _ := is.Solitaire(ss, "万众一心", "u1"); ret != SolitaireRepeated {
+		t.Errorf("repeated: %d", ret)
+	}
+	if ss.miss != 0 {
+		t.Errorf("repeat should not count as miss: %d", ss.miss)
+	}
+}
+
+func TestSolitaireMissDeadEnd(t *testing.T) {
+	is := NewIdiomsSolitaire(testIdioms(), 60)
+	is.SetRules(10, 1)
+
+	// 先发制人 之后只能接 人山人海，已经用过了
+	ss, _ := is.SessionOrCreate("g1")
+	ss.used = nil
+	ss.current, ss.lastRune = "先发制人", '人'
+	ss.use("人山人海")
+	ss.use(ss.current)
+
+	ret, next := is.Solitaire(ss, "不是成语", "u1")
+	if ret != SolitaireEnd || len(next) > 0 {
+		t.Fatalf("dead end: %d %s", ret, next)
+	}
+	if !ss.finished || !is.outOfMisses(ss) {
+		t.Errorf("session should be finished after misses: %+v", ss)
+	}
+	if is.Session("g1") != nil {
+		t.Error("finished session should be removed")
+	}
+}
+
+func TestSolitaireAssist(t *testing.T) {
+	is := NewIdiomsSolitaire(testIdioms(), 60)
+	is.SetRules(10, 3)
+
+	ss, _ := is.SessionOrCreate("g1")
+	ss.used = nil
+	ss.current, ss.lastRune = "一马当先", '先'
+	ss.use(ss.current)
+
+	// 没有拼音时第二次提示露出前两个字，之后不再扣分
+	for _, want := range []string{"先＿＿＿", "先发＿＿", "先发＿＿"} {
+		if ret, hint := is.Hint(ss, "u1"); ret != SolitaireSucceed || hint != want {
+			t.Errorf("hint: %d %s, want %s", ret, hint, want)
+		}
+	}
+	if ret, answers := is.Answers(ss, "u1"); ret != SolitaireSucceed || len(answers) != 1 || answers[0] != "先发制人" {
+		t.Errorf("answers: %d %v", ret, answers)
+	}
+	if ret, next := is.Skip(ss, "u1"); ret != SolitaireSkipped || next != "先发制人" || ss.Idiom() != "先发制人" {
+		t.Errorf("skip: %d %s", ret, next)
+	}
+	if ss.hint.stage != 0 {
+		t.Errorf("hint should reset after skip: %+v", ss.hint)
+	}
+	if ret, _ := is.Solitaire(ss, "人山人海", "u1"); ret != SolitaireSucceed {
+		t.Errorf("solitaire: %d", ret)
+	}
+
+	hits := ss.Hits()
+	want := HitCnt{Name: "u1", Count: 1, Score: scoreHit - 2*costHint - costSkip - costAnswers, Assist: Assist{Hints: 2, Skips: 1, Answers: 1},
+		FirstHit: ss.perf["u1"].FirstHit}
+	if len(hits) != 1 || hits[0] != want || want.FirstHit == 0 {
+		t.Errorf("hits: %+v, want %+v", hits, want)
+	}
+
+	// 有拼音时第二次提示给出拼音
+	is = NewIdiomsSolitaire(pinyinIdioms(), 60)
+	ss, _ = is.SessionOrCreate("g1")
+	ss.used = nil
+	ss.current, ss.lastRune = "知己知彼", '彼'
+	is.Hint(ss, "u1")
+	if _, hint := is.Hint(ss, "u1"); hint != "彼＿＿＿（bǐ cǐ bǐ cǐ）" {
+		t.Errorf("pinyin hint: %s", hint)
+	}
+}
+
+func TestSolitaireAssistTimeout(t *testing.T) {
+	is := NewIdiomsSolitaire(testIdioms(), 60)
+	for _, assist := range []func(ss *Session) int{
+		func(ss *Session) int { ret, _ := is.Hint(ss, "u1"); return ret },
+		func(ss *Session) int { ret, _ := is.Answers(ss, "u1"); return ret },
+	} {
+		ss, _ := is.SessionOrCreate("g1")
+		ss.lastAccess = 0
+		is.store.CompareAndSwap(ss, ss.version)
+
+		// 超时的会话不再提示，结束并返回超时
+		if ret := assist(ss); ret != SolitaireTimeout || !ss.finished {
+			t.Errorf("timeout: %d %+v", ret, ss)
+		}
+		if is.Session("g1") != nil {
+			t.Error("timed out session should be removed")
+		}
+	}
+}
+
+func TestSessionHitsAssistOnly(t *testing.T) {
+	is := NewIdiomsSolitaire(testIdioms(), 60)
+	ss, _ := is.SessionOrCreate("g1")
+	ss.used = nil
+	ss.current, ss.lastRune = "一马当先", '先'
+	ss.use(ss.current)
+
+	// u2 只用了答案，u3 只答错过，扣分与答错都要算进去
+	is.Answers(ss, "u2")
+	is.Solitaire(ss, "不是成语", "u3")
+	is.Solitaire(ss, "先发制人", "u1")
+
+	got := map[string]HitCnt{}
+	for _, hit := range ss.Hits() {
+		got[hit.Name] = hit
+	}
+	if len(got) != 3 || got["u1"].Score != scoreHit || got["u2"].Score != -costAnswers || got["u3"].Misses != 1 {
+		t.Errorf("hits: %+v", got)
+	}
+	if hits := ss.Hits(); hits[0].Name != "u1" {
+		t.Errorf("order: %+v", hits)
+	}
+}
@@ -1,54 +1,157 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"sgroupbot"
+	"syscall"
+	"time"
 )
 
-// 机器人配置
-var ticket = sgroupbot.Ticket{
-	AppID: 000,
-	Token: "xxx",
-}
-
+// 默认的成语库路径，可以通过配置 idioms_path 修改
 var idiomsPath = "./idioms.json"
 
 func main() {
+	// 回放录制的网关消息
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:]); err != nil {
+			log.Println("replay", err)
+		}
+		return
+	}
+	// 分析成语库的接龙图
+	if len(os.Args) > 1 && os.Args[1] == "analyze" {
+		if err := runAnalyze(os.Args[2:]); err != nil {
+			log.Println("analyze", err)
+		}
+		return
+	}
+
+	cfg, err := LoadConfig(flag.CommandLine, os.Args[1:])
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.Println("config", err)
+		os.Exit(2)
+	}
+	level, _ := sgroupbot.ParseLevel(cfg.LogLevel)
+	sgroupbot.DefaultLogger = sgroupbot.NewStdLogger(level)
+	sgroupbot.DefaultLogger.Info("config", "effective", cfg.String())
 
 	// 加载成语集合
-	idioms, err := LoadIdioms(idiomsPath)
+	idioms, err := LoadIdioms(cfg.IdiomsPath)
 	if err != nil || len(idioms) == 0 {
-		log.Println("read idioms fail", len(idioms), err)
+		sgroupbot.DefaultLogger.Error("load_idioms", "path", cfg.IdiomsPath, "count", len(idioms), "err", err)
 		return
 	}
-	log.Println("load idioms, count", len(idioms))
+	sgroupbot.DefaultLogger.Info("load_idioms", "count", len(idioms))
 
 	// 构建频道机器人api
 	var api = sgroupbot.API{
-		Target:   sgroupbot.SandboxSgroupTarget,
-		Ticket:   ticket,
+		Target:   cfg.Target(),
+		Ticket:   cfg.Ticket(),
 		Handlers: make(map[string]sgroupbot.EventHandler),
 	}
 
+	// 录制网关消息，用于离线回放
+	if len(cfg.Record) > 0 {
+		f, err := os.OpenFile(cfg.Record, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			sgroupbot.DefaultLogger.Error("open_record", "file", cfg.Record, "err", err)
+			return
+		}
+		api.Recorder = sgroupbot.NewRecorder(f)
+		defer api.Recorder.Close()
+	}
+
 	// 构建成语接龙服务
-	var is = NewIdiomsSolitaire(idioms, 60*5)
+	var is = NewIdiomsSolitaire(idioms, cfg.Game.ExpiredTime)
+	is.SetRules(cfg.Game.MaxTurn, cfg.Game.MaxMiss)
+	is.SetStrategy(cfg.Game.NewStrategy())
+	store, err := newSessionStore(cfg.SessionStore)
+	if err != nil {
+		sgroupbot.DefaultLogger.Error("session_store", "spec", cfg.SessionStore, "err", err)
+		return
+	}
+	is.SetStore(store)
+
+	// 恢复上次退出时正在进行的会话
+	if len(cfg.Snapshot) > 0 {
+		n, err := is.LoadSnapshot(cfg.Snapshot)
+		if err != nil {
+			sgroupbot.DefaultLogger.Error("restore_snapshot", "file", cfg.Snapshot, "restored", n, "err", err)
+		} else {
+			sgroupbot.DefaultLogger.Info("restore_snapshot", "file", cfg.Snapshot, "restored", n)
+		}
+	}
+	graph := is.Graph().Summary()
+	sgroupbot.DefaultLogger.Info("idiom_graph", "dead_ends", graph.DeadEnds, "components", graph.Components,
+		"largest_component", graph.LargestComponent, "openings", len(is.openings))
 
 	// 整合api_server
 	var s = NewApiServer(&api, is)
+	s.Configure(cfg)
 
-	if err := s.Start(); err != nil {
-		log.Println("startWs", err)
+	// 用户战绩
+	if len(cfg.StatsPath) > 0 {
+		repo, err := NewFileStatsRepository(cfg.StatsPath)
+		if err != nil {
+			sgroupbot.DefaultLogger.Error("open_stats", "file", cfg.StatsPath, "err", err)
+			return
+		}
+		defer repo.Close()
+		stats := NewStats(repo)
+		if err := stats.SetRetention(time.Duration(cfg.StatsRetention) * 24 * time.Hour); err != nil {
+			sgroupbot.DefaultLogger.Warn("trim_stats", "file", cfg.StatsPath, "err", err)
+		}
+		s.SetStats(stats)
+	}
+
+	// Prometheus 指标
+	if len(cfg.MetricsAddr) > 0 {
+		reg := sgroupbot.NewRegistry()
+		api.EnableMetrics(reg)
+		s.EnableMetrics(reg)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", reg)
+		go func() {
+			if err := http.ListenAndServe(cfg.MetricsAddr, mux); err != nil {
+				api.Log().Error("metrics_server", "addr", cfg.MetricsAddr, "err", err)
+			}
+		}()
+	}
+
+	// 收到退出信号后断开网关，等待正在处理的消息完成
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := s.Run(ctx); err != nil {
+		api.Log().Error("startWs", "err", err)
+	}
+	// 消息处理完成之后保存会话，下次启动时恢复
+	if len(cfg.Snapshot) > 0 {
+		n, err := is.SaveSnapshot(cfg.Snapshot)
+		if err != nil {
+			api.Log().Error("save_snapshot", "file", cfg.Snapshot, "err", err)
+		} else {
+			api.Log().Info("save_snapshot", "file", cfg.Snapshot, "sessions", n)
+		}
 	}
+	api.Log().Info("shutdown")
 
 }
 
 func LoadIdioms(path string) ([]Idiom, error) {
-	f, err := os.Open(idiomsPath)
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
 	var idioms []Idiom
 	if err := json.NewDecoder(f).Decode(&idioms); err != nil {
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sgroupbot"
+)
+
+// serverMetrics 成语接龙服务的指标
+type serverMetrics struct {
+	syncFallbacks *sgroupbot.Counter // 线程池已满，同步处理的消息数
+	games         *sgroupbot.Counter // 开始的接龙会话数
+	rounds        *sgroupbot.Counter // 进入下一轮的次数
+	results       *sgroupbot.Counter // 按结果统计接龙次数
+}
+
+// EnableMetrics 在 reg 中注册线程池与成语接龙的指标
+func (s *ApiServer) EnableMetrics(reg *sgroupbot.Registry) {
+	s.metrics = &serverMetrics{
+		syncFallbacks: reg.Counter("sgroupbot_pool_sync_fallbacks_total", "Messages handled synchronously because the pool was full."),
+		games:         reg.Counter("sgroupbot_solitaire_games_total", "Solitaire sessions started."),
+		rounds:        reg.Counter("sgroupbot_solitaire_rounds_total", "Solitaire rounds advanced."),
+		results:       reg.Counter("sgroupbot_solitaire_results_total", "Solitaire answers by result.", "result"),
+	}
+	reg.GaugeFunc("sgroupbot_pool_running", "Running workers in the message pool.", func() float64 {
+		return float64(s.pool.Running())
+	})
+	reg.GaugeFunc("sgroupbot_pool_capacity", "Capacity of the message pool.", func() float64 {
+		return float64(s.pool.Cap())
+	})
+	reg.GaugeFunc("sgroupbot_solitaire_sessions", "Active solitaire sessions.", func() float64 {
+		return float64(s.is.SessionCount())
+	})
+}
+
+// observeResult 记录一次接龙的结果
+func (m *serverMetrics) observeResult(ret int) {
+	m.results.Inc(solitaireResultName(ret))
+	if ret == SolitaireSucceed || ret == SolitaireFailedToNext || ret == SolitaireSkipped {
+		m.rounds.Inc()
+	}
+}
+
+// solitaireResultName 指标中使用的结果名称
+func solitaireResultName(ret int) string {
+	switch ret {
+	case SolitaireFailed:
+		return "failed"
+	case SolitaireFailedToNext:
+		return "failed_to_next"
+	case SolitaireSucceed:
+		return "succeed"
+	case SolitaireEnd:
+		return "end"
+	case SolitaireCompleted:
+		return "completed"
+	case SolitaireTimeout:
+		return "timeout"
+	case SolitaireCanceled:
+		return "canceled"
+	case SolitaireFailComplete:
+		return "fail_complete"
+	case SolitaireRepeated:
+		return "repeated"
+	case SolitaireSkipped:
+		return "skipped"
+	case SolitaireConflict:
+		return "conflict"
+	case SolitaireStoreError:
+		return "store_error"
+	default:
+		return "unknown"
+	}
+}
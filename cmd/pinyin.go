@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// ChainMode 接龙的规则
+type ChainMode int
+
+const (
+	ChainChar     ChainMode = iota // 同字接龙，首字与上一个成语的尾字相同
+	ChainToneless                  // 同音接龙，首字读音与尾字相同，不区分声调
+	ChainTone                      // 同音同调接龙，首字读音与声调都要与尾字相同
+)
+
+// chainCommands 开始接龙的指令
+var chainCommands = map[string]ChainMode{
+	"成语接龙":   ChainChar,
+	"同音接龙":   ChainToneless,
+	"同音同调接龙": ChainTone,
+}
+
+// Name 接龙的名称，与开始的指令相同
+func (m ChainMode) Name() string {
+	switch m {
+	case ChainToneless:
+		return "同音接龙"
+	case ChainTone:
+		return "同音同调接龙"
+	default:
+		return "成语接龙"
+	}
+}
+
+// syllables 成语首字与尾字的读音
+type syllables struct {
+	first, last string
+}
+
+// pinyinIndex 按读音索引成语
+type pinyinIndex struct {
+	words map[string]syllables // word -> 首尾读音
+	dict  map[string][]string  // 首字读音 -> word_list
+}
+
+func newPinyinIndex() *pinyinIndex {
+	return &pinyinIndex{
+		words: make(map[string]syllables),
+		dict:  make(map[string][]string),
+	}
+}
+
+func (idx *pinyinIndex) add(word string, s syllables) {
+	if len(s.first) == 0 || len(s.last) == 0 {
+		return
+	}
+	idx.words[word] = s
+	idx.dict[s.first] = append(idx.dict[s.first], word)
+}
+
+// buildPinyinIndexes 建立同音与同音同调的索引，pinyin 为空时使用 pinyin_r，只能用于同音接龙
+func buildPinyinIndexes(idioms []Idiom) map[ChainMode]*pinyinIndex {
+	tone, toneless := newPinyinIndex(), newPinyinIndex()
+	for i := range idioms {
+		idiom := &idioms[i]
+		if py := splitPinyin(idiom.Pinyin); len(py) > 0 {
+			first, last := strings.ToLower(py[0]), strings.ToLower(py[len(py)-1])
+			tone.add(idiom.Word, syllables{first, last})
+			toneless.add(idiom.Word, syllables{removeTone(first), removeTone(last)})
+		} else if py := splitPinyin(idiom.PingyinR); len(py) > 0 {
+			toneless.add(idiom.Word, syllables{removeTone(py[0]), removeTone(py[len(py)-1])})
+		}
+	}
+	return map[ChainMode]*pinyinIndex{ChainTone: tone, ChainToneless: toneless}
+}
+
+// splitPinyin 按音节拆分拼音，忽略空格与标点
+func splitPinyin(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// 带声调的韵母
+var toneMarks = map[rune]rune{
+	'ā': 'a', 'á': 'a', 'ǎ': 'a', 'à': 'a',
+	'ē': 'e', 'é': 'e', 'ě': 'e', 'è': 'e',
+	'ī': 'i', 'í': 'i', 'ǐ': 'i', 'ì': 'i',
+	'ō': 'o', 'ó': 'o', 'ǒ': 'o', 'ò': 'o',
+	'ū': 'u', 'ú': 'u', 'ǔ': 'u', 'ù': 'u',
+	'ǖ': 'v', 'ǘ': 'v', 'ǚ': 'v', 'ǜ': 'v', 'ü': 'v',
+	'ń': 'n', 'ň': 'n', 'ǹ': 'n', 'ḿ': 'm',
+}
+
+// removeTone 去掉音节的声调，ü 统一为 v，同时去掉数字标注的声调，如 yi1
+func removeTone(s string) string {
+	return strings.Map(func(r rune) rune {
+		r = unicode.ToLower(r)
+		if plain, ok := toneMarks[r]; ok {
+			return plain
+		}
+		if unicode.IsDigit(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// linked answer 能否接上 current，读音相同的规则下同字也可以接上
+func (is *IdiomsSolitaire) linked(mode ChainMode, current, answer string) bool {
+	last, ok := is.idioms[current]
+	if !ok || len(answer) == 0 {
+		return false
+	}
+	if []rune(answer)[0] == last {
+		return true
+	}
+	idx, ok := is.pinyin[mode]
+	if !ok {
+		return false
+	}
+	c, ok1 := idx.words[current]
+	a, ok2 := idx.words[answer]
+	return ok1 && ok2 && c.last == a.first
+}
+
+// continuable 按照 mode 的规则，idiom 之后是否还有成语可以接
+func (is *IdiomsSolitaire) continuable(mode ChainMode, idiom string) bool {
+	last, ok := is.idioms[idiom]
+	if !ok {
+		return false
+	}
+	if _, ok := is.dict[last]; ok {
+		return true
+	}
+	if idx, ok := is.pinyin[mode]; ok {
+		if s, ok := idx.words[idiom]; ok {
+			_, ok = idx.dict[s.last]
+			return ok
+		}
+	}
+	return false
+}
+
+// candidates 按照 mode 的规则，可以接在 idiom 之后的成语，同字的排在前面
+func (is *IdiomsSolitaire) candidates(mode ChainMode, idiom string) [][]string {
+	last, ok := is.idioms[idiom]
+	if !ok {
+		return nil
+	}
+	lists := [][]string{is.dict[last]}
+	if idx, ok := is.pinyin[mode]; ok {
+		if s, ok := idx.words[idiom]; ok {
+			lists = append(lists, idx.dict[s.last])
+		}
+	}
+	return lists
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+)
+
+func pinyinIdioms() []Idiom {
+	data := [][2]string{
+		{"一马当先", "yī mǎ dāng xiān"},
+		{"鲜为人知", "xiān wéi rén zhī"},
+		{"闲云野鹤", "xián yún yě hè"},
+		{"知己知彼", "zhī jǐ zhī bǐ"},
+		{"彼此彼此", "bǐ cǐ bǐ cǐ"},
+		{"此起彼伏", "cǐ qǐ bǐ fú"},
+	}
+	idioms := make([]Idiom, 0, len(data))
+	for _, d := range data {
+		runes := []rune(d[0])
+		idioms = append(idioms, Idiom{
+			Word:      d[0],
+			Pinyin:    d[1],
+			FirstRune: runes[0],
+			LastRune:  runes[len(runes)-1],
+		})
+	}
+	return idioms
+}
+
+func TestRemoveTone(t *testing.T) {
+	for s, want := range map[string]string{"xiān": "xian", "lǜ": "lv", "nǚ": "nv", "yi1": "yi", "ÀI": "ai"} {
+		if got := removeTone(s); got != want {
+			t.Errorf("removeTone(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestChainMode(t *testing.T) {
+	is := NewIdiomsSolitaire(pinyinIdioms(), 60)
+
+	for _, c := range []struct {
+		mode            ChainMode
+		current, answer string
+		want            bool
+	}{
+		{ChainChar, "知己知彼", "彼此彼此", true},
+		{ChainChar, "一马当先", "鲜为人知", false},
+		{ChainTone, "一马当先", "鲜为人知", true},
+		{ChainTone, "一马当先", "闲云野鹤", false},
+		{ChainToneless, "一马当先", "闲云野鹤", true},
+		{ChainToneless, "一马当先", "知己知彼", false},
+	} {
+		if got := is.linked(c.mode, c.current, c.answer); got != c.want {
+			t.Errorf("%s %s -> %s: got %v", c.mode.Name(), c.current, c.answer, got)
+		}
+	}
+
+	// 同字接龙接不下去，同音可以接，闲云野鹤之后接不下去，不会被选中
+	if _, _, ok := is.nextIdiom(is.strategy, ChainChar, "一马当先", nil); ok {
+		t.Error("char mode should have no continuation")
+	}
+	if next, _, ok := is.nextIdiom(is.strategy, ChainToneless, "一马当先", nil); !ok || next != "鲜为人知" {
+		t.Errorf("toneless next: %s %v", next, ok)
+	}
+
+	ss, _ := is.SessionOrCreateWith("g1", SessionOptions{Mode: ChainTone})
+	ss.used = nil
+	ss.current, ss.lastRune = "一马当先", '先'
+	if ret, next := is.Solitaire(ss, "鲜为人知", "u1"); ret != SolitaireSucceed || next != "知己知彼" {
+		t.Errorf("solitaire: %d %s", ret, next)
+	}
+}
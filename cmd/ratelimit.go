@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// rateLimiter 按发送对象（群、用户、子频道）限制下行消息的频率，令牌桶实现
+type rateLimiter struct {
+	rate    float64 // 每秒生成的令牌数
+	burst   float64
+	buckets *xsync.MapOf[string, *bucket]
+}
+
+type bucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: xsync.NewMapOf[string, *bucket](),
+	}
+}
+
+// reserve 取一个令牌，返回需要等待的时间
+func (l *rateLimiter) reserve(key string, now time.Time) time.Duration {
+	b, _ := l.buckets.LoadOrCompute(key, func() *bucket {
+		return &bucket{tokens: l.burst, last: now}
+	})
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / l.rate * float64(time.Second))
+}
+
+// Wait 等待直到可以向 key 发送消息
+func (l *rateLimiter) Wait(key string) {
+	if d := l.reserve(key, time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// cleanup 删除已经回满的令牌桶，避免发送对象越来越多
+func (l *rateLimiter) cleanup(now time.Time) {
+	l.buckets.Range(func(key string, b *bucket) bool {
+		b.mu.Lock()
+		full := b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst
+		b.mu.Unlock()
+		if full {
+			l.buckets.Delete(key)
+		}
+		return true
+	})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(5, 2)
+	now := time.Now()
+	if d := l.reserve("c1", now); d != 0 {
+		t.Errorf("first: %v", d)
+	}
+	if d := l.reserve("c1", now); d != 0 {
+		t.Errorf("burst: %v", d)
+	}
+	if d := l.reserve("c1", now); d != 200*time.Millisecond {
+		t.Errorf("limited: %v", d)
+	}
+	// 不同的发送对象互不影响
+	if d := l.reserve("c2", now); d != 0 {
+		t.Errorf("other target: %v", d)
+	}
+	// 令牌随时间恢复
+	if d := l.reserve("c1", now.Add(time.Second)); d != 0 {
+		t.Errorf("refill: %v", d)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"sgroupbot"
+	"time"
+)
+
+// replayTransport 回放时拦截机器人发出的请求，只打印，不发往平台
+type replayTransport struct{}
+
+func (replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+		req.Body.Close()
+	}
+	sgroupbot.DefaultLogger.Info("replay_request", "method", req.Method, "api", req.URL.Path, "body", body)
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader([]byte(`{"id":"replay"}`))),
+		Request:    req,
+	}, nil
+}
+
+// runReplay 回放录制的网关消息，用于复现线上问题，消息按顺序同步处理
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := fs.Float64("speed", 0, "回放速度，1 为原速，0 为不等待")
+	shard := fs.Int("shard", -1, "只回放指定分片，-1 为全部")
+	cfg, err := LoadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: replay [-speed n] [-shard n] [-config file] record.jsonl")
+	}
+	// 回放不连接平台，不需要凭证
+	if err := cfg.validate(false); err != nil {
+		return err
+	}
+
+	idioms, err := LoadIdioms(cfg.IdiomsPath)
+	if err != nil {
+		return err
+	}
+
+	var api = sgroupbot.API{
+		Target:   "http://replay.invalid",
+		Client:   &http.Client{Transport: replayTransport{}},
+		Handlers: make(map[string]sgroupbot.EventHandler),
+	}
+	var is = NewIdiomsSolitaire(idioms, cfg.Game.ExpiredTime)
+	is.SetRules(cfg.Game.MaxTurn, cfg.Game.MaxMiss)
+	is.SetStrategy(cfg.Game.NewStrategy())
+	var s = NewApiServer(&api, is)
+	s.Configure(cfg)
+	s.limiter = nil // 回放时不限流
+	s.sync = true
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	start := time.Now()
+	if err := api.Replay(context.Background(), f, sgroupbot.ReplayOptions{Speed: *speed, Shard: *shard}); err != nil {
+		return err
+	}
+	sgroupbot.DefaultLogger.Info("replay_done", "file", fs.Arg(0), "cost", time.Since(start))
+	return nil
+}
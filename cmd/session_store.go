@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sgroupbot"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// SessionStore 会话的存储，会话按版本号乐观更新：
+// 读出会话的副本，修改之后只有存储中的版本没有变化时才能保存，否则重新读出再修改。
+// 多个实例共享同一个存储时，同一个会话的消息可以由任意实例处理
+type SessionStore interface {
+	// Load 读出会话的副本，不存在时返回 false
+	Load(key string) (*Session, bool, error)
+	// CompareAndSwap 存储中的版本为 old 时保存 ss，old 为 0 表示会话不存在时创建，
+	// 保存成功后 ss 的版本加一，创建时的版本见 initialVersion
+	CompareAndSwap(ss *Session, old uint64) (bool, error)
+	// Delete 存储中的版本为 version 时删除会话
+	Delete(key string, version uint64) (bool, error)
+	// Range 遍历上次访问早于 before 的会话副本，before 为 0 时遍历全部，f 返回 false 时停止
+	Range(before int64, f func(ss *Session) bool) error
+}
+
+// initialVersion 新建会话的版本，取当前时间而不是从 1 开始：
+// 会话删除后重新创建时，延迟到达的旧会话的更新不会因为版本相同覆盖新的会话
+func initialVersion() uint64 {
+	return uint64(time.Now().UnixNano())
+}
+
+// memoryStore 内存中的会话存储，重启后会话丢失
+type memoryStore struct {
+	sessions *xsync.MapOf[string, *Session]
+}
+
+// NewMemoryStore 内存中的会话存储
+func NewMemoryStore() SessionStore {
+	return &memoryStore{sessions: xsync.NewMapOf[string, *Session]()}
+}
+
+func (m *memoryStore) Load(key string) (*Session, bool, error) {
+	ss, ok := m.sessions.Load(key)
+	if !ok {
+		return nil, false, nil
+	}
+	return ss.clone(), true, nil
+}
+
+func (m *memoryStore) CompareAndSwap(ss *Session, old uint64) (bool, error) {
+	saved := ss.clone()
+	saved.version = old + 1
+	if old == 0 {
+		saved.version = initialVersion()
+	}
+	var swapped bool
+	m.sessions.Compute(ss.key, func(cur *Session, loaded bool) (*Session, bool) {
+		if (!loaded && old == 0) || (loaded && cur.version == old) {
+			swapped = true
+			return saved, false
+		}
+		return cur, !loaded
+	})
+	if swapped {
+		ss.version = saved.version
+	}
+	return swapped, nil
+}
+
+func (m *memoryStore) Delete(key string, version uint64) (bool, error) {
+	var deleted bool
+	m.sessions.Compute(key, func(cur *Session, loaded bool) (*Session, bool) {
+		if loaded && cur.version == version {
+			deleted = true
+			return nil, true
+		}
+		return cur, !loaded
+	})
+	return deleted, nil
+}
+
+func (m *memoryStore) Range(before int64, f func(ss *Session) bool) error {
+	m.sessions.Range(func(key string, ss *Session) bool {
+		if before > 0 && ss.lastAccess >= before {
+			return true
+		}
+		return f(ss.clone())
+	})
+	return nil
+}
+
+// fileStore 每个会话保存为目录中的一个 json 文件，
+// 通过每个会话各自的锁文件串行化多个进程的更新，适合单机多实例或者重启后继续游戏
+type fileStore struct {
+	dir string
+}
+
+// NewFileStore 在 dir 目录中保存会话，目录不存在时创建
+func NewFileStore(dir string) (SessionStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (f *fileStore) path(key string) string {
+	return filepath.Join(f.dir, hex.EncodeToString([]byte(key))+".json")
+}
+
+func (f *fileStore) lockPath(key string) string {
+	return filepath.Join(f.dir, hex.EncodeToString([]byte(key))+".lock")
+}
+
+// lock 锁住会话的锁文件，持有锁的进程退出时由系统释放。
+// 锁文件在删除会话时一起删除，拿到锁之后检查锁住的还是目录中的文件，否则重新打开
+func (f *fileStore) lock(key string) (func(), error) {
+	name := f.lockPath(key)
+	for {
+		lf, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		unlock, err := lockFile(lf)
+		if err != nil {
+			lf.Close()
+			return nil, err
+		}
+		held, err := lf.Stat()
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		cur, err := os.Stat(name)
+		if err == nil && os.SameFile(held, cur) {
+			return unlock, nil
+		}
+		unlock()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+}
+
+func (f *fileStore) read(name string) (*Session, bool, error) {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var rec sessionRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, false, fmt.Errorf("session %s: %w", name, err)
+	}
+	return rec.session(), true, nil
+}
+
+func (f *fileStore) Load(key string) (*Session, bool, error) {
+	return f.read(f.path(key))
+}
+
+func (f *fileStore) CompareAndSwap(ss *Session, old uint64) (bool, error) {
+	unlock, err := f.lock(ss.key)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	cur, loaded, err := f.read(f.path(ss.key))
+	if err != nil {
+		return false, err
+	}
+	if (loaded && cur.version != old) || (!loaded && old != 0) {
+		return false, nil
+	}
+	rec := newSessionRecord(ss)
+	rec.Version = old + 1
+	if old == 0 {
+		rec.Version = initialVersion()
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return false, err
+	}
+	// 先写临时文件再改名，避免读到写了一半的文件
+	tmp := f.path(ss.key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, f.path(ss.key)); err != nil {
+		return false, err
+	}
+	ss.version = rec.Version
+	return true, nil
+}
+
+func (f *fileStore) Delete(key string, version uint64) (bool, error) {
+	unlock, err := f.lock(key)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	cur, loaded, err := f.read(f.path(key))
+	if err != nil || !loaded || cur.version != version {
+		return false, err
+	}
+	if err := os.Remove(f.path(key)); err != nil {
+		return false, err
+	}
+	// 持有锁时删除锁文件，等待这个锁的进程拿到锁之后会发现文件已经删除
+	if err := os.Remove(f.lockPath(key)); err != nil {
+		sgroupbot.DefaultLogger.Warn("session_store_lock", "key", key, "err", err)
+	}
+	return true, nil
+}
+
+func (f *fileStore) Range(before int64, fn func(ss *Session) bool) error {
+	names, err := filepath.Glob(filepath.Join(f.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ss, ok, err := f.read(name)
+		if err != nil {
+			// 一个会话文件损坏或者读不出来时跳过，不影响其它会话
+			sgroupbot.DefaultLogger.Warn("session_store_corrupt", "file", name, "err", err)
+			continue
+		}
+		if !ok || (before > 0 && ss.lastAccess >= before) {
+			continue
+		}
+		if !fn(ss) {
+			break
+		}
+	}
+	return nil
+}
+
+// sessionRecord 会话持久化的格式
+type sessionRecord struct {
+	Key        string                 `json:"key"`
+	Version    uint64                 `json:"version"`
+	Current    string                 `json:"current"`
+	LastRune   rune                   `json:"last_rune"`
+	Mode       ChainMode              `json:"mode"`
+	Strategy   string                 `json:"strategy,omitempty"` // 为空时使用默认的策略
+	Used       []string               `json:"used"`
+	Miss       int                    `json:"miss"`
+	Turn       int                    `json:"turn"`
+	Hits       map[string]int         `json:"hits,omitempty"`
+	Assists    map[string]Assist      `json:"assists,omitempty"`
+	Perf       map[string]Performance `json:"performance,omitempty"`
+	HintAnswer string                 `json:"hint_answer,omitempty"`
+	HintStage  int                    `json:"hint_stage,omitempty"`
+	LastAccess int64                  `json:"last_access"`
+	Kind       sgroupbot.TargetKind   `json:"kind"`
+	To         string                 `json:"to,omitempty"`
+	Guild      string                 `json:"guild,omitempty"`
+}
+
+func newSessionRecord(ss *Session) *sessionRecord {
+	rec := &sessionRecord{
+		Key:        ss.key,
+		Version:    ss.version,
+		Current:    ss.current,
+		LastRune:   ss.lastRune,
+		Mode:       ss.mode,
+		Miss:       ss.miss,
+		Turn:       ss.trun,
+		Hits:       ss.hits,
+		HintAnswer: ss.hint.answer,
+		HintStage:  ss.hint.stage,
+		LastAccess: ss.lastAccess,
+		Kind:       ss.conv.kind,
+		To:         ss.conv.to,
+		Guild:      ss.conv.guild,
+	}
+	if ss.strategy != nil {
+		rec.Strategy = ss.strategy.Name()
+	}
+	for w := range ss.used {
+		rec.Used = append(rec.Used, w)
+	}
+	sort.Strings(rec.Used)
+	if len(ss.assists) > 0 {
+		rec.Assists = make(map[string]Assist, len(ss.assists))
+		for k, a := range ss.assists {
+			rec.Assists[k] = *a
+		}
+	}
+	if len(ss.perf) > 0 {
+		rec.Perf = make(map[string]Performance, len(ss.perf))
+		for k, p := range ss.perf {
+			rec.Perf[k] = *p
+		}
+	}
+	return rec
+}
+
+func (rec *sessionRecord) session() *Session {
+	ss := &Session{
+		key:        rec.Key,
+		version:    rec.Version,
+		current:    rec.Current,
+		lastRune:   rec.LastRune,
+		mode:       rec.Mode,
+		miss:       rec.Miss,
+		trun:       rec.Turn,
+		hits:       rec.Hits,
+		hint:       hintState{answer: rec.HintAnswer, stage: rec.HintStage},
+		lastAccess: rec.LastAccess,
+		conv:       conversation{kind: rec.Kind, to: rec.To, guild: rec.Guild},
+	}
+	if len(rec.Strategy) > 0 {
+		if strategy, err := NewStrategy(rec.Strategy, defaultSeed()); err == nil {
+			ss.strategy = strategy
+		} else {
+			sgroupbot.DefaultLogger.Warn("session_strategy", "key", rec.Key, "err", err)
+		}
+	}
+	for _, w := range rec.Used {
+		ss.use(w)
+	}
+	for k, a := range rec.Assists {
+		a := a
+		if ss.assists == nil {
+			ss.assists = make(map[string]*Assist)
+		}
+		ss.assists[k] = &a
+	}
+	for k, p := range rec.Perf {
+		*ss.perfOf(k) = p
+	}
+	return ss
+}
+
+// parseSessionStore 解析配置中的会话存储，file:dir 为文件存储，为空或 memory 时使用内存
+func parseSessionStore(spec string) (kind, dir string, err error) {
+	switch {
+	case len(spec) == 0 || spec == "memory":
+		return "memory", "", nil
+	case strings.HasPrefix(spec, "file:") && len(spec) > len("file:"):
+		return "file", strings.TrimPrefix(spec, "file:"), nil
+	}
+	return "", "", fmt.Errorf("unknown session_store %q, want memory or file:dir", spec)
+}
+
+// newSessionStore 按配置创建会话存储
+func newSessionStore(spec string) (SessionStore, error) {
+	kind, dir, err := parseSessionStore(spec)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "file" {
+		return NewFileStore(dir)
+	}
+	return NewMemoryStore(), nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionStore(t *testing.T) {
+	fileStore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]SessionStore{"memory": NewMemoryStore(), "file": fileStore} {
+		t.Run(name, func(t *testing.T) {
+			ss := &Session{key: "g1", current: "一马当先", lastRune: '先', lastAccess: 100}
+			ss.use("一马当先")
+			ss.assistOf("u1").Hints++
+
+			if ok, err := store.CompareAndSwap(ss, 0); !ok || err != nil || ss.version == 0 {
+				t.Fatalf("create: %v %v %d", ok, err, ss.version)
+			}
+			created := ss.version
+			if ok, _ := store.CompareAndSwap(ss.clone(), 0); ok {
+				t.Error("create twice")
+			}
+
+			loaded, ok, err := store.Load("g1")
+			if !ok || err != nil {
+				t.Fatalf("load: %v %v", ok, err)
+			}
+			if loaded.current != "一马当先" || loaded.version != created || len(loaded.used) != 1 || loaded.assists["u1"].Hints != 1 {
+				t.Errorf("load: %+v", loaded)
+			}
+
+			// 旧版本的更新失败
+			loaded.miss = 1
+			if ok, _ := store.CompareAndSwap(loaded, created); !ok || loaded.version != created+1 {
+				t.Error("swap")
+			}
+			ss.miss = 2
+			if ok, _ := store.CompareAndSwap(ss, created); ok {
+				t.Error("stale swap")
+			}
+
+			var expired []string
+			store.Range(101, func(ss *Session) bool {
+				expired = append(expired, ss.key)
+				return true
+			})
+			if len(expired) != 1 {
+				t.Errorf("range: %v", expired)
+			}
+			expired = nil
+			store.Range(100, func(ss *Session) bool {
+				expired = append(expired, ss.key)
+				return true
+			})
+			if len(expired) != 0 {
+				t.Errorf("range not expired: %v", expired)
+			}
+
+			if ok, _ := store.Delete("g1", created); ok {
+				t.Error("stale delete")
+			}
+			if ok, _ := store.Delete("g1", created+1); !ok {
+				t.Error("delete")
+			}
+			if _, ok, _ := store.Load("g1"); ok {
+				t.Error("deleted")
+			}
+
+			// 重新创建的会话版本不会从头开始，删除前的旧版本不能覆盖新的会话
+			again := &Session{key: "g1", current: "心想事成", lastRune: '成'}
+			if ok, _ := store.CompareAndSwap(again, 0); !ok {
+				t.Fatal("recreate")
+			}
+			for _, old := range []uint64{created, created + 1} {
+				if ok, _ := store.CompareAndSwap(ss.clone(), old); ok {
+					t.Errorf("stale swap %d after recreate", old)
+				}
+				if ok, _ := store.Delete("g1", old); ok {
+					t.Errorf("stale delete %d after recreate", old)
+				}
+			}
+			if loaded, _, _ := store.Load("g1"); loaded.current != "心想事成" {
+				t.Errorf("recreated: %+v", loaded)
+			}
+		})
+	}
+}
+
+func TestFileStoreRangeCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"g1", "g3"} {
+		if ok, err := store.CompareAndSwap(&Session{key: key, current: "一马当先", lastRune: '先'}, 0); !ok || err != nil {
+			t.Fatalf("create %s: %v %v", key, ok, err)
+		}
+	}
+	// g2 的文件损坏，按文件名排在两个正常的会话之间
+	if err := os.WriteFile(store.(*fileStore).path("g2"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	if err := store.Range(0, func(ss *Session) bool {
+		keys = append(keys, ss.key)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "g1" || keys[1] != "g3" {
+		t.Errorf("range: %v", keys)
+	}
+}
+
+func TestSolitaireConcurrentUpdate(t *testing.T) {
+	is := NewIdiomsSolitaire(testIdioms(), 60)
+	ss, _ := is.SessionOrCreate("g1")
+
+	// 并发的提示都会被记录下来，版本冲突时重新执行
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			is.Answers(is.Session("g1"), "u1")
+		}()
+	}
+	wg.Wait()
+	if got := is.Session("g1").assists["u1"].Answers; got != 8 {
+		t.Errorf("answers: %d", got)
+	}
+
+	// 已经结束的会话
+	is.SessionAndDelete("g1")
+	if ret, _ := is.Solitaire(ss, answerFor(ss.Idiom()), "u1"); ret != SolitaireCanceled {
+		t.Errorf("canceled: %d", ret)
+	}
+}
+
+func TestFileStoreShared(t *testing.T) {
+	dir := t.TempDir()
+	a, b := NewIdiomsSolitaire(testIdioms(), 60), NewIdiomsSolitaire(testIdioms(), 60)
+	for _, is := range []*IdiomsSolitaire{a, b} {
+		store, err := NewFileStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		is.SetStore(store)
+	}
+
+	// 一个实例创建的会话，另一个实例可以继续
+	ss, _ := a.SessionOrCreate("g1")
+	other := b.Session("g1")
+	if other == nil || other.Idiom() != ss.Idiom() {
+		t.Fatalf("shared session: %+v", other)
+	}
+	if ret, _ := b.Solitaire(other, answerFor(other.Idiom()), "u1"); ret != SolitaireSucceed {
+		t.Errorf("solitaire: %d", ret)
+	}
+	// 旧的副本更新时读出最新的会话，答错计入最新的会话
+	if ret, _ := a.Solitaire(ss, "不是成语", "u2"); ret != SolitaireFailed || ss.hits["u1"] != 1 {
+		t.Errorf("stale copy: %d %+v", ret, ss.hits)
+	}
+
+	ss.lastAccess = time.Now().Unix() - 60
+	a.store.CompareAndSwap(ss, ss.version)
+	if expired := b.ClearExpiredSession(); len(expired) != 1 || a.Session("g1") != nil {
+		t.Errorf("expired: %d", len(expired))
+	}
+}
+
+// faultyStore 在 CompareAndSwap 与 Delete 时返回 err，或者总是版本冲突
+type faultyStore struct {
+	SessionStore
+	err      error
+	conflict bool
+}
+
+func (f *faultyStore) CompareAndSwap(ss *Session, old uint64) (bool, error) {
+	if f.err != nil || f.conflict {
+		return false, f.err
+	}
+	return f.SessionStore.CompareAndSwap(ss, old)
+}
+
+func (f *faultyStore) Delete(key string, version uint64) (bool, error) {
+	if f.err != nil || f.conflict {
+		return false, f.err
+	}
+	return f.SessionStore.Delete(key, version)
+}
+
+func TestSolitaireUnsaved(t *testing.T) {
+	is := NewIdiomsSolitaire(testIdioms(), 60)
+	ss, _ := is.SessionOrCreate("g1")
+	store := &faultyStore{SessionStore: is.store}
+	is.SetStore(store)
+
+	// 没有保存时返回各自的结果，而不是 fn 的结果
+	store.conflict = true
+	if ret, next := is.Solitaire(ss, answerFor(ss.Idiom()), "u1"); ret != SolitaireConflict || len(next) > 0 {
+		t.Errorf("conflict: %d %s", ret, next)
+	}
+	store.conflict, store.err = false, errors.New("disk full")
+	if ret, _ := is.Skip(ss, "u1"); ret != SolitaireStoreError {
+		t.Errorf("store error: %d", ret)
+	}
+	if saved := is.Session("g1"); saved.hits["u1"] != 0 || saved.trun != 0 {
+		t.Errorf("unsaved session changed: %+v", saved)
+	}
+}
+
+func TestFileStoreLock(t *testing.T) {
+	dir := t.TempDir()
+	a, b := NewIdiomsSolitaire(testIdioms(), 60), NewIdiomsSolitaire(testIdioms(), 60)
+	stores := make([]*fileStore, 2)
+	for i, is := range []*IdiomsSolitaire{a, b} {
+		store, err := NewFileStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		is.SetStore(store)
+		stores[i] = store.(*fileStore)
+	}
+	a.SessionOrCreate("g1")
+	a.SessionOrCreate("g2")
+
+	// 两个实例并发更新同一个会话，每次更新都要保存下来
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		is := []*IdiomsSolitaire{a, b}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			is.Answers(is.Session("g1"), "u1")
+		}()
+	}
+	wg.Wait()
+	if got := a.Session("g1").assists["u1"].Answers; got != 16 {
+		t.Errorf("answers: %d", got)
+	}
+
+	// 锁只针对一个会话，g1 被锁住时 g2 仍然可以更新
+	unlock, err := stores[0].lock("g1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan int)
+	go func() {
+		ret, _ := b.Answers(b.Session("g2"), "u1")
+		done <- ret
+	}()
+	select {
+	case ret := <-done:
+		if ret != SolitaireSucceed {
+			t.Errorf("g2: %d", ret)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("g2 blocked by the lock of g1")
+	}
+	unlock()
+
+	// 删除会话时锁文件一起删除
+	ss := a.Session("g1")
+	if ok, err := stores[1].Delete("g1", ss.version); !ok || err != nil {
+		t.Fatalf("delete: %v %v", ok, err)
+	}
+	if _, err := os.Stat(stores[0].lockPath("g1")); !os.IsNotExist(err) {
+		t.Errorf("lock file should be removed: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"sgroupbot"
+	"strings"
+	"time"
+
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// settlementSize 结算中列出的名次，并列时可能多于这个人数
+const settlementSize = 3
+
+// SettlementFormat 结算消息的格式
+type SettlementFormat string
+
+const (
+	SettlementText     SettlementFormat = "text"     // 纯文本
+	SettlementMarkdown SettlementFormat = "markdown" // 原生 markdown，需要开通 markdown 权限
+	SettlementArk      SettlementFormat = "ark"      // 23 号 ark 模板，需要开通模板权限
+	SettlementAuto     SettlementFormat = "auto"     // 群与单聊使用 markdown，频道使用 ark
+)
+
+// parseSettlementFormat 解析配置中的结算格式，为空时使用纯文本
+func parseSettlementFormat(s string) (SettlementFormat, error) {
+	switch f := SettlementFormat(s); f {
+	case "":
+		return SettlementText, nil
+	case SettlementText, SettlementMarkdown, SettlementArk, SettlementAuto:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown settlement %q, want text, markdown, ark or auto", s)
+}
+
+// formatFor 发送对象使用的结算格式
+func (f SettlementFormat) formatFor(kind sgroupbot.TargetKind) SettlementFormat {
+	if f != SettlementAuto {
+		return f
+	}
+	switch kind {
+	case sgroupbot.TargetChannel, sgroupbot.TargetDirect:
+		return SettlementArk
+	default:
+		return SettlementMarkdown
+	}
+}
+
+// Standing 结算中的一个名次
+type Standing struct {
+	Rank int    // 并列时名次相同，之后的名次跳过并列的人数，如 1、1、3
+	Name string // 展示用的名字
+	HitCnt
+}
+
+// rankHits 按 HitCntList 的顺序排出前 n 名，答对与答错次数都相同时并列，并列时先答对的排在前面，
+// 第 n 名并列的用户都会列出
+func rankHits(hits []HitCnt, n int, name func(id string) string) []Standing {
+	var standings []Standing
+	for i, hit := range hits {
+		if hit.Count == 0 {
+			break
+		}
+		rank := i + 1
+		if i > 0 && hit.tied(hits[i-1]) {
+			rank = standings[i-1].Rank
+		}
+		if rank > n {
+			break
+		}
+		standings = append(standings, Standing{Rank: rank, Name: name(hit.Name), HitCnt: hit})
+	}
+	return standings
+}
+
+// renderSettlement 在 msg 的内容之后附加接龙排行，没有人答对时只保留原来的内容
+func renderSettlement(msg *sgroupbot.CreateMessageRequest, format SettlementFormat, standings []Standing) {
+	if len(standings) == 0 {
+		return
+	}
+	content := msg.Content
+	switch format {
+	case SettlementMarkdown:
+		var sb strings.Builder
+		sb.WriteString(content)
+		sb.WriteString("\n\n**接龙排行**\n")
+		for _, st := range standings {
+			fmt.Fprintf(&sb, "\n%d. **%s** 答对%d次 得分%d", st.Rank, escapeMarkdown(st.Name), st.Count, st.Score)
+		}
+		msg.MsgType = sgroupbot.MsgTypeMarkdown
+		msg.Markdown = &sgroupbot.Markdown{Content: sb.String()}
+		msg.Content = ""
+	case SettlementArk:
+		items := make([]string, 0, len(standings))
+		for _, st := range standings {
+			items = append(items, fmt.Sprintf("%d. %s 答对%d次 得分%d", st.Rank, sgroupbot.EscapeContent(st.Name), st.Count, st.Score))
+		}
+		msg.MsgType = sgroupbot.MsgTypeArk
+		msg.Ark = sgroupbot.NewListArk(content, "接龙排行", items...)
+		msg.Content = ""
+	default:
+		var sb strings.Builder
+		sb.WriteString(content)
+		sb.WriteString("\n接龙排行")
+		for _, st := range standings {
+			fmt.Fprintf(&sb, "\n%d. %s 答对%d次 得分%d", st.Rank, sgroupbot.EscapeContent(st.Name), st.Count, st.Score)
+		}
+		msg.Content = sb.String()
+	}
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "~", `\~`,
+	"[", `\[`, "]", `\]`, "#", `\#`, ">", `\>`,
+)
+
+// escapeMarkdown 转义昵称中的 markdown 符号
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// settle 在 msg 之后附加会话的结算，按发送对象选择格式
+func (s *ApiServer) settle(msg *sgroupbot.CreateMessageRequest, ss *Session) {
+	kind, _ := ss.Conversation()
+	standings := rankHits(ss.Hits(), settlementSize, func(id string) string {
+		return s.displayName(kind, ss.conv.guild, id)
+	})
+	renderSettlement(msg, s.settlement.formatFor(kind), standings)
+}
+
+// nameTTL 缓存的名字多长时间没有更新后清理
+const nameTTL = 24 * time.Hour
+
+type cachedName struct {
+	name string
+	seen int64
+}
+
+// nameCache 从消息中记录的频道昵称与用户名，群与单聊的消息中没有名字
+type nameCache struct {
+	names *xsync.MapOf[string, cachedName]
+}
+
+func newNameCache() *nameCache {
+	return &nameCache{names: xsync.NewMapOf[string, cachedName]()}
+}
+
+func (c *nameCache) put(id, name string, now time.Time) {
+	if len(id) == 0 || len(name) == 0 {
+		return
+	}
+	c.names.Store(id, cachedName{name: name, seen: now.Unix()})
+}
+
+func (c *nameCache) get(id string) (string, bool) {
+	n, ok := c.names.Load(id)
+	return n.name, ok
+}
+
+// cleanup 清理长时间没有发消息的用户
+func (c *nameCache) cleanup(now time.Time) {
+	before := now.Add(-nameTTL).Unix()
+	c.names.Range(func(id string, n cachedName) bool {
+		if n.seen < before {
+			c.names.Delete(id)
+		}
+		return true
+	})
+}
+
+// displayName 用户展示的名字：频道中优先使用状态缓存中的成员昵称，其次是消息中缓存的昵称或用户名，
+// 都没有时使用匿名化的 openid
+func (s *ApiServer) displayName(kind sgroupbot.TargetKind, guild, id string) string {
+	if kind == sgroupbot.TargetChannel && len(guild) > 0 && s.api.State != nil {
+		if m, err := s.api.State.Member(guild, id); err == nil {
+			if len(m.Nick) > 0 {
+				return m.Nick
+			}
+			if len(m.User.Username) > 0 {
+				return m.User.Username
+			}
+		}
+	}
+	if name, ok := s.names.get(id); ok {
+		return name
+	}
+	return anonymousName(id)
+}
+
+// anonymousName 只保留 openid 的后四位，如“玩家3C2C”
+func anonymousName(id string) string {
+	if len(id) > 4 {
+		id = id[len(id)-4:]
+	}
+	return "玩家" + strings.ToUpper(id)
+}
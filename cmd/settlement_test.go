@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sgroupbot"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRankHits(t *testing.T) {
+	is := NewIdiomsSolitaire(testIdioms(), 60)
+	is.SetRules(10, 3)
+	ss, _ := is.SessionOrCreate("g1")
+	ss.used = nil
+	ss.current, ss.lastRune = "一马当先", '先'
+	ss.use(ss.current)
+
+	// 一马当先 之后每个字只有一个接法，机器人依次接 人山人海、空前绝后、上下一心、成千上万
+	for _, answer := range []struct{ id, idiom string }{
+		{"b", "先发制人"}, {"c", "海阔天空"}, {"d", "不是成语"}, {"d", "后来居上"}, {"e", "心想事成"}, {"e", "万众一心"},
+	} {
+		if ret, _ := is.Solitaire(ss, answer.idiom, answer.id); ret != SolitaireSucceed && ret != SolitaireFailed && ret != SolitaireEnd {
+			t.Fatalf("%s %s: %d", answer.id, answer.idiom, ret)
+		}
+	}
+
+	// b 与 c 都答对一次并列，先答对的 b 排在前面；d 答错过一次
+	standings := rankHits(ss.Hits(), 5, strings.ToUpper)
+	var got []string
+	for _, st := range standings {
+		got = append(got, st.Name+":"+strconv.Itoa(st.Rank))
+	}
+	if want := "E:1 B:2 C:2 D:4"; strings.Join(got, " ") != want {
+		t.Errorf("standings: %v, want %s", got, want)
+	}
+
+	// 只列出前 n 名，并列在第 n 名的都列出
+	if standings := rankHits(ss.Hits(), 2, strings.ToUpper); len(standings) != 3 {
+		t.Errorf("top 2: %+v", standings)
+	}
+	// 没有答对的不列出
+	if standings := rankHits([]HitCnt{{Name: "a"}}, 3, strings.ToUpper); len(standings) != 0 {
+		t.Errorf("no hits: %+v", standings)
+	}
+}
+
+func TestRenderSettlement(t *testing.T) {
+	standings := []Standing{
+		{Rank: 1, Name: "小_张", HitCnt: HitCnt{Count: 2, Score: 20}},
+		{Rank: 1, Name: "小李", HitCnt: HitCnt{Count: 2, Score: 17}},
+	}
+	newMsg := func() *sgroupbot.CreateMessageRequest {
+		return &sgroupbot.CreateMessageRequest{Content: "接龙结束", MsgType: sgroupbot.MsgTypeText}
+	}
+
+	msg := newMsg()
+	renderSettlement(msg, SettlementText, standings)
+	if want := "接龙结束\n接龙排行\n1. 小_张 答对2次 得分20\n1. 小李 答对2次 得分17"; msg.Content != want {
+		t.Errorf("text: %q", msg.Content)
+	}
+
+	msg = newMsg()
+	renderSettlement(msg, SettlementMarkdown, standings)
+	if msg.MsgType != sgroupbot.MsgTypeMarkdown || len(msg.Content) > 0 ||
+		msg.Markdown.Content != "接龙结束\n\n**接龙排行**\n\n1. **小\\_张** 答对2次 得分20\n1. **小李** 答对2次 得分17" {
+		t.Errorf("markdown: %+v %q", msg, msg.Markdown.Content)
+	}
+
+	msg = newMsg()
+	renderSettlement(msg, SettlementArk, standings)
+	data, _ := json.Marshal(msg.Ark)
+	want := `{"template_id":23,"kv":[{"key":"#DESC#","value":"接龙结束"},{"key":"#PROMPT#","value":"接龙排行"},` +
+		`{"key":"#LIST#","obj":[{"obj_kv":[{"key":"desc","value":"1. 小_张 答对2次 得分20"}]},{"obj_kv":[{"key":"desc","value":"1. 小李 答对2次 得分17"}]}]}]}`
+	if msg.MsgType != sgroupbot.MsgTypeArk || string(data) != want {
+		t.Errorf("ark: %s", data)
+	}
+
+	// 昵称中的内嵌格式转义之后发送，不能借结算@全体成员
+	injected := []Standing{{Rank: 1, Name: "<@everyone>", HitCnt: HitCnt{Count: 1, Score: 10}}}
+	for _, format := range []SettlementFormat{SettlementText, SettlementArk} {
+		msg = newMsg()
+		renderSettlement(msg, format, injected)
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.Encode(msg)
+		if strings.Contains(buf.String(), "<@everyone>") || !strings.Contains(buf.String(), "1. &lt;@everyone&gt; 答对1次 得分10") {
+			t.Errorf("%s escape: %s", format, buf.String())
+		}
+	}
+
+	// 没有人答对时不附加结算
+	msg = newMsg()
+	renderSettlement(msg, SettlementMarkdown, nil)
+	if msg.Content != "接龙结束" || msg.MsgType != sgroupbot.MsgTypeText {
+		t.Errorf("empty: %+v", msg)
+	}
+
+	if f := SettlementAuto.formatFor(sgroupbot.TargetChannel); f != SettlementArk {
+		t.Errorf("auto channel: %s", f)
+	}
+	if f := SettlementAuto.formatFor(sgroupbot.TargetGroup); f != SettlementMarkdown {
+		t.Errorf("auto group: %s", f)
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	_, s := startTestServer(t)
+	now := time.Now()
+	s.names.put("1234567", "小张", now.Add(-2*nameTTL))
+	s.names.put("7654321", "小李", now)
+
+	if name := s.displayName(sgroupbot.TargetChannel, "g1", "7654321"); name != "小李" {
+		t.Errorf("cached: %s", name)
+	}
+	if name := s.displayName(sgroupbot.TargetGroup, "", "0DE2782CA6DD4FB3B29730FC6F7C26ac"); name != "玩家26AC" {
+		t.Errorf("anonymous: %s", name)
+	}
+	s.names.cleanup(now)
+	if name := s.displayName(sgroupbot.TargetChannel, "g1", "1234567"); name != "玩家4567" {
+		t.Errorf("expired: %s", name)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sgroupbot"
+	"sort"
+	"time"
+)
+
+// snapshotVersion 快照格式的版本，格式不兼容地变化时加一，并在 Restore 中转换旧的格式
+const snapshotVersion = 1
+
+// snapshot 正在进行的会话的快照，退出时保存，启动时恢复
+type snapshot struct {
+	Version  int             `json:"version"`
+	Time     int64           `json:"time"` // 保存快照的时间
+	Sessions json.RawMessage `json:"sessions"`
+}
+
+// Snapshot 将正在进行的会话写入 w，返回会话的数量
+func (is *IdiomsSolitaire) Snapshot(w io.Writer) (int, error) {
+	var records []*sessionRecord
+	err := is.store.Range(0, func(ss *Session) bool {
+		records = append(records, newSessionRecord(ss))
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+
+	sessions, err := json.Marshal(records)
+	if err != nil {
+		return 0, err
+	}
+	snap := snapshot{Version: snapshotVersion, Time: time.Now().Unix(), Sessions: sessions}
+	if err := json.NewEncoder(w).Encode(snap); err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+// Restore 从 r 中恢复会话，跳过已经过期、成语已经不在成语库中或者已经存在的会话，返回恢复的数量
+func (is *IdiomsSolitaire) Restore(r io.Reader) (int, error) {
+	var snap snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return 0, err
+	}
+
+	var records []*sessionRecord
+	switch snap.Version {
+	case 1:
+		if err := json.Unmarshal(snap.Sessions, &records); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	now := time.Now().Unix()
+	var restored int
+	for _, rec := range records {
+		if now-rec.LastAccess >= is.expiredTime {
+			continue
+		}
+		if _, ok := is.idioms[rec.Current]; !ok {
+			sgroupbot.DefaultLogger.Warn("restore_skip", "key", rec.Key, "idiom", rec.Current)
+			continue
+		}
+		ss := rec.session()
+		ss.version = 0
+		ok, err := is.store.CompareAndSwap(ss, 0)
+		if err != nil {
+			return restored, err
+		}
+		if ok {
+			restored++
+		}
+	}
+	return restored, nil
+}
+
+// SaveSnapshot 将会话的快照保存到 path，先写临时文件再改名
+func (is *IdiomsSolitaire) SaveSnapshot(path string) (int, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := is.Snapshot(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return n, os.Rename(tmp, path)
+}
+
+// LoadSnapshot 从 path 恢复会话，文件不存在时不恢复；恢复之后删除快照，避免下次启动重复恢复
+func (is *IdiomsSolitaire) LoadSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := is.Restore(f)
+	f.Close()
+	if err != nil {
+		return n, err
+	}
+	return n, os.Remove(path)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	is := NewIdiomsSolitaire(testIdioms(), 60)
+	ss, _ := is.SessionOrCreateWith("g1", SessionOptions{Mode: ChainToneless, Strategy: NewRandomStrategy(1), To: "G1"})
+	if ret, _ := is.Solitaire(ss, answerFor(ss.Idiom()), "u1"); ret != SolitaireSucceed {
+		t.Fatalf("solitaire: %d", ret)
+	}
+	is.Solitaire(ss, "不是成语", "u2")
+	is.Hint(ss, "u2")
+	// 过期的会话不恢复
+	old, _ := is.SessionOrCreate("g2")
+	old.lastAccess = time.Now().Unix() - 60
+	is.store.CompareAndSwap(old, old.version)
+
+	var buf bytes.Buffer
+	if n, err := is.Snapshot(&buf); n != 2 || err != nil {
+		t.Fatalf("snapshot: %d %v", n, err)
+	}
+
+	restored := NewIdiomsSolitaire(testIdioms(), 60)
+	if n, err := restored.Restore(bytes.NewReader(buf.Bytes())); n != 1 || err != nil {
+		t.Fatalf("restore: %d %v", n, err)
+	}
+	got := restored.Session("g1")
+	if got == nil || got.Idiom() != ss.Idiom() || got.lastRune != ss.lastRune || got.miss != 1 || got.trun != 1 ||
+		got.hits["u1"] != 1 || len(got.used) != len(ss.used) || got.lastAccess != ss.lastAccess ||
+		got.Mode() != ChainToneless || got.strategy.Name() != StrategyRandom || got.assists["u2"].Hints != 1 {
+		t.Errorf("restored: %+v, want %+v", got, ss)
+	}
+	if _, to := got.Conversation(); to != "G1" {
+		t.Errorf("conversation: %s", to)
+	}
+	if restored.Session("g2") != nil {
+		t.Error("expired session restored")
+	}
+
+	// 恢复的会话可以继续接龙
+	if ret, _ := restored.Solitaire(got, answerFor(got.Idiom()), "u1"); ret != SolitaireSucceed {
+		t.Errorf("continue: %d", ret)
+	}
+}
+
+func TestSnapshotVersion(t *testing.T) {
+	is := NewIdiomsSolitaire(testIdioms(), 60)
+	now := time.Now().Unix()
+
+	// 旧快照中没有的字段使用零值，多出的字段忽略
+	data := `{"version":1,"sessions":[{"key":"g1","current":"一马当先","last_rune":20808,"used":["一马当先"],"last_access":` +
+		strconv.FormatInt(now, 10) + `,"extra":true}]}`
+	if n, err := is.Restore(strings.NewReader(data)); n != 1 || err != nil {
+		t.Fatalf("restore: %d %v", n, err)
+	}
+	if _, err := is.Restore(strings.NewReader(`{"version":99,"sessions":[]}`)); err == nil {
+		t.Error("unsupported version")
+	}
+
+	// 快照文件恢复之后删除，不存在时不恢复
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if n, err := is.SaveSnapshot(path); n != 1 || err != nil {
+		t.Fatalf("save: %d %v", n, err)
+	}
+	restored := NewIdiomsSolitaire(testIdioms(), 60)
+	if n, err := restored.LoadSnapshot(path); n != 1 || err != nil {
+		t.Fatalf("load: %d %v", n, err)
+	}
+	if n, err := restored.LoadSnapshot(path); n != 0 || err != nil {
+		t.Errorf("load removed: %d %v", n, err)
+	}
+}
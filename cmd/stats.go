@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sgroupbot"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PlayerResult 用户在一局中的结果
+type PlayerResult struct {
+	ID      string `json:"id"`
+	Correct int    `json:"correct"`
+	Misses  int    `json:"misses"`
+	Streak  int    `json:"streak"` // 最长连续答对的次数
+	Hints   int    `json:"hints"`
+	Won     bool   `json:"won"` // 答对次数最多，并列时都算赢
+}
+
+// GameResult 一局成语接龙的结果
+type GameResult struct {
+	Time         int64                `json:"time"` // 结束的时间
+	Kind         sgroupbot.TargetKind `json:"kind"`
+	Conversation string               `json:"conversation"` // 群、子频道或用户
+	Guild        string               `json:"guild,omitempty"`
+	Players      []PlayerResult       `json:"players"`
+}
+
+// newGameResult 根据结束的会话生成对局结果，没有人参与时返回 false
+func newGameResult(ss *Session, now time.Time) (GameResult, bool) {
+	r := GameResult{
+		Time:         now.Unix(),
+		Kind:         ss.conv.kind,
+		Conversation: ss.conv.to,
+		Guild:        ss.conv.guild,
+	}
+	ids := make(map[string]struct{})
+	for id := range ss.hits {
+		ids[id] = struct{}{}
+	}
+	for id := range ss.perf {
+		ids[id] = struct{}{}
+	}
+	for id := range ss.assists {
+		ids[id] = struct{}{}
+	}
+
+	var best int
+	for id := range ids {
+		p := PlayerResult{ID: id, Correct: ss.hits[id]}
+		if perf, ok := ss.perf[id]; ok {
+			p.Misses, p.Streak = perf.Misses, perf.Longest
+		}
+		if a, ok := ss.assists[id]; ok {
+			p.Hints = a.Hints
+		}
+		if p.Correct > best {
+			best = p.Correct
+		}
+		r.Players = append(r.Players, p)
+	}
+	for i := range r.Players {
+		r.Players[i].Won = best > 0 && r.Players[i].Correct == best
+	}
+	sort.Slice(r.Players, func(i, j int) bool { return r.Players[i].ID < r.Players[j].ID })
+	return r, len(r.Players) > 0
+}
+
+// StatsRepository 对局结果的存储
+type StatsRepository interface {
+	// Add 保存一局的结果
+	Add(r GameResult) error
+	// Range 按结束时间遍历 since 之后的对局，f 返回 false 时停止
+	Range(since int64, f func(r *GameResult) bool) error
+	// Trim 删除结束时间早于 before 的对局
+	Trim(before int64) error
+	Close() error
+}
+
+// memoryStatsRepository 内存中的对局结果，file 不为空时追加写入 jsonl 文件
+type memoryStatsRepository struct {
+	mu      sync.RWMutex
+	results []GameResult
+	path    string
+	file    *os.File
+}
+
+// NewMemoryStatsRepository 保存在内存中，重启后丢失
+func NewMemoryStatsRepository() StatsRepository {
+	return &memoryStatsRepository{}
+}
+
+// NewFileStatsRepository 保存在 jsonl 文件中，打开时加载已有的结果
+func NewFileStatsRepository(path string) (StatsRepository, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	repo := &memoryStatsRepository{path: path, file: f}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var r GameResult
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		repo.results = append(repo.results, r)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	sort.SliceStable(repo.results, func(i, j int) bool { return repo.results[i].Time < repo.results[j].Time })
+	return repo, nil
+}
+
+func (m *memoryStatsRepository) Add(r GameResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.file != nil {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := m.file.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	// 按时间插入，通常是追加到最后
+	i := sort.Search(len(m.results), func(i int) bool { return m.results[i].Time > r.Time })
+	m.results = append(m.results, GameResult{})
+	copy(m.results[i+1:], m.results[i:])
+	m.results[i] = r
+	return nil
+}
+
+func (m *memoryStatsRepository) Range(since int64, f func(r *GameResult) bool) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i := sort.Search(len(m.results), func(i int) bool { return m.results[i].Time >= since })
+	for ; i < len(m.results); i++ {
+		r := m.results[i]
+		if !f(&r) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memoryStatsRepository) Trim(before int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := sort.Search(len(m.results), func(i int) bool { return m.results[i].Time >= before })
+	if i == 0 {
+		return nil
+	}
+	if m.file != nil {
+		if err := m.rewrite(m.results[i:]); err != nil {
+			return err
+		}
+	}
+	m.results = append([]GameResult(nil), m.results[i:]...)
+	return nil
+}
+
+// rewrite 只保留 results 重写文件：先写临时文件再改名，之后追加到新的文件
+func (m *memoryStatsRepository) rewrite(results []GameResult) error {
+	tmp := m.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range results {
+		if err := enc.Encode(&results[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(m.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	m.file.Close()
+	m.file = file
+	return nil
+}
+
+func (m *memoryStatsRepository) Close() error {
+	if m.file != nil {
+		return m.file.Close()
+	}
+	return nil
+}
+
+// ScopeKind 统计的范围
+type ScopeKind int
+
+const (
+	ScopeConversation ScopeKind = iota // 当前的群、子频道或单聊
+	ScopeGuild                         // 当前的频道
+	ScopeGlobal                        // 全部
+)
+
+// Scope 统计的范围，ID 为群、子频道、用户或频道的ID，
+// ScopeConversation 时 Target 为聊天的类型，不同类型的ID可能相同
+type Scope struct {
+	Kind   ScopeKind
+	Target sgroupbot.TargetKind
+	ID     string
+}
+
+func (s Scope) match(r *GameResult) bool {
+	switch s.Kind {
+	case ScopeConversation:
+		return r.Kind == s.Target && r.Conversation == s.ID
+	case ScopeGuild:
+		return len(s.ID) > 0 && r.Guild == s.ID
+	default:
+		return true
+	}
+}
+
+// Window 统计的时间窗口
+type Window int
+
+const (
+	WindowAll  Window = iota // 全部
+	WindowDay                // 今天
+	WindowWeek               // 本周，从周一开始
+)
+
+// since 窗口开始的时间
+func (w Window) since(now time.Time) int64 {
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	switch w {
+	case WindowDay:
+		return today.Unix()
+	case WindowWeek:
+		offset := (int(today.Weekday()) + 6) % 7 // 周一为 0
+		return today.AddDate(0, 0, -offset).Unix()
+	default:
+		return 0
+	}
+}
+
+func (w Window) Name() string {
+	switch w {
+	case WindowDay:
+		return "今日"
+	case WindowWeek:
+		return "本周"
+	default:
+		return "全部"
+	}
+}
+
+// PlayerStats 用户在一段时间内的统计
+type PlayerStats struct {
+	ID            string
+	Games         int
+	Wins          int
+	Correct       int
+	Misses        int
+	LongestStreak int
+	Hints         int
+}
+
+// WinRate 胜率，没有对局时为 0
+func (p PlayerStats) WinRate() float64 {
+	if p.Games == 0 {
+		return 0
+	}
+	return float64(p.Wins) / float64(p.Games)
+}
+
+func (p *PlayerStats) add(r PlayerResult) {
+	p.Games++
+	if r.Won {
+		p.Wins++
+	}
+	p.Correct += r.Correct
+	p.Misses += r.Misses
+	p.Hints += r.Hints
+	if r.Streak > p.LongestStreak {
+		p.LongestStreak = r.Streak
+	}
+}
+
+// trimInterval 设置了保留时间时，多长时间删除一次过期的对局
+const trimInterval = time.Hour
+
+// aggregateCacheSize 最多缓存多少个范围与窗口的汇总，超过时全部清空
+const aggregateCacheSize = 256
+
+type aggregateKey struct {
+	scope  Scope
+	window Window
+}
+
+// aggregate 范围与窗口内按用户汇总的战绩，since 为汇总开始的时间，窗口移动后重新汇总
+type aggregate struct {
+	since   int64
+	players map[string]*PlayerStats
+}
+
+func (a *aggregate) add(r *GameResult) {
+	for _, pr := range r.Players {
+		p, ok := a.players[pr.ID]
+		if !ok {
+			p = &PlayerStats{ID: pr.ID}
+			a.players[pr.ID] = p
+		}
+		p.add(pr)
+	}
+}
+
+// Stats 用户的长期战绩。查询过的范围与窗口会缓存汇总，之后的对局增量计入，
+// 不需要每次查询都遍历全部的对局
+type Stats struct {
+	repo StatsRepository
+	now  func() time.Time
+
+	mu        sync.Mutex
+	cache     map[aggregateKey]*aggregate
+	retention time.Duration
+	cutoff    int64 // 已经删除了这个时间之前的对局
+	trimmed   time.Time
+}
+
+func NewStats(repo StatsRepository) *Stats {
+	return &Stats{repo: repo, now: time.Now, cache: make(map[aggregateKey]*aggregate)}
+}
+
+// SetRetention 只保留最近 d 时间内的对局，立即删除过期的对局，之后每 trimInterval 删除一次；0 为全部保留
+func (s *Stats) SetRetention(d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retention = d
+	return s.trim()
+}
+
+// trim 删除保留时间之前的对局，窗口开始的时间改变，清空缓存的汇总
+func (s *Stats) trim() error {
+	if s.retention <= 0 {
+		return nil
+	}
+	now := s.now()
+	before := now.Add(-s.retention).Unix()
+	if err := s.repo.Trim(before); err != nil {
+		return err
+	}
+	s.cutoff, s.trimmed = before, now
+	s.cache = make(map[aggregateKey]*aggregate)
+	return nil
+}
+
+// Record 记录结束的会话，没有人参与时不记录
+func (s *Stats) Record(ss *Session) error {
+	r, ok := newGameResult(ss, s.now())
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.repo.Add(r); err != nil {
+		return err
+	}
+	for key, a := range s.cache {
+		if key.scope.match(&r) && r.Time >= a.since {
+			a.add(&r)
+		}
+	}
+	if s.retention > 0 && s.now().Sub(s.trimmed) >= trimInterval {
+		return s.trim()
+	}
+	return nil
+}
+
+// aggregate 按用户汇总范围与窗口内的对局，调用时持有 s.mu
+func (s *Stats) aggregate(scope Scope, window Window) (map[string]*PlayerStats, error) {
+	since := window.since(s.now())
+	if since < s.cutoff {
+		since = s.cutoff
+	}
+	key := aggregateKey{scope: scope, window: window}
+	if a, ok := s.cache[key]; ok && a.since == since {
+		return a.players, nil
+	}
+
+	a := &aggregate{since: since, players: make(map[string]*PlayerStats)}
+	err := s.repo.Range(since, func(r *GameResult) bool {
+		if scope.match(r) {
+			a.add(r)
+		}
+		return true
+	})
+	if err != nil {
+		return a.players, err
+	}
+	if len(s.cache) >= aggregateCacheSize {
+		s.cache = make(map[aggregateKey]*aggregate)
+	}
+	s.cache[key] = a
+	return a.players, nil
+}
+
+// Player 用户在范围与窗口内的战绩
+func (s *Stats) Player(scope Scope, window Window, id string) (PlayerStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	players, err := s.aggregate(scope, window)
+	if p, ok := players[id]; ok {
+		return *p, err
+	}
+	return PlayerStats{ID: id}, err
+}
+
+// Leaderboard 范围与窗口内的前 n 名：答对次数多的在前，其次胜率高、答错少的在前
+func (s *Stats) Leaderboard(scope Scope, window Window, n int) ([]PlayerStats, error) {
+	s.mu.Lock()
+	players, err := s.aggregate(scope, window)
+	list := make([]PlayerStats, 0, len(players))
+	for _, p := range players {
+		if p.Correct > 0 {
+			list = append(list, *p)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Correct != b.Correct {
+			return a.Correct > b.Correct
+		}
+		if a.WinRate() != b.WinRate() {
+			return a.WinRate() > b.WinRate()
+		}
+		if a.Misses != b.Misses {
+			return a.Misses < b.Misses
+		}
+		return a.ID < b.ID
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list, err
+}
+
+// leaderboardSize 排行榜列出的人数
+const leaderboardSize = 10
+
+// statsQuery 战绩与排行榜指令的参数
+type statsQuery struct {
+	window Window
+	scope  ScopeKind
+}
+
+// parseStatsQuery 解析指令之后的参数，如“排行榜 本周 全局”，不认识的参数返回 false
+func parseStatsQuery(args []string) (statsQuery, bool) {
+	var q statsQuery
+	for _, arg := range args {
+		switch arg {
+		case "今日", "今天":
+			q.window = WindowDay
+		case "本周":
+			q.window = WindowWeek
+		case "全部":
+			q.window = WindowAll
+		case "本群", "本频道":
+			q.scope = ScopeConversation
+		case "频道":
+			q.scope = ScopeGuild
+		case "全局":
+			q.scope = ScopeGlobal
+		default:
+			return q, false
+		}
+	}
+	return q, true
+}
+
+// scopeName 范围的名称
+func scopeName(kind ScopeKind, target sgroupbot.TargetKind) string {
+	switch kind {
+	case ScopeGuild:
+		return "频道"
+	case ScopeGlobal:
+		return "全局"
+	}
+	switch target {
+	case sgroupbot.TargetGroup:
+		return "本群"
+	case sgroupbot.TargetChannel:
+		return "子频道"
+	default:
+		return "私聊"
+	}
+}
+
+// formatPlayerStats 一行战绩
+func formatPlayerStats(p PlayerStats) string {
+	return fmt.Sprintf("%d局 胜%d局 胜率%.0f%% 答对%d次 答错%d次 最长连对%d次 提示%d次",
+		p.Games, p.Wins, p.WinRate()*100, p.Correct, p.Misses, p.LongestStreak, p.Hints)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"path/filepath"
+	"sgroupbot"
+	"strings"
+	"testing"
+	"time"
+)
+
+// finishedSession 构造一局结束的会话，hits 与 misses 按用户记录
+func finishedSession(to, guild string, hits, misses map[string]int) *Session {
+	ss := &Session{key: to, conv: conversation{kind: sgroupbot.TargetGroup, to: to, guild: guild}, hits: hits}
+	for id, n := range hits {
+		for i := 0; i < n; i++ {
+			ss.perfOf(id).hit(int64(i + 1))
+		}
+	}
+	for id, n := range misses {
+		for i := 0; i < n; i++ {
+			ss.perfOf(id).missed()
+		}
+	}
+	return ss
+}
+
+func TestStats(t *testing.T) {
+	// 2026-10-21 是周三
+	now := time.Date(2026, 10, 21, 12, 0, 0, 0, time.Local)
+	stats := NewStats(NewMemoryStatsRepository())
+	record := func(at time.Time, ss *Session) {
+		stats.now = func() time.Time { return at }
+		if err := stats.Record(ss); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record(now.AddDate(0, 0, -8), finishedSession("G1", "", map[string]int{"u1": 5}, nil))
+	record(now.AddDate(0, 0, -1), finishedSession("G1", "", map[string]int{"u1": 1, "u2": 3}, map[string]int{"u1": 2}))
+	record(now, finishedSession("G1", "", map[string]int{"u1": 2, "u2": 2}, nil))
+	record(now, finishedSession("C1", "X1", map[string]int{"u3": 4}, nil))
+	// 没有人参与的对局不记录
+	record(now, finishedSession("G1", "", nil, nil))
+	stats.now = func() time.Time { return now }
+
+	p, _ := stats.Player(Scope{Kind: ScopeConversation, Target: sgroupbot.TargetGroup, ID: "G1"}, WindowAll, "u1")
+	want := PlayerStats{ID: "u1", Games: 3, Wins: 2, Correct: 8, Misses: 2, LongestStreak: 5}
+	if p != want {
+		t.Errorf("player: %+v, want %+v", p, want)
+	}
+	if p, _ := stats.Player(Scope{Kind: ScopeConversation, Target: sgroupbot.TargetGroup, ID: "G1"}, WindowWeek, "u1"); p.Games != 2 || p.Wins != 1 {
+		t.Errorf("week: %+v", p)
+	}
+	if p, _ := stats.Player(Scope{Kind: ScopeConversation, Target: sgroupbot.TargetGroup, ID: "G1"}, WindowDay, "u2"); p.Games != 1 || p.WinRate() != 1 {
+		t.Errorf("day: %+v", p)
+	}
+
+	board, _ := stats.Leaderboard(Scope{Kind: ScopeGlobal}, WindowWeek, 2)
+	if len(board) != 2 || board[0].ID != "u2" || board[1].ID != "u3" {
+		t.Errorf("global leaderboard: %+v", board)
+	}
+	board, _ = stats.Leaderboard(Scope{Kind: ScopeGuild, ID: "X1"}, WindowAll, 10)
+	if len(board) != 1 || board[0].ID != "u3" {
+		t.Errorf("guild leaderboard: %+v", board)
+	}
+	if board, _ := stats.Leaderboard(Scope{Kind: ScopeGuild}, WindowAll, 10); len(board) != 0 {
+		t.Errorf("group has no guild: %+v", board)
+	}
+}
+
+func TestStatsScopeKind(t *testing.T) {
+	stats := NewStats(NewMemoryStatsRepository())
+	// 群与单聊用户的 openid 可能相同，只统计同一类聊天的对局
+	group := finishedSession("X1", "", map[string]int{"u1": 1}, nil)
+	c2c := finishedSession("X1", "", map[string]int{"u1": 2}, nil)
+	c2c.conv.kind = sgroupbot.TargetUser
+	stats.Record(group)
+	stats.Record(c2c)
+
+	if p, _ := stats.Player(Scope{Kind: ScopeConversation, Target: sgroupbot.TargetUser, ID: "X1"}, WindowAll, "u1"); p.Games != 1 || p.Correct != 2 {
+		t.Errorf("c2c: %+v", p)
+	}
+	if p, _ := stats.Player(Scope{Kind: ScopeGlobal}, WindowAll, "u1"); p.Games != 2 {
+		t.Errorf("global: %+v", p)
+	}
+}
+
+func TestStatsCache(t *testing.T) {
+	now := time.Date(2026, 10, 21, 23, 0, 0, 0, time.Local)
+	repo := &countingRepository{StatsRepository: NewMemoryStatsRepository()}
+	stats := NewStats(repo)
+	stats.now = func() time.Time { return now }
+	scope := Scope{Kind: ScopeConversation, Target: sgroupbot.TargetGroup, ID: "G1"}
+
+	stats.Record(finishedSession("G1", "", map[string]int{"u1": 1}, nil))
+	stats.Player(scope, WindowDay, "u1")
+	// 查询过的汇总不再遍历对局，新的对局增量计入
+	stats.Record(finishedSession("G1", "", map[string]int{"u1": 2}, nil))
+	stats.Record(finishedSession("G2", "", map[string]int{"u1": 4}, nil))
+	if p, _ := stats.Player(scope, WindowDay, "u1"); p.Games != 2 || p.Correct != 3 || repo.ranges != 1 {
+		t.Errorf("cached: %+v, %d ranges", p, repo.ranges)
+	}
+
+	// 过了一天窗口移动，重新汇总
+	now = now.Add(2 * time.Hour)
+	if p, _ := stats.Player(scope, WindowDay, "u1"); p.Games != 0 || repo.ranges != 2 {
+		t.Errorf("next day: %+v, %d ranges", p, repo.ranges)
+	}
+	if p, _ := stats.Player(scope, WindowAll, "u1"); p.Games != 2 {
+		t.Errorf("all: %+v", p)
+	}
+}
+
+// countingRepository 记录 Range 的次数
+type countingRepository struct {
+	StatsRepository
+	ranges int
+}
+
+func (c *countingRepository) Range(since int64, f func(r *GameResult) bool) error {
+	c.ranges++
+	return c.StatsRepository.Range(since, f)
+}
+
+func TestStatsRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.jsonl")
+	repo, err := NewFileStatsRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 21, 12, 0, 0, 0, time.Local)
+	stats := NewStats(repo)
+	for _, days := range []int{40, 20, 0} {
+		stats.now = func() time.Time { return now.AddDate(0, 0, -days) }
+		stats.Record(finishedSession("G1", "", map[string]int{"u1": 1}, nil))
+	}
+	stats.now = func() time.Time { return now }
+	scope := Scope{Kind: ScopeGlobal}
+	if p, _ := stats.Player(scope, WindowAll, "u1"); p.Games != 3 {
+		t.Fatalf("before trim: %+v", p)
+	}
+
+	// 只保留 30 天内的对局，文件重写之后继续追加
+	if err := stats.SetRetention(30 * 24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := stats.Player(scope, WindowAll, "u1"); p.Games != 2 {
+		t.Errorf("after trim: %+v", p)
+	}
+	stats.Record(finishedSession("G1", "", map[string]int{"u1": 1}, nil))
+	repo.Close()
+
+	repo, err = NewFileStatsRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	var n int
+	repo.Range(0, func(r *GameResult) bool {
+		n++
+		return true
+	})
+	if n != 3 {
+		t.Errorf("reloaded %d results, want 3", n)
+	}
+}
+
+func TestFileStatsRepository(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.jsonl")
+	repo, err := NewFileStatsRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, at := range []int64{200, 100} {
+		if err := repo.Add(GameResult{Time: at, Conversation: "G1", Players: []PlayerResult{{ID: "u1", Correct: 1}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo.Close()
+
+	repo, err = NewFileStatsRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	var times []int64
+	repo.Range(150, func(r *GameResult) bool {
+		times = append(times, r.Time)
+		return true
+	})
+	if len(times) != 1 || times[0] != 200 {
+		t.Errorf("range: %v", times)
+	}
+}
+
+func TestParseStatsQuery(t *testing.T) {
+	if q, ok := parseStatsQuery([]string{"本周", "全局"}); !ok || q.window != WindowWeek || q.scope != ScopeGlobal {
+		t.Errorf("query: %+v %v", q, ok)
+	}
+	if _, ok := parseStatsQuery([]string{"上周"}); ok {
+		t.Error("unknown window")
+	}
+}
+
+func TestApiServerStats(t *testing.T) {
+	srv, s := startTestServer(t)
+	s.is.SetRules(1, 3)
+
+	if rsp := say(t, srv, "我的战绩"); !strings.HasPrefix(rsp, "还没有战绩哦") {
+		t.Errorf("no stats: %s", rsp)
+	}
+	rsp := say(t, srv, "成语接龙")
+	current := strings.TrimPrefix(rsp, "成语接龙开始了哦，想想这个成语怎么接，")
+	if rsp := say(t, srv, answerFor(current)); !strings.Contains(rsp, "全部完成了哦") {
+		t.Fatalf("complete: %s", rsp)
+	}
+
+	if rsp := say(t, srv, "我的战绩"); rsp != "我的战绩（私聊·全部）\n1局 胜1局 胜率100% 答对1次 答错0次 最长连对1次 提示0次" {
+		t.Errorf("my stats: %q", rsp)
+	}
+	if rsp := say(t, srv, "排行榜 今日 全局"); rsp != "排行榜（全局·今日）\n1. 玩家U1 答对1次 胜率100%" {
+		t.Errorf("leaderboard: %q", rsp)
+	}
+	if rsp := say(t, srv, "排行榜 上周"); !strings.HasPrefix(rsp, "可以这样查") {
+		t.Errorf("usage: %q", rsp)
+	}
+}
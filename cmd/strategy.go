@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Moves 策略可以查询的接龙信息
+type Moves interface {
+	// Next 可以接在 idiom 之后、并且没有用过的全部成语，即对方可以选择的接法
+	Next(idiom string) []string
+	// Fewest Next 中按接法从少到多排列的前 n 个成语，接不下去的排在最后
+	Fewest(idiom string, n int) []string
+	// Count Next 中成语的数量，不生成列表
+	Count(idiom string) int
+}
+
+// Strategy 机器人选词的策略，同一个策略会被多个会话并发使用
+type Strategy interface {
+	// Name 策略的名称，与配置中的名称相同
+	Name() string
+	// Opening 从 openings 中选择开局的成语
+	Opening(m Moves, openings []string) string
+	// Reply 从 candidates 中选择回复的成语，candidates 不为空，并且都可以继续接下去
+	Reply(m Moves, candidates []string) string
+}
+
+// 内置的策略
+const (
+	StrategyRandom      = "random"      // 均匀随机
+	StrategyEasy        = "easy"        // 优先选择接法多的成语
+	StrategyHard        = "hard"        // 优先选择接法少的成语
+	StrategyAdversarial = "adversarial" // 向前看两步，让对方的选择尽量少
+)
+
+// strategyAliases 开始接龙时可以附带的难度，如“成语接龙 困难”
+var strategyAliases = map[string]string{
+	"随机": StrategyRandom,
+	"简单": StrategyEasy,
+	"困难": StrategyHard,
+	"对抗": StrategyAdversarial,
+}
+
+// NewStrategy 按名称创建策略，相同的 seed 得到相同的选择，用于测试与回放
+func NewStrategy(name string, seed int64) (Strategy, error) {
+	rnd := newLockedRand(seed)
+	switch name {
+	case StrategyRandom:
+		return &randomStrategy{rnd: rnd}, nil
+	case StrategyEasy:
+		return &scoredStrategy{name: name, rnd: rnd, score: func(m Moves) func(string) int {
+			return func(idiom string) int { return -m.Count(idiom) }
+		}}, nil
+	case StrategyHard:
+		return &scoredStrategy{name: name, rnd: rnd, score: func(m Moves) func(string) int {
+			return func(idiom string) int { return m.Count(idiom) }
+		}}, nil
+	case StrategyAdversarial:
+		return &scoredStrategy{name: name, rnd: rnd, score: adversarialScore}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q", name)
+}
+
+// NewRandomStrategy 均匀随机选词
+func NewRandomStrategy(seed int64) Strategy {
+	s, _ := NewStrategy(StrategyRandom, seed)
+	return s
+}
+
+// lockedRand 可以并发使用的随机数
+type lockedRand struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{rnd: rand.New(rand.NewSource(seed))}
+}
+
+func (r *lockedRand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Intn(n)
+}
+
+type randomStrategy struct {
+	rnd *lockedRand
+}
+
+func (s *randomStrategy) Name() string { return StrategyRandom }
+
+func (s *randomStrategy) Opening(m Moves, openings []string) string {
+	return openings[s.rnd.Intn(len(openings))]
+}
+
+func (s *randomStrategy) Reply(m Moves, candidates []string) string {
+	return candidates[s.rnd.Intn(len(candidates))]
+}
+
+// openingSamples 打分的策略开局时只从随机抽取的成语中选择，避免遍历整个成语库
+const openingSamples = 32
+
+// scoredStrategy 选择得分最低的成语，得分相同时随机选择。
+// score 每次选词时调用一次，返回的打分函数可以在这次选词中缓存查询的结果
+type scoredStrategy struct {
+	name  string
+	rnd   *lockedRand
+	score func(m Moves) func(idiom string) int
+}
+
+func (s *scoredStrategy) Name() string { return s.name }
+
+func (s *scoredStrategy) Opening(m Moves, openings []string) string {
+	if len(openings) <= openingSamples {
+		return s.Reply(m, openings)
+	}
+	samples := make([]string, openingSamples)
+	for i := range samples {
+		samples[i] = openings[s.rnd.Intn(len(openings))]
+	}
+	return s.Reply(m, samples)
+}
+
+func (s *scoredStrategy) Reply(m Moves, candidates []string) string {
+	var best []string
+	var bestScore int
+	scoreOf := s.score(m)
+	for _, c := range candidates {
+		score := scoreOf(c)
+		switch {
+		case len(best) == 0 || score < bestScore:
+			best, bestScore = append(best[:0], c), score
+		case score == bestScore:
+			best = append(best, c)
+		}
+	}
+	// 保证相同的 seed 得到相同的结果
+	sort.Strings(best)
+	return best[s.rnd.Intn(len(best))]
+}
+
+// adversarialWidth 对抗策略向前看时每一步最多考虑的接法数量，常用字开头的成语有上百个接法
+const adversarialWidth = 16
+
+// adversarialScore 对方接 idiom 时的选择数，加上对方无论怎么接、机器人再接之后对方最多还有的选择数。
+// 向前看时每一步只考虑接法最少的 adversarialWidth 个接法：对方接了之后机器人的回复越少越危险，
+// 机器人也优先回复让对方选择少的成语，所以是一个有界的近似。接法的数量与机器人的最佳回复在一次选词中只计算一次
+func adversarialScore(m Moves) func(idiom string) int {
+	degrees := map[string]int{}
+	pressures := map[string]int{}
+	degree := func(idiom string) int {
+		n, ok := degrees[idiom]
+		if !ok {
+			n = m.Count(idiom)
+			degrees[idiom] = n
+		}
+		return n
+	}
+	// pressure 对方接了 u 之后，机器人选择让对方选择最少的回复，返回对方的选择数
+	pressure := func(u string) int {
+		best, ok := pressures[u]
+		if ok {
+			return best
+		}
+		best = -1
+		for _, r := range m.Fewest(u, adversarialWidth) {
+			if n := degree(r); n > 0 && (best < 0 || n < best) {
+				best = n
+			}
+		}
+		pressures[u] = best
+		return best
+	}
+	return func(idiom string) int {
+		worst := 0
+		for _, u := range m.Fewest(idiom, adversarialWidth) {
+			if best := pressure(u); best > worst {
+				worst = best
+			}
+		}
+		return degree(idiom) + worst
+	}
+}
+
+// strategyFor 返回会话使用的策略，没有指定时使用默认的策略
+func (is *IdiomsSolitaire) strategyFor(ss *Session) Strategy {
+	if ss.strategy != nil {
+		return ss.strategy
+	}
+	return is.strategy
+}
+
+// moves 按照会话的规则与用过的成语查询接法
+type moves struct {
+	is   *IdiomsSolitaire
+	mode ChainMode
+	used map[string]struct{}
+}
+
+func (m *moves) Next(idiom string) []string {
+	var next []string
+	m.each(idiom, func(w string) bool {
+		next = append(next, w)
+		return true
+	})
+	return next
+}
+
+// Head Next 中的前 n 个成语，不生成完整的列表
+func (m *moves) Head(idiom string, n int) []string {
+	var next []string
+	m.each(idiom, func(w string) bool {
+		if len(next) >= n {
+			return false
+		}
+		next = append(next, w)
+		return true
+	})
+	return next
+}
+
+func (m *moves) Fewest(idiom string, n int) []string {
+	// 按接龙图中的出度排序，不考虑用过的成语与同音，只用于限制搜索的范围
+	rank := func(w string) int {
+		if s, ok := m.is.graph.stats[w]; ok && s.OutDegree > 0 {
+			return s.OutDegree
+		}
+		return math.MaxInt
+	}
+	next := m.Next(idiom)
+	sort.SliceStable(next, func(i, j int) bool {
+		return rank(next[i]) < rank(next[j])
+	})
+	if len(next) > n {
+		next = next[:n]
+	}
+	return next
+}
+
+func (m *moves) Count(idiom string) int {
+	// 同字接龙使用接龙图中的出度，再减去用过的成语，用过的成语通常远少于接法
+	if s, ok := m.is.graph.stats[idiom]; ok && m.mode == ChainChar && len(m.used) < s.OutDegree {
+		last, n := m.is.idioms[idiom], s.OutDegree
+		for w := range m.used {
+			if w != idiom && []rune(w)[0] == last {
+				n--
+			}
+		}
+		return n
+	}
+	var n int
+	m.each(idiom, func(string) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// each 依次访问可以接在 idiom 之后、并且没有用过的成语，f 返回 false 时停止
+func (m *moves) each(idiom string, f func(w string) bool) {
+	var same map[string]struct{}
+	lists := m.is.candidates(m.mode, idiom)
+	for i, list := range lists {
+		if i == 0 && len(lists) > 1 {
+			same = make(map[string]struct{}, len(list))
+		}
+		for _, w := range list {
+			if _, ok := m.used[w]; ok || w == idiom {
+				continue
+			}
+			if i == 0 {
+				if same != nil {
+					same[w] = struct{}{}
+				}
+			} else if _, ok := same[w]; ok {
+				continue // 同音的列表中可能包含同字的成语
+			}
+			if !f(w) {
+				return
+			}
+		}
+	}
+}
+
+func defaultSeed() int64 {
+	return time.Now().UnixNano()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+)
+
+// strategyIdioms 人山人海 之后可以接：
+// 海纳百川，对方只能接 川流不息，但机器人再接 息息相关 之后对方有 3 种接法；
+// 海阔天空，对方有 2 种接法，但都接不下去了
+func strategyIdioms() []Idiom {
+	words := []string{"人山人海", "海纳百川", "海阔天空", "川流不息", "息息相关", "关怀备至", "关门大吉", "关山迢递", "空前绝后", "空空如也"}
+	idioms := make([]Idiom, 0, len(words))
+	for _, w := range words {
+		runes := []rune(w)
+		idioms = append(idioms, Idiom{Word: w, FirstRune: runes[0], LastRune: runes[len(runes)-1]})
+	}
+	return idioms
+}
+
+func TestStrategy(t *testing.T) {
+	is := NewIdiomsSolitaire(strategyIdioms(), 60)
+	for name, want := range map[string]string{
+		StrategyEasy:        "海阔天空",
+		StrategyHard:        "海纳百川",
+		StrategyAdversarial: "海阔天空",
+	} {
+		strategy, err := NewStrategy(name, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next, _, ok := is.nextIdiom(strategy, ChainChar, "人山人海", nil); !ok || next != want {
+			t.Errorf("%s: got %s, want %s", name, next, want)
+		}
+	}
+
+	if _, err := NewStrategy("unknown", 1); err == nil {
+		t.Error("unknown strategy")
+	}
+}
+
+func TestMovesCount(t *testing.T) {
+	is := NewIdiomsSolitaire(strategyIdioms(), 60)
+	for _, used := range []map[string]struct{}{
+		nil,
+		{"关怀备至": {}},
+		{"关怀备至": {}, "关门大吉": {}, "息息相关": {}},
+	} {
+		m := &moves{is: is, mode: ChainChar, used: used}
+		for w := range is.idioms {
+			if n, next := m.Count(w), m.Next(w); n != len(next) {
+				t.Errorf("count %s with %v: %d, want %d", w, used, n, len(next))
+			}
+		}
+		if head := m.Head("息息相关", 1); len(head) > 1 {
+			t.Errorf("head: %v", head)
+		}
+	}
+}
+
+func TestAdversarialFewest(t *testing.T) {
+	// 起甲 之后机器人可以接 甲一乙 或 甲二丙：
+	// 接 甲一乙 时对方有 17 种接法，按字典序排在最后的 乙17己 只有一种接法，机器人接 己一庚 之后对方还有 5 种接法；
+	// 接 甲二丙 时对方有 19 种接法，但机器人再接之后对方只有 1 种接法
+	words := []string{"起甲", "甲一乙", "甲二丙", "丁一戊", "丁二戊", "戊一终", "己一庚"}
+	for i := 1; i <= 16; i++ {
+		words = append(words, fmt.Sprintf("乙%02d丁", i))
+	}
+	words = append(words, "乙17己")
+	for i := 1; i <= 19; i++ {
+		words = append(words, fmt.Sprintf("丙%02d丁", i))
+	}
+	for i := 1; i <= 5; i++ {
+		words = append(words, fmt.Sprintf("庚%d终", i))
+	}
+	idioms := make([]Idiom, 0, len(words))
+	for _, w := range words {
+		runes := []rune(w)
+		idioms = append(idioms, Idiom{Word: w, FirstRune: runes[0], LastRune: runes[len(runes)-1]})
+	}
+	is := NewIdiomsSolitaire(idioms, 60)
+
+	m := &moves{is: is, mode: ChainChar}
+	if fewest := m.Fewest("甲一乙", 1); len(fewest) != 1 || fewest[0] != "乙17己" {
+		t.Errorf("fewest: %v", fewest)
+	}
+	// 接不下去的排在最后
+	if fewest := m.Fewest("己一庚", 5); len(fewest) != 5 {
+		t.Errorf("fewest dead ends: %v", fewest)
+	}
+
+	strategy, _ := NewStrategy(StrategyAdversarial, 1)
+	if next, _, ok := is.nextIdiom(strategy, ChainChar, "起甲", nil); !ok || next != "甲二丙" {
+		t.Errorf("adversarial: %s", next)
+	}
+}
+
+func TestRandomStrategySeed(t *testing.T) {
+	is := NewIdiomsSolitaire(strategyIdioms(), 60)
+	a, b := NewRandomStrategy(42), NewRandomStrategy(42)
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		wa, _ := is.openingIdiom(a, ChainChar)
+		wb, _ := is.openingIdiom(b, ChainChar)
+		if wa != wb {
+			t.Fatalf("same seed should pick the same idiom: %s %s", wa, wb)
+		}
+		seen[wa] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("random opening always picks %v", seen)
+	}
+}
+
+func TestParseStart(t *testing.T) {
+	if opts, ok := parseStart("同音接龙 困难"); !ok || opts.Mode != ChainToneless || opts.Strategy.Name() != StrategyHard {
+		t.Errorf("with strategy: %+v %v", opts, ok)
+	}
+	if opts, ok := parseStart("成语接龙"); !ok || opts.Mode != ChainChar || opts.Strategy != nil {
+		t.Errorf("default: %+v %v", opts, ok)
+	}
+	for _, content := range []string{"成语接龙 很难", "接龙", "成语接龙 困难 吗"} {
+		if _, ok := parseStart(content); ok {
+			t.Errorf("%q should not start", content)
+		}
+	}
+}
+
+// BenchmarkAdversarialReply 在完整的成语库上为接法最多的成语选词，没有成语库时跳过
+func BenchmarkAdversarialReply(b *testing.B) {
+	idioms, err := LoadIdioms(idiomsPath)
+	if os.IsNotExist(err) {
+		b.Skip("no idioms file:", idiomsPath)
+	} else if err != nil {
+		b.Fatal(err)
+	}
+	is := NewIdiomsSolitaire(idioms, 60)
+	strategy, _ := NewStrategy(StrategyAdversarial, 1)
+
+	words := make([]string, 0, len(is.graph.stats))
+	for w := range is.graph.stats {
+		words = append(words, w)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		si, sj := is.graph.stats[words[i]], is.graph.stats[words[j]]
+		return si.OutDegree > sj.OutDegree || si.OutDegree == sj.OutDegree && words[i] < words[j]
+	})
+	if len(words) > 64 {
+		words = words[:64]
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		is.nextIdiom(strategy, ChainChar, words[i%len(words)], nil)
+	}
+}
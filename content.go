@@ -0,0 +1,262 @@
+package sgroupbot
+
+import (
+	"strings"
+)
+
+// TargetKind 消息发送的目标类型，不同类型支持的内嵌格式不同
+type TargetKind int
+
+const (
+	TargetChannel TargetKind = iota // 子频道
+	TargetDirect                    // 频道私信
+	TargetGroup                     // 群聊
+	TargetUser                      // 单聊
+)
+
+// TargetKindOf 根据接收到的消息事件类型，返回回复时对应的目标类型
+func TargetKindOf(eventType string) TargetKind {
+	switch eventType {
+	case EventDirectMessageCreate:
+		return TargetDirect
+	case EventGroupAtMessageCreate:
+		return TargetGroup
+	case EventC2CMessageCreate:
+		return TargetUser
+	default:
+		return TargetChannel
+	}
+}
+
+// 内嵌格式的类型
+const (
+	tokenUser = 1 << iota
+	tokenEveryone
+	tokenChannel
+	tokenEmoji
+)
+
+// 各目标类型支持的内嵌格式，群聊与单聊都不支持
+var targetTokens = map[TargetKind]int{
+	TargetChannel: tokenUser | tokenEveryone | tokenChannel | tokenEmoji,
+	TargetDirect:  tokenChannel | tokenEmoji,
+}
+
+// supports 判断目标类型是否支持对应的内嵌格式
+func (k TargetKind) supports(token int) bool {
+	return targetTokens[k]&token != 0
+}
+
+// MessageReference 引用消息
+type MessageReference struct {
+	MessageID             string `json:"message_id"`                         // 需要引用回复的消息 id
+	IgnoreGetMessageError bool   `json:"ignore_get_message_error,omitempty"` // 是否忽略获取引用消息详情错误
+}
+
+var contentEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// EscapeContent 转义消息内容，避免被当成内嵌格式解析
+func EscapeContent(s string) string {
+	return contentEscaper.Replace(s)
+}
+
+// MentionUser @用户
+func MentionUser(userID string) string {
+	return "<@" + userID + ">"
+}
+
+// MentionEveryone @所有人
+func MentionEveryone() string {
+	return "<@everyone>"
+}
+
+// ChannelLink #子频道，会被渲染成可点击跳转的子频道名
+func ChannelLink(channelID string) string {
+	return "<#" + channelID + ">"
+}
+
+// Emoji 系统表情
+func Emoji(emojiID string) string {
+	return "<emoji:" + emojiID + ">"
+}
+
+// ContentBuilder 构建消息内容，目标类型不支持的内嵌格式会被自动转义
+type ContentBuilder struct {
+	kind TargetKind
+	sb   strings.Builder
+}
+
+func NewContentBuilder(kind TargetKind) *ContentBuilder {
+	return &ContentBuilder{kind: kind}
+}
+
+func (b *ContentBuilder) token(token int, s string) *ContentBuilder {
+	if b.kind.supports(token) {
+		b.sb.WriteString(s)
+	} else {
+		b.sb.WriteString(EscapeContent(s))
+	}
+	return b
+}
+
+// Text 追加普通文本，会被转义
+func (b *ContentBuilder) Text(s string) *ContentBuilder {
+	b.sb.WriteString(EscapeContent(s))
+	return b
+}
+
+func (b *ContentBuilder) MentionUser(userID string) *ContentBuilder {
+	return b.token(tokenUser, MentionUser(userID))
+}
+
+func (b *ContentBuilder) MentionEveryone() *ContentBuilder {
+	return b.token(tokenEveryone, MentionEveryone())
+}
+
+func (b *ContentBuilder) ChannelLink(channelID string) *ContentBuilder {
+	return b.token(tokenChannel, ChannelLink(channelID))
+}
+
+func (b *ContentBuilder) Emoji(emojiID string) *ContentBuilder {
+	return b.token(tokenEmoji, Emoji(emojiID))
+}
+
+func (b *ContentBuilder) String() string {
+	return b.sb.String()
+}
+
+// SegmentType 消息内容片段的类型
+type SegmentType int
+
+const (
+	SegmentText     SegmentType = iota // 普通文本
+	SegmentUser                        // @用户，<@user_id> 或 <@!user_id>
+	SegmentRole                        // @身份组，<@&role_id>
+	SegmentEveryone                    // @所有人，<@everyone>
+	SegmentChannel                     // #子频道，<#channel_id>
+	SegmentEmoji                       // 表情，<emoji:id> 或群聊的 <faceType=1,faceId="id",ext="...">
+)
+
+// Segment 消息内容片段
+type Segment struct {
+	Type SegmentType
+	Text string // 文本内容，已反转义，仅 SegmentText 有效
+	ID   string // 用户/身份组/子频道/表情的ID
+	Raw  string // 原始内容
+}
+
+// Content 解析后的消息内容
+type Content []Segment
+
+var contentUnescaper = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">")
+
+// UnescapeContent 反转义消息内容
+func UnescapeContent(s string) string {
+	return contentUnescaper.Replace(s)
+}
+
+// ParseContent 将消息内容解析为片段，无法识别的内嵌格式按文本处理
+func ParseContent(s string) Content {
+	var content Content
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			raw := text.String()
+			content = append(content, Segment{Type: SegmentText, Text: UnescapeContent(raw), Raw: raw})
+			text.Reset()
+		}
+	}
+
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			text.WriteString(s)
+			break
+		}
+		text.WriteString(s[:i])
+		s = s[i:]
+
+		j := strings.IndexByte(s, '>')
+		if j < 0 {
+			text.WriteString(s)
+			break
+		}
+		if seg, ok := parseToken(s[:j+1]); ok {
+			flush()
+			content = append(content, seg)
+		} else {
+			text.WriteString(s[:1])
+			s = s[1:]
+			continue
+		}
+		s = s[j+1:]
+	}
+	flush()
+
+	return content
+}
+
+// parseToken 解析单个内嵌格式，token 包含首尾的尖括号
+func parseToken(token string) (Segment, bool) {
+	seg := Segment{Raw: token}
+	body := token[1 : len(token)-1]
+	switch {
+	case body == "@everyone":
+		seg.Type = SegmentEveryone
+	case strings.HasPrefix(body, "@!"):
+		seg.Type, seg.ID = SegmentUser, body[2:]
+	case strings.HasPrefix(body, "@&"):
+		seg.Type, seg.ID = SegmentRole, body[2:]
+	case strings.HasPrefix(body, "@"):
+		seg.Type, seg.ID = SegmentUser, body[1:]
+	case strings.HasPrefix(body, "#"):
+		seg.Type, seg.ID = SegmentChannel, body[1:]
+	case strings.HasPrefix(body, "emoji:"):
+		seg.Type, seg.ID = SegmentEmoji, body[len("emoji:"):]
+	case strings.HasPrefix(body, "faceType="):
+		seg.Type = SegmentEmoji
+		for _, kv := range strings.Split(body, ",") {
+			if v, ok := strings.CutPrefix(kv, "faceId="); ok {
+				seg.ID = strings.Trim(v, `"`)
+			}
+		}
+	default:
+		return seg, false
+	}
+
+	if seg.Type != SegmentEveryone && (len(seg.ID) == 0 || strings.ContainsAny(seg.ID, " <")) {
+		return seg, false
+	}
+	return seg, true
+}
+
+// PlainText 返回去掉机器人@之后的文本内容，并去掉首尾空白
+func (c Content) PlainText(botID string) string {
+	var sb strings.Builder
+	for i := range c {
+		seg := &c[i]
+		switch seg.Type {
+		case SegmentText:
+			sb.WriteString(seg.Text)
+		case SegmentUser:
+			if seg.ID == botID {
+				continue
+			}
+			sb.WriteString(seg.Raw)
+		default:
+			sb.WriteString(seg.Raw)
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// Mentions 返回被@的用户ID
+func (c Content) Mentions() []string {
+	var ids []string
+	for i := range c {
+		if c[i].Type == SegmentUser {
+			ids = append(ids, c[i].ID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,131 @@
+package sgroupbot_test
+
+import (
+	"sgroupbot"
+	"testing"
+)
+
+func TestContentBuilder(t *testing.T) {
+	var cases = []struct {
+		kind sgroupbot.TargetKind
+		want string
+	}{
+		{sgroupbot.TargetChannel, "<@123> a&amp;b <#456><emoji:4><@everyone>"},
+		{sgroupbot.TargetDirect, "&lt;@123&gt; a&amp;b <#456><emoji:4>&lt;@everyone&gt;"},
+		{sgroupbot.TargetGroup, "&lt;@123&gt; a&amp;b &lt;#456&gt;&lt;emoji:4&gt;&lt;@everyone&gt;"},
+		{sgroupbot.TargetUser, "&lt;@123&gt; a&amp;b &lt;#456&gt;&lt;emoji:4&gt;&lt;@everyone&gt;"},
+	}
+
+	for _, c := range cases {
+		got := sgroupbot.NewContentBuilder(c.kind).
+			MentionUser("123").
+			Text(" a&b ").
+			ChannelLink("456").
+			Emoji("4").
+			MentionEveryone().
+			String()
+		if got != c.want {
+			t.Errorf("kind %d: got %q, want %q", c.kind, got, c.want)
+		}
+	}
+}
+
+func TestParseContent(t *testing.T) {
+	const botID = "6158788878435714165"
+
+	var cases = []struct {
+		name     string
+		content  string
+		plain    string
+		segments []sgroupbot.SegmentType
+	}{
+		{
+			name:     "guild at prefix",
+			content:  "<@!6158788878435714165> 成语接龙",
+			plain:    "成语接龙",
+			segments: []sgroupbot.SegmentType{sgroupbot.SegmentUser, sgroupbot.SegmentText},
+		},
+		{
+			name:     "guild at without space",
+			content:  "<@!6158788878435714165>退出",
+			plain:    "退出",
+			segments: []sgroupbot.SegmentType{sgroupbot.SegmentUser, sgroupbot.SegmentText},
+		},
+		{
+			name:     "guild at in the middle",
+			content:  "一马当先 <@!6158788878435714165> ",
+			plain:    "一马当先",
+			segments: []sgroupbot.SegmentType{sgroupbot.SegmentText, sgroupbot.SegmentUser, sgroupbot.SegmentText},
+		},
+		{
+			name:    "guild mixed tokens",
+			content: "<@!6158788878435714165> <@1234> <@&5> <#9988> <emoji:4> a&lt;b&amp;c",
+			plain:   "<@1234> <@&5> <#9988> <emoji:4> a<b&c",
+			segments: []sgroupbot.SegmentType{
+				sgroupbot.SegmentUser, sgroupbot.SegmentText,
+				sgroupbot.SegmentUser, sgroupbot.SegmentText,
+				sgroupbot.SegmentRole, sgroupbot.SegmentText,
+				sgroupbot.SegmentChannel, sgroupbot.SegmentText,
+				sgroupbot.SegmentEmoji, sgroupbot.SegmentText,
+			},
+		},
+		{
+			name:     "guild everyone",
+			content:  "<@everyone> 开始",
+			plain:    "<@everyone> 开始",
+			segments: []sgroupbot.SegmentType{sgroupbot.SegmentEveryone, sgroupbot.SegmentText},
+		},
+		{
+			name:     "group at message",
+			content:  " aaa",
+			plain:    "aaa",
+			segments: []sgroupbot.SegmentType{sgroupbot.SegmentText},
+		},
+		{
+			name:     "group face",
+			content:  ` <faceType=1,faceId="13",ext="eyJ0ZXh0IjoiIn0=">一马当先`,
+			plain:    `<faceType=1,faceId="13",ext="eyJ0ZXh0IjoiIn0=">一马当先`,
+			segments: []sgroupbot.SegmentType{sgroupbot.SegmentText, sgroupbot.SegmentEmoji, sgroupbot.SegmentText},
+		},
+		{
+			name:     "direct message",
+			content:  "成语接龙",
+			plain:    "成语接龙",
+			segments: []sgroupbot.SegmentType{sgroupbot.SegmentText},
+		},
+		{
+			name:     "broken token",
+			content:  "1 < 2 <@ x> 3>",
+			plain:    "1 < 2 <@ x> 3>",
+			segments: []sgroupbot.SegmentType{sgroupbot.SegmentText},
+		},
+		{
+			name:     "only bot mention",
+			content:  "<@!6158788878435714165>",
+			plain:    "",
+			segments: []sgroupbot.SegmentType{sgroupbot.SegmentUser},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			content := sgroupbot.ParseContent(c.content)
+			if got := content.PlainText(botID); got != c.plain {
+				t.Errorf("plain text: got %q, want %q", got, c.plain)
+			}
+			if len(content) != len(c.segments) {
+				t.Fatalf("segments: got %+v, want %v", content, c.segments)
+			}
+			for i := range content {
+				if content[i].Type != c.segments[i] {
+					t.Errorf("segment %d: got %d, want %d", i, content[i].Type, c.segments[i])
+				}
+			}
+		})
+	}
+
+	content := sgroupbot.ParseContent(`<@!1> <faceType=1,faceId="13",ext="">`)
+	if content[0].ID != "1" || content[2].ID != "13" {
+		t.Errorf("ids: %+v", content)
+	}
+}
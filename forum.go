@@ -0,0 +1,277 @@
+package sgroupbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	ThreadsAPI = "/channels/%s/threads"    // channel_id
+	ThreadAPI  = "/channels/%s/threads/%s" // channel_id, thread_id
+)
+
+// 发表帖子的内容格式
+const (
+	ThreadFormatText     = 1
+	ThreadFormatHTML     = 2
+	ThreadFormatMarkdown = 3
+	ThreadFormatJSON     = 4
+)
+
+// 富文本元素类型
+const (
+	RichTextElemText  = 1
+	RichTextElemImage = 2
+	RichTextElemVideo = 3
+	RichTextElemURL   = 4
+)
+
+// RichText 帖子、评论与回复的富文本内容，平台以 json 字符串下发
+//
+//	{"paragraphs":[{"elems":[{"text":{"text":"成语接龙周榜"},"type":1}],"props":{}}]}
+type RichText struct {
+	Paragraphs []Paragraph `json:"paragraphs"`
+}
+
+type Paragraph struct {
+	Elems []RichTextElem `json:"elems"`
+	Props struct {
+		Alignment int `json:"alignment,omitempty"` // 0 左对齐，1 居中，2 右对齐
+	} `json:"props"`
+}
+
+type RichTextElem struct {
+	Type int `json:"type"`
+	Text *struct {
+		Text string `json:"text"`
+	} `json:"text,omitempty"`
+	Image *struct {
+		ThirdURL     string     `json:"third_url"`
+		WidthPercent float64    `json:"width_percent"`
+		PlatImage    *PlatImage `json:"plat_image,omitempty"`
+	} `json:"image,omitempty"`
+	Video *struct {
+		ThirdURL  string `json:"third_url"`
+		PlatVideo *struct {
+			URL      string    `json:"url"`
+			Width    int       `json:"width"`
+			Height   int       `json:"height"`
+			VideoID  string    `json:"video_id"`
+			Duration int       `json:"duration"`
+			Cover    PlatImage `json:"cover"`
+		} `json:"plat_video,omitempty"`
+	} `json:"video,omitempty"`
+	URL *struct {
+		URL  string `json:"url"`
+		Desc string `json:"desc"`
+	} `json:"url,omitempty"`
+}
+
+type PlatImage struct {
+	URL     string `json:"url"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	ImageID string `json:"image_id"`
+}
+
+// ParseRichText 解析富文本 json 字符串
+func ParseRichText(s string) (*RichText, error) {
+	var rt RichText
+	if err := json.Unmarshal([]byte(s), &rt); err != nil {
+		return nil, fmt.Errorf("parse rich text: %w", err)
+	}
+	return &rt, nil
+}
+
+// PlainText 提取文本与链接，段落之间换行
+func (rt *RichText) PlainText() string {
+	var sb strings.Builder
+	for i, p := range rt.Paragraphs {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		for _, e := range p.Elems {
+			switch {
+			case e.Type == RichTextElemText && e.Text != nil:
+				sb.WriteString(e.Text.Text)
+			case e.Type == RichTextElemURL && e.URL != nil:
+				if len(e.URL.Desc) > 0 {
+					sb.WriteString(e.URL.Desc)
+				} else {
+					sb.WriteString(e.URL.URL)
+				}
+			}
+		}
+	}
+	return sb.String()
+}
+
+// ForumInfo 帖子、评论与回复的公共部分
+type ForumInfo struct {
+	ThreadID string    `json:"thread_id"`
+	PostID   string    `json:"post_id,omitempty"`
+	ReplyID  string    `json:"reply_id,omitempty"`
+	Title    string    `json:"title,omitempty"` // 富文本，仅帖子有
+	Content  string    `json:"content"`         // 富文本
+	DateTime Timestamp `json:"date_time"`
+}
+
+// RichTitle 解析帖子标题
+func (f *ForumInfo) RichTitle() (*RichText, error) {
+	return ParseRichText(f.Title)
+}
+
+// RichContent 解析内容
+func (f *ForumInfo) RichContent() (*RichText, error) {
+	return ParseRichText(f.Content)
+}
+
+// Thread 帖子，FORUM_THREAD_CREATE/FORUM_THREAD_UPDATE/FORUM_THREAD_DELETE 的数据
+type Thread struct {
+	GuildID    string    `json:"guild_id"`
+	ChannelID  string    `json:"channel_id"`
+	AuthorID   string    `json:"author_id"`
+	ThreadInfo ForumInfo `json:"thread_info"`
+}
+
+// Post 评论，FORUM_POST_CREATE/FORUM_POST_DELETE 的数据
+type Post struct {
+	GuildID   string    `json:"guild_id"`
+	ChannelID string    `json:"channel_id"`
+	AuthorID  string    `json:"author_id"`
+	PostInfo  ForumInfo `json:"post_info"`
+}
+
+// Reply 回复，FORUM_REPLY_CREATE/FORUM_REPLY_DELETE 的数据
+type Reply struct {
+	GuildID   string    `json:"guild_id"`
+	ChannelID string    `json:"channel_id"`
+	AuthorID  string    `json:"author_id"`
+	ReplyInfo ForumInfo `json:"reply_info"`
+}
+
+// 论坛发表审核的对象类型
+const (
+	ForumAuditThread = 1
+	ForumAuditPost   = 2
+	ForumAuditReply  = 3
+)
+
+// ForumAuditResult FORUM_PUBLISH_AUDIT_RESULT 的数据
+type ForumAuditResult struct {
+	TaskID    string    `json:"task_id"`
+	GuildID   string    `json:"guild_id"`
+	ChannelID string    `json:"channel_id"`
+	AuthorID  string    `json:"author_id"`
+	ThreadID  string    `json:"thread_id"`
+	PostID    string    `json:"post_id"`
+	ReplyID   string    `json:"reply_id"`
+	Type      int       `json:"type"`
+	Result    int       `json:"result"` // 0 审核通过，1 审核不通过
+	ErrMsg    string    `json:"err_msg"`
+	DateTime  Timestamp `json:"date_time"`
+}
+
+// ForumHandler 处理论坛事件，event 为 *Thread、*Post、*Reply 或 *ForumAuditResult 其中之一
+type ForumHandler func(eventType string, event interface{})
+
+// HandleForum 将 ForumHandler 包装为 EventHandler，需要设置 IntentForumsEvent
+func HandleForum(h ForumHandler) EventHandler {
+	return func(wm WsMessage) {
+		var event interface{}
+		switch wm.Type {
+		case EventForumThreadCreate, EventForumThreadUpdate, EventForumThreadDelete:
+			event = &Thread{}
+		case EventForumPostCreate, EventForumPostDelete:
+			event = &Post{}
+		case EventForumReplyCreate, EventForumReplyDelete:
+			event = &Reply{}
+		case EventForumAuditResult:
+			event = &ForumAuditResult{}
+		default:
+			return
+		}
+		if err := json.Unmarshal(wm.Data, event); err != nil {
+			DefaultLogger.Warn("forum_event", "event", wm.Type, "err", err)
+			return
+		}
+		h(wm.Type, event)
+	}
+}
+
+// ForumEvents 论坛的全部事件
+var ForumEvents = []string{
+	EventForumThreadCreate, EventForumThreadUpdate, EventForumThreadDelete,
+	EventForumPostCreate, EventForumPostDelete,
+	EventForumReplyCreate, EventForumReplyDelete,
+	EventForumAuditResult,
+}
+
+type PutThreadRequest struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	Format  int    `json:"format"`
+}
+
+type PutThreadResponse struct {
+	TaskID     string `json:"task_id"` // 与 FORUM_PUBLISH_AUDIT_RESULT 的 task_id 对应
+	CreateTime string `json:"create_time"`
+
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// PutThread 发表帖子，发表结果通过 FORUM_PUBLISH_AUDIT_RESULT 事件通知
+func (a *API) PutThread(channelID string, request PutThreadRequest) (*PutThreadResponse, error) {
+	method := http.MethodPut
+	api := fmt.Sprintf(ThreadsAPI, channelID)
+	var result PutThreadResponse
+	if err := a.doSimpleRequest(method, api, &request, &result); err != nil {
+		return nil, err
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("code: %d, msg: %s", result.Code, result.Message)
+	}
+
+	return &result, nil
+}
+
+type ThreadsResponse struct {
+	Threads  []Thread `json:"threads"`
+	IsFinish int      `json:"is_finish"` // 1 表示已经拉取完
+
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// GetThreads 获取子频道的帖子列表
+func (a *API) GetThreads(channelID string) (*ThreadsResponse, error) {
+	method := http.MethodGet
+	api := fmt.Sprintf(ThreadsAPI, channelID)
+	var result ThreadsResponse
+	if err := a.doSimpleRequest(method, api, nil, &result); err != nil {
+		return nil, err
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("code: %d, msg: %s", result.Code, result.Message)
+	}
+
+	return &result, nil
+}
+
+// DeleteThread 删除帖子
+func (a *API) DeleteThread(channelID, threadID string) error {
+	method := http.MethodDelete
+	api := fmt.Sprintf(ThreadAPI, channelID, threadID)
+	var result CreateMessageResposne
+	if err := a.doSimpleRequest(method, api, nil, &result); err != nil {
+		return err
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("code: %d, msg: %s", result.Code, result.Message)
+	}
+
+	return nil
+}
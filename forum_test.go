@@ -0,0 +1,120 @@
+package sgroupbot_test
+
+import (
+	"encoding/json"
+	"sgroupbot"
+	"testing"
+)
+
+func TestParseRichText(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "text",
+			content: `{"paragraphs":[{"elems":[{"text":{"text":"成语接龙周榜"},"type":1}],"props":{}}]}`,
+			want:    "成语接龙周榜",
+		},
+		{
+			name: "paragraphs",
+			content: `{"paragraphs":[{"elems":[{"text":{"text":"第一名 "},"type":1},{"text":{"text":"小张"},"type":1}],"props":{"alignment":1}},` +
+				`{"elems":[{"text":{"text":"详情见"},"type":1},{"url":{"url":"https://qun.qq.com","desc":"活动页"},"type":4}],"props":{}}]}`,
+			want: "第一名 小张\n详情见活动页",
+		},
+		{
+			name: "media",
+			content: `{"paragraphs":[{"elems":[{"image":{"plat_image":{"url":"https://example.com/a.jpg","width":1080,"height":1080,"image_id":"a1"}},"type":2}],"props":{}},` +
+				`{"elems":[{"video":{"plat_video":{"url":"https://example.com/v.mp4","width":720,"height":1280,"video_id":"v1","duration":15,"cover":{"url":"https://example.com/c.jpg"}}},"type":3},` +
+				`{"url":{"url":"https://qun.qq.com"},"type":4}],"props":{}}]}`,
+			want: "\nhttps://qun.qq.com",
+		},
+		{
+			name:    "empty",
+			content: `{"paragraphs":[]}`,
+			want:    "",
+		},
+	} {
+		rt, err := sgroupbot.ParseRichText(tt.content)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := rt.PlainText(); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := sgroupbot.ParseRichText("成语接龙周榜"); err == nil {
+		t.Error("want error for plain string")
+	}
+}
+
+func TestParseRichTextMedia(t *testing.T) {
+	rt, err := sgroupbot.ParseRichText(`{"paragraphs":[{"elems":[{"image":{"third_url":"","width_percent":1,` +
+		`"plat_image":{"url":"https://example.com/a.jpg","width":1080,"height":720,"image_id":"a1"}},"type":2}],"props":{}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elem := rt.Paragraphs[0].Elems[0]
+	if elem.Type != sgroupbot.RichTextElemImage || elem.Image == nil || elem.Image.PlatImage == nil ||
+		elem.Image.PlatImage.ImageID != "a1" || elem.Image.PlatImage.Height != 720 {
+		t.Errorf("image: %+v", elem)
+	}
+}
+
+func TestHandleForum(t *testing.T) {
+	var gotType string
+	var got interface{}
+	handler := sgroupbot.HandleForum(func(eventType string, event interface{}) {
+		gotType, got = eventType, event
+	})
+	event := func(eventType, data string) {
+		var wm sgroupbot.WsMessage
+		wm.Type = eventType
+		wm.Data = json.RawMessage(data)
+		gotType, got = "", nil
+		handler(wm)
+	}
+
+	// 标题与内容是 json 字符串
+	event(sgroupbot.EventForumThreadCreate, `{"guild_id":"18700000000001","channel_id":"100010","author_id":"1234",`+
+		`"thread_info":{"thread_id":"B_1","title":"{\"paragraphs\":[{\"elems\":[{\"text\":{\"text\":\"周榜\"},\"type\":1}],\"props\":{}}]}",`+
+		`"content":"{\"paragraphs\":[{\"elems\":[{\"text\":{\"text\":\"第一名 小张\"},\"type\":1}],\"props\":{}}]}","date_time":"2024-09-04T13:12:43+08:00"}}`)
+	thread, ok := got.(*sgroupbot.Thread)
+	if !ok || gotType != sgroupbot.EventForumThreadCreate || thread.AuthorID != "1234" || thread.ThreadInfo.ThreadID != "B_1" {
+		t.Fatalf("thread: %s %+v", gotType, got)
+	}
+	if title, err := thread.ThreadInfo.RichTitle(); err != nil || title.PlainText() != "周榜" {
+		t.Errorf("title: %+v %v", title, err)
+	}
+	if content, err := thread.ThreadInfo.RichContent(); err != nil || content.PlainText() != "第一名 小张" {
+		t.Errorf("content: %+v %v", content, err)
+	}
+
+	event(sgroupbot.EventForumPostCreate, `{"guild_id":"g1","channel_id":"c1","author_id":"u2",`+
+		`"post_info":{"thread_id":"B_1","post_id":"P_1","content":"{\"paragraphs\":[]}","date_time":"2024-09-04T13:12:43+08:00"}}`)
+	if post, ok := got.(*sgroupbot.Post); !ok || post.PostInfo.PostID != "P_1" || post.AuthorID != "u2" {
+		t.Errorf("post: %+v", got)
+	}
+
+	event(sgroupbot.EventForumReplyDelete, `{"guild_id":"g1","channel_id":"c1","author_id":"u3",`+
+		`"reply_info":{"thread_id":"B_1","post_id":"P_1","reply_id":"R_1","content":"","date_time":"2024-09-04T13:12:43+08:00"}}`)
+	if reply, ok := got.(*sgroupbot.Reply); !ok || reply.ReplyInfo.ReplyID != "R_1" || gotType != sgroupbot.EventForumReplyDelete {
+		t.Errorf("reply: %+v", got)
+	}
+
+	event(sgroupbot.EventForumAuditResult, `{"task_id":"t1","guild_id":"g1","channel_id":"c1","author_id":"u1",`+
+		`"thread_id":"B_1","type":1,"result":1,"err_msg":"内容违规","date_time":"2024-09-04T13:12:43+08:00"}`)
+	if audit, ok := got.(*sgroupbot.ForumAuditResult); !ok || audit.Type != sgroupbot.ForumAuditThread || audit.Result != 1 || audit.ErrMsg != "内容违规" {
+		t.Errorf("audit: %+v", got)
+	}
+
+	// 其它事件与解析失败时不调用
+	event(sgroupbot.EventAtMessageCreate, `{}`)
+	event(sgroupbot.EventForumThreadDelete, `[]`)
+	if got != nil {
+		t.Errorf("ignored: %s %+v", gotType, got)
+	}
+}
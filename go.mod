@@ -6,6 +6,7 @@ require (
 	github.com/gorilla/websocket v1.5.3
 	github.com/panjf2000/ants/v2 v2.10.0
 	github.com/puzpuzpuz/xsync/v3 v3.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require golang.org/x/sync v0.3.0 // indirect
@@ -0,0 +1,154 @@
+package sgroupbot
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+//	{
+//	    "timestamp": 1725426763,
+//	    "group_openid": "0DE2782CA6DD4FB3B29730FC6F7C26AC",
+//	    "op_member_openid": "4381EC917E8332933D6A2B7893C28ACB"
+//	}
+//
+// GroupEvent GROUP_ADD_ROBOT/GROUP_DEL_ROBOT/GROUP_MSG_REJECT/GROUP_MSG_RECEIVE 的数据
+type GroupEvent struct {
+	Timestamp      Timestamp `json:"timestamp"`
+	GroupOpenID    string    `json:"group_openid"`
+	OpMemberOpenID string    `json:"op_member_openid"` // 操作的群成员
+}
+
+//	{
+//	    "timestamp": 1725426763,
+//	    "openid": "4381EC917E8332933D6A2B7893C28ACB"
+//	}
+//
+// FriendEvent FRIEND_ADD/FRIEND_DEL/C2C_MSG_REJECT/C2C_MSG_RECEIVE 的数据
+type FriendEvent struct {
+	Timestamp Timestamp `json:"timestamp"`
+	OpenID    string    `json:"openid"`
+}
+
+// 主动消息的推送状态
+const (
+	PushUnknown  = iota // 没有收到过相关事件，默认允许
+	PushAllowed         // 已添加机器人，或者开启了主动消息
+	PushRejected        // 关闭了主动消息
+	PushRemoved         // 机器人被移出群聊，或者用户删除了机器人
+)
+
+// Subscriptions 记录群与用户是否允许机器人主动推送消息
+type Subscriptions struct {
+	mu     sync.RWMutex
+	groups map[string]int // group_openid -> 推送状态
+	users  map[string]int // openid -> 推送状态
+}
+
+func NewSubscriptions() *Subscriptions {
+	return &Subscriptions{
+		groups: make(map[string]int),
+		users:  make(map[string]int),
+	}
+}
+
+// GroupEvents 群聊相关的通知事件
+var GroupEvents = []string{EventGroupAddRobot, EventGroupDelRobot, EventGroupMsgReject, EventGroupMsgReceive}
+
+// FriendEvents 单聊相关的通知事件
+var FriendEvents = []string{EventFriendAdd, EventFriendDel, EventC2CMsgReject, EventC2CMsgReceive}
+
+// Register 注册群聊与单聊的通知事件，需要同时设置 IntentGroupAndC2CEvent
+func (s *Subscriptions) Register(api *API) {
+	if api.Handlers == nil {
+		api.Handlers = make(map[string]EventHandler)
+	}
+	for _, t := range GroupEvents {
+		api.Handlers[t] = s.HandleEvent
+	}
+	for _, t := range FriendEvents {
+		api.Handlers[t] = s.HandleEvent
+	}
+	api.Intents |= IntentGroupAndC2CEvent
+}
+
+// HandleEvent 根据通知事件更新推送状态
+func (s *Subscriptions) HandleEvent(wm WsMessage) {
+	switch wm.Type {
+	case EventGroupAddRobot, EventGroupDelRobot, EventGroupMsgReject, EventGroupMsgReceive:
+		var event GroupEvent
+		if err := json.Unmarshal(wm.Data, &event); err != nil {
+			DefaultLogger.Warn("group_event", "event", wm.Type, "err", err)
+			return
+		}
+		s.ApplyGroupEvent(wm.Type, &event)
+	case EventFriendAdd, EventFriendDel, EventC2CMsgReject, EventC2CMsgReceive:
+		var event FriendEvent
+		if err := json.Unmarshal(wm.Data, &event); err != nil {
+			DefaultLogger.Warn("friend_event", "event", wm.Type, "err", err)
+			return
+		}
+		s.ApplyFriendEvent(wm.Type, &event)
+	}
+}
+
+func (s *Subscriptions) ApplyGroupEvent(eventType string, event *GroupEvent) {
+	var state int
+	switch eventType {
+	case EventGroupAddRobot, EventGroupMsgReceive:
+		state = PushAllowed
+	case EventGroupMsgReject:
+		state = PushRejected
+	case EventGroupDelRobot:
+		state = PushRemoved
+	default:
+		return
+	}
+
+	s.mu.Lock()
+	s.groups[event.GroupOpenID] = state
+	s.mu.Unlock()
+}
+
+func (s *Subscriptions) ApplyFriendEvent(eventType string, event *FriendEvent) {
+	var state int
+	switch eventType {
+	case EventFriendAdd, EventC2CMsgReceive:
+		state = PushAllowed
+	case EventC2CMsgReject:
+		state = PushRejected
+	case EventFriendDel:
+		state = PushRemoved
+	default:
+		return
+	}
+
+	s.mu.Lock()
+	s.users[event.OpenID] = state
+	s.mu.Unlock()
+}
+
+// GroupState 群的推送状态
+func (s *Subscriptions) GroupState(groupOpenID string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.groups[groupOpenID]
+}
+
+// UserState 用户的推送状态
+func (s *Subscriptions) UserState(openID string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.users[openID]
+}
+
+// GroupAllowed 是否可以向群主动推送消息
+func (s *Subscriptions) GroupAllowed(groupOpenID string) bool {
+	state := s.GroupState(groupOpenID)
+	return state == PushUnknown || state == PushAllowed
+}
+
+// UserAllowed 是否可以向用户主动推送消息
+func (s *Subscriptions) UserAllowed(openID string) bool {
+	state := s.UserState(openID)
+	return state == PushUnknown || state == PushAllowed
+}
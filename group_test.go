@@ -0,0 +1,57 @@
+package sgroupbot_test
+
+import (
+	"encoding/json"
+	"sgroupbot"
+	"testing"
+)
+
+func TestSubscriptions(t *testing.T) {
+	subs := sgroupbot.NewSubscriptions()
+	api := sgroupbot.API{}
+	subs.Register(&api)
+	if api.Intents&sgroupbot.IntentGroupAndC2CEvent == 0 {
+		t.Error("intent not set")
+	}
+
+	send := func(eventType, data string) {
+		var wm sgroupbot.WsMessage
+		wm.Type = eventType
+		wm.Data = json.RawMessage(data)
+		api.Handlers[eventType](wm)
+	}
+
+	const group = `{"timestamp":1725426763,"group_openid":"G1","op_member_openid":"M1"}`
+	if !subs.GroupAllowed("G1") {
+		t.Error("unknown group should be allowed")
+	}
+	send(sgroupbot.EventGroupMsgReject, group)
+	if subs.GroupAllowed("G1") || subs.GroupState("G1") != sgroupbot.PushRejected {
+		t.Error("rejected group should not be allowed")
+	}
+	send(sgroupbot.EventGroupMsgReceive, group)
+	if !subs.GroupAllowed("G1") {
+		t.Error("group turned notifications on")
+	}
+	send(sgroupbot.EventGroupDelRobot, group)
+	if subs.GroupAllowed("G1") {
+		t.Error("removed group should not be allowed")
+	}
+
+	send(sgroupbot.EventC2CMsgReject, `{"timestamp":1725426763,"openid":"U1"}`)
+	if subs.UserAllowed("U1") || !subs.UserAllowed("U2") {
+		t.Error("user state")
+	}
+	send(sgroupbot.EventFriendAdd, `{"timestamp":1725426763,"openid":"U1"}`)
+	if subs.UserState("U1") != sgroupbot.PushAllowed {
+		t.Error("friend added")
+	}
+
+	var event sgroupbot.GroupEvent
+	if err := json.Unmarshal([]byte(group), &event); err != nil {
+		t.Fatal(err)
+	}
+	if ts, err := event.Timestamp.Time(); err != nil || ts.Unix() != 1725426763 {
+		t.Errorf("timestamp: %v %v", ts, err)
+	}
+}
@@ -0,0 +1,186 @@
+package sgroupbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	InteractionAPI = "/interactions/%s" // interaction_id
+)
+
+// 互动事件的场景
+const (
+	SceneGuild = "guild"
+	SceneGroup = "group"
+	SceneC2C   = "c2c"
+)
+
+// 互动事件的类型
+const (
+	InteractionTypeButton = 11 // 消息按钮
+	InteractionTypeMenu   = 12 // 单聊快捷菜单
+)
+
+// 回应互动事件的结果码
+const (
+	InteractionOK           = 0 // 成功
+	InteractionFailed       = 1 // 操作失败
+	InteractionTooFrequent  = 2 // 操作频繁
+	InteractionDuplicated   = 3 // 重复操作
+	InteractionNoPermission = 4 // 没有权限
+	InteractionAdminOnly    = 5 // 仅管理员操作
+)
+
+//	{
+//	    "id": "e2c4a6d4-3a51-4a7e-9b86-1f2b0c1a4b11",
+//	    "type": 11,
+//	    "scene": "group",
+//	    "chat_type": 1,
+//	    "timestamp": "2024-09-04T13:12:43+08:00",
+//	    "group_openid": "0DE2782CA6DD4FB3B29730FC6F7C26AC",
+//	    "group_member_openid": "4381EC917E8332933D6A2B7893C28ACB",
+//	    "data": {
+//	        "type": 11,
+//	        "resolved": {
+//	            "button_data": "退出",
+//	            "button_id": "2"
+//	        }
+//	    },
+//	    "version": 1
+//	}
+type Interaction struct {
+	ID            string    `json:"id"`
+	ApplicationID string    `json:"application_id"`
+	Type          int       `json:"type"`
+	Scene         string    `json:"scene"`
+	ChatType      int       `json:"chat_type"` // 0 频道，1 群聊，2 单聊
+	Timestamp     Timestamp `json:"timestamp"`
+	Version       int       `json:"version"`
+
+	// 频道场景
+	GuildID   string `json:"guild_id"`
+	ChannelID string `json:"channel_id"`
+	// 群聊场景
+	GroupOpenID       string `json:"group_openid"`
+	GroupMemberOpenID string `json:"group_member_openid"`
+	// 单聊场景
+	UserOpenID string `json:"user_openid"`
+
+	Data struct {
+		Type     int `json:"type"`
+		Resolved struct {
+			ButtonData string `json:"button_data"`
+			ButtonID   string `json:"button_id"`
+			UserID     string `json:"user_id"` // 频道场景的用户ID
+			FeatureID  string `json:"feature_id"`
+			MessageID  string `json:"message_id"`
+		} `json:"resolved"`
+	} `json:"data"`
+}
+
+// ButtonData 点击按钮时携带的数据
+func (i *Interaction) ButtonData() string {
+	return i.Data.Resolved.ButtonData
+}
+
+// OperatorID 点击按钮的用户，按场景取对应的ID
+func (i *Interaction) OperatorID() string {
+	switch i.Scene {
+	case SceneGroup:
+		return i.GroupMemberOpenID
+	case SceneC2C:
+		return i.UserOpenID
+	default:
+		return i.Data.Resolved.UserID
+	}
+}
+
+// AckInteraction 回应互动事件，不回应的话客户端会显示按钮操作失败
+func (a *API) AckInteraction(interactionID string, code int) error {
+	method := http.MethodPut
+	api := fmt.Sprintf(InteractionAPI, interactionID)
+	var request = struct {
+		Code int `json:"code"`
+	}{code}
+	var result CreateMessageResposne
+	if err := a.doSimpleRequest(method, api, &request, &result); err != nil {
+		return err
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("code: %d, msg: %s", result.Code, result.Message)
+	}
+
+	return nil
+}
+
+// InteractionHandler 处理互动事件，返回回应的结果码
+type InteractionHandler func(*Interaction) int
+
+// HandleInteraction 将 InteractionHandler 包装为 EventHandler，处理完成后自动回应
+func (a *API) HandleInteraction(h InteractionHandler) EventHandler {
+	return func(wm WsMessage) {
+		var interaction Interaction
+		if err := json.Unmarshal(wm.Data, &interaction); err != nil {
+			a.Log().Warn("interaction_event", "event", wm.Type, "trace_id", wm.ID, "err", err)
+			return
+		}
+
+		code := h(&interaction)
+		if err := a.AckInteraction(interaction.ID, code); err != nil {
+			a.Log().Warn("interaction_ack", "interaction", interaction.ID, "trace_id", wm.ID, "err", err)
+		}
+	}
+}
+
+// InteractionRouter 按照按钮数据分发互动事件，完全匹配优先，其次按最长前缀匹配
+type InteractionRouter struct {
+	routes map[string]InteractionHandler
+	prefix map[string]InteractionHandler
+
+	// NotFound 没有匹配的路由时调用，为空时回应 InteractionFailed
+	NotFound InteractionHandler
+}
+
+func NewInteractionRouter() *InteractionRouter {
+	return &InteractionRouter{
+		routes: make(map[string]InteractionHandler),
+		prefix: make(map[string]InteractionHandler),
+	}
+}
+
+// Handle 注册按钮数据完全匹配的处理函数
+func (r *InteractionRouter) Handle(data string, h InteractionHandler) {
+	r.routes[data] = h
+}
+
+// HandlePrefix 注册按钮数据前缀匹配的处理函数
+func (r *InteractionRouter) HandlePrefix(prefix string, h InteractionHandler) {
+	r.prefix[prefix] = h
+}
+
+// Route 分发互动事件，可以直接传给 API.HandleInteraction
+func (r *InteractionRouter) Route(i *Interaction) int {
+	data := i.ButtonData()
+	if h, ok := r.routes[data]; ok {
+		return h(i)
+	}
+
+	var matched string
+	var handler InteractionHandler
+	for prefix, h := range r.prefix {
+		if strings.HasPrefix(data, prefix) && len(prefix) >= len(matched) {
+			matched, handler = prefix, h
+		}
+	}
+	if handler != nil {
+		return handler(i)
+	}
+
+	if r.NotFound != nil {
+		return r.NotFound(i)
+	}
+	return InteractionFailed
+}
@@ -0,0 +1,96 @@
+package sgroupbot_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"sgroupbot"
+	"sgroupbot/sgroupbottest"
+	"testing"
+	"time"
+)
+
+func TestInteractionRouter(t *testing.T) {
+	router := sgroupbot.NewInteractionRouter()
+	var got string
+	route := func(name string) sgroupbot.InteractionHandler {
+		return func(*sgroupbot.Interaction) int {
+			got = name
+			return sgroupbot.InteractionOK
+		}
+	}
+	router.Handle("退出", route("exact"))
+	router.HandlePrefix("退", route("short"))
+	router.HandlePrefix("接龙:", route("prefix"))
+	router.HandlePrefix("接龙:困难", route("longest"))
+
+	click := func(data string) *sgroupbot.Interaction {
+		var i sgroupbot.Interaction
+		i.Data.Resolved.ButtonData = data
+		return &i
+	}
+	for _, tt := range []struct {
+		data, want string
+	}{
+		{"退出", "exact"},
+		{"退出游戏", "short"},
+		{"接龙:简单", "prefix"},
+		{"接龙:困难", "longest"},
+	} {
+		got = ""
+		if code := router.Route(click(tt.data)); code != sgroupbot.InteractionOK || got != tt.want {
+			t.Errorf("route %s: %d %s, want %s", tt.data, code, got, tt.want)
+		}
+	}
+
+	// 没有匹配的路由
+	if code := router.Route(click("提示")); code != sgroupbot.InteractionFailed {
+		t.Errorf("unmatched: %d", code)
+	}
+	router.NotFound = func(*sgroupbot.Interaction) int { return sgroupbot.InteractionDuplicated }
+	if code := router.Route(click("提示")); code != sgroupbot.InteractionDuplicated {
+		t.Errorf("not found: %d", code)
+	}
+}
+
+func TestHandleInteraction(t *testing.T) {
+	srv := sgroupbottest.NewServer()
+	defer srv.Close()
+	api := srv.API()
+
+	var clicked *sgroupbot.Interaction
+	handler := api.HandleInteraction(func(i *sgroupbot.Interaction) int {
+		clicked = i
+		return sgroupbot.InteractionNoPermission
+	})
+	var wm sgroupbot.WsMessage
+	wm.Type = sgroupbot.EventInteractionCreate
+	wm.Data = json.RawMessage(`{"id":"e2c4a6d4","type":11,"scene":"guild","chat_type":0,"guild_id":"g1","channel_id":"c1",
+		"data":{"type":11,"resolved":{"button_data":"提示","button_id":"1","user_id":"u1","message_id":"m1"}},"version":1}`)
+	handler(wm)
+
+	if clicked == nil || clicked.ButtonData() != "提示" || clicked.OperatorID() != "u1" || clicked.ChannelID != "c1" {
+		t.Fatalf("interaction: %+v", clicked)
+	}
+	req, err := srv.NextRequest(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Code int `json:"code"`
+	}
+	json.Unmarshal(req.Body, &body)
+	if req.Method != http.MethodPut || req.Path != "/interactions/e2c4a6d4" || body.Code != sgroupbot.InteractionNoPermission {
+		t.Errorf("ack: %s %s %s", req.Method, req.Path, req.Body)
+	}
+
+	// 群与单聊按场景取点击的用户
+	for scene, data := range map[string]string{
+		sgroupbot.SceneGroup: `{"scene":"group","group_member_openid":"u2"}`,
+		sgroupbot.SceneC2C:   `{"scene":"c2c","user_openid":"u2"}`,
+	} {
+		var i sgroupbot.Interaction
+		if err := json.Unmarshal([]byte(data), &i); err != nil || i.OperatorID() != "u2" {
+			t.Errorf("%s operator: %q %v", scene, i.OperatorID(), err)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package sgroupbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+)
+
+// Logger 结构化日志，args 为交替的 key/value，与 *slog.Logger 的方法一致，可以直接传入 slog
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// LevelEnabler 可选的接口，Logger 实现之后，包装的 Logger 在级别未开启时跳过字段的拼接与密钥的替换
+type LevelEnabler interface {
+	Enabled(level Level) bool
+}
+
+// enabled 没有实现 LevelEnabler 时视为开启
+func enabled(l Logger, level Level) bool {
+	if le, ok := l.(LevelEnabler); ok {
+		return le.Enabled(level)
+	}
+	return true
+}
+
+// 日志级别，取值与 slog.Level 一致
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l <= LevelDebug:
+		return "DEBUG"
+	case l <= LevelInfo:
+		return "INFO"
+	case l <= LevelWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// ParseLevel 解析 debug/info/warn/error，不区分大小写
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// StdLogger 基于标准库 log 的默认实现，输出为 level msg key=value ...
+type StdLogger struct {
+	Level Level
+}
+
+func NewStdLogger(level Level) *StdLogger {
+	return &StdLogger{Level: level}
+}
+
+func (l *StdLogger) Enabled(level Level) bool {
+	return level >= l.Level
+}
+
+func (l *StdLogger) output(level Level, msg string, args []any) {
+	if !l.Enabled(level) {
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(level.String())
+	sb.WriteByte(' ')
+	sb.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		sb.WriteByte(' ')
+		if i+1 == len(args) {
+			// 缺少 key 的值，与 slog 一致使用 !BADKEY
+			fmt.Fprintf(&sb, "!BADKEY=%v", args[i])
+			break
+		}
+		fmt.Fprintf(&sb, "%v=%v", args[i], args[i+1])
+	}
+	log.Output(3, sb.String())
+}
+
+func (l *StdLogger) Debug(msg string, args ...any) { l.output(LevelDebug, msg, args) }
+func (l *StdLogger) Info(msg string, args ...any)  { l.output(LevelInfo, msg, args) }
+func (l *StdLogger) Warn(msg string, args ...any)  { l.output(LevelWarn, msg, args) }
+func (l *StdLogger) Error(msg string, args ...any) { l.output(LevelError, msg, args) }
+
+// DefaultLogger 没有指定 Logger 时使用
+var DefaultLogger Logger = NewStdLogger(LevelInfo)
+
+// fieldLogger 为每条日志附加固定的字段
+type fieldLogger struct {
+	l      Logger
+	fields []any
+}
+
+// WithFields 返回附加了固定字段的 Logger，比如 event、shard、target、trace_id
+func WithFields(l Logger, args ...any) Logger {
+	if fl, ok := l.(*fieldLogger); ok {
+		return &fieldLogger{l: fl.l, fields: append(append([]any{}, fl.fields...), args...)}
+	}
+	return &fieldLogger{l: l, fields: args}
+}
+
+func (l *fieldLogger) with(args []any) []any {
+	return append(append(make([]any, 0, len(l.fields)+len(args)), l.fields...), args...)
+}
+
+func (l *fieldLogger) Enabled(level Level) bool {
+	return enabled(l.l, level)
+}
+
+func (l *fieldLogger) Debug(msg string, args ...any) {
+	if l.Enabled(LevelDebug) {
+		l.l.Debug(msg, l.with(args)...)
+	}
+}
+func (l *fieldLogger) Info(msg string, args ...any) {
+	if l.Enabled(LevelInfo) {
+		l.l.Info(msg, l.with(args)...)
+	}
+}
+func (l *fieldLogger) Warn(msg string, args ...any) {
+	if l.Enabled(LevelWarn) {
+		l.l.Warn(msg, l.with(args)...)
+	}
+}
+func (l *fieldLogger) Error(msg string, args ...any) {
+	if l.Enabled(LevelError) {
+		l.l.Error(msg, l.with(args)...)
+	}
+}
+
+// 值需要隐藏的字段名，包含即匹配，不区分大小写
+var sensitiveKeys = []string{"token", "secret", "authorization", "password"}
+
+// redactLogger 隐藏日志中的密钥
+type redactLogger struct {
+	l        Logger
+	replacer *strings.Replacer
+}
+
+// NewRedactLogger 返回隐藏密钥的 Logger，敏感字段名的值以及所有出现在 secrets 中的字符串都会被替换为 [REDACTED]
+func NewRedactLogger(l Logger, secrets ...string) Logger {
+	var pairs []string
+	for _, s := range secrets {
+		if len(s) > 0 {
+			pairs = append(pairs, s, redacted)
+		}
+	}
+	rl := &redactLogger{l: l}
+	if len(pairs) > 0 {
+		rl.replacer = strings.NewReplacer(pairs...)
+	}
+	return rl
+}
+
+func (l *redactLogger) redact(msg string, args []any) (string, []any) {
+	msg = l.redactString(msg)
+	out := make([]any, len(args))
+	for i := 0; i < len(args); i++ {
+		if i%2 == 0 && i+1 < len(args) {
+			if key, ok := args[i].(string); ok && isSensitiveKey(key) {
+				out[i], out[i+1] = key, redacted
+				i++
+				continue
+			}
+		}
+		out[i] = l.redactValue(args[i])
+	}
+	return msg, out
+}
+
+func (l *redactLogger) redactValue(v any) any {
+	switch x := v.(type) {
+	case string:
+		return l.redactString(x)
+	case []byte:
+		return l.redactString(string(x))
+	case json.RawMessage:
+		return l.redactString(string(x))
+	case error:
+		return l.redactString(x.Error())
+	case fmt.Stringer:
+		return l.redactString(x.String())
+	}
+	return v
+}
+
+func (l *redactLogger) redactString(s string) string {
+	if l.replacer == nil {
+		return s
+	}
+	return l.replacer.Replace(s)
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range sensitiveKeys {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *redactLogger) Enabled(level Level) bool {
+	return enabled(l.l, level)
+}
+
+func (l *redactLogger) Debug(msg string, args ...any) {
+	if l.Enabled(LevelDebug) {
+		msg, args = l.redact(msg, args)
+		l.l.Debug(msg, args...)
+	}
+}
+func (l *redactLogger) Info(msg string, args ...any) {
+	if l.Enabled(LevelInfo) {
+		msg, args = l.redact(msg, args)
+		l.l.Info(msg, args...)
+	}
+}
+func (l *redactLogger) Warn(msg string, args ...any) {
+	if l.Enabled(LevelWarn) {
+		msg, args = l.redact(msg, args)
+		l.l.Warn(msg, args...)
+	}
+}
+func (l *redactLogger) Error(msg string, args ...any) {
+	if l.Enabled(LevelError) {
+		msg, args = l.redact(msg, args)
+		l.l.Error(msg, args...)
+	}
+}
+
+// apiLogger 为 API 创建的隐藏密钥的 Logger，以及创建时的 Logger 与凭证
+type apiLogger struct {
+	base   Logger
+	token  string
+	secret string
+	log    Logger
+}
+
+// Log 返回 API 使用的 Logger，会隐藏机器人的 token 与 secret；
+// 第一次调用时创建，之后只有 Logger 或凭证变化时才重新创建
+func (a *API) Log() Logger {
+	base := a.Logger
+	if base == nil {
+		base = DefaultLogger
+	}
+	if l := a.logger.Load(); l != nil && l.token == a.Ticket.Token && l.secret == a.Ticket.Secret && sameLogger(l.base, base) {
+		return l.log
+	}
+	l := &apiLogger{base: base, token: a.Ticket.Token, secret: a.Ticket.Secret}
+	l.log = NewRedactLogger(base, l.token, l.secret)
+	a.logger.Store(l)
+	return l.log
+}
+
+// sameLogger 是否为同一个 Logger，不可比较的类型视为不同
+func sameLogger(a, b Logger) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t != nil && t.Comparable() && a == b
+}
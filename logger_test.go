@@ -0,0 +1,127 @@
+package sgroupbot_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sgroupbot"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memLogger 记录日志到内存，用于检查输出
+type memLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *memLogger) log(level, msg string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprint(level, " ", msg, " ", args))
+}
+
+func (l *memLogger) Debug(msg string, args ...any) { l.log("DEBUG", msg, args) }
+func (l *memLogger) Info(msg string, args ...any)  { l.log("INFO", msg, args) }
+func (l *memLogger) Warn(msg string, args ...any)  { l.log("WARN", msg, args) }
+func (l *memLogger) Error(msg string, args ...any) { l.log("ERROR", msg, args) }
+
+func (l *memLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+func TestRedactLogger(t *testing.T) {
+	var mem memLogger
+	logger := sgroupbot.WithFields(sgroupbot.NewRedactLogger(&mem, "s3cret"), "shard", 0)
+	logger.Info("identify", "token", "Bot 1.abc", "body", []byte(`{"d":"s3cret"}`), "err", fmt.Errorf("bad s3cret"))
+
+	out := mem.String()
+	for _, leak := range []string{"Bot 1.abc", "s3cret"} {
+		if strings.Contains(out, leak) {
+			t.Errorf("leaked %q: %s", leak, out)
+		}
+	}
+	if !strings.Contains(out, "shard 0") {
+		t.Errorf("missing fields: %s", out)
+	}
+}
+
+func TestAPILogRedactsToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 错误返回中回显了请求头，日志中不能出现 token
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(w, `{"code":11241,"message":%q}`, r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	var mem memLogger
+	api := sgroupbot.API{
+		Target: srv.URL,
+		Ticket: sgroupbot.Ticket{AppID: 1, Token: "tok-123"},
+		Logger: &mem,
+	}
+	api.GetGuild("1")
+
+	out := mem.String()
+	if !strings.Contains(out, "WARN rest_response") {
+		t.Errorf("missing warn: %s", out)
+	}
+	if strings.Contains(out, "tok-123") {
+		t.Errorf("leaked token: %s", out)
+	}
+}
+
+// countStringer 记录被格式化的次数
+type countStringer struct{ n *int }
+
+func (s countStringer) String() string {
+	*s.n++
+	return "s3cret"
+}
+
+func TestRedactLoggerLevel(t *testing.T) {
+	var formatted int
+	var mem memLogger
+	std := sgroupbot.NewStdLogger(sgroupbot.LevelInfo)
+	logger := sgroupbot.WithFields(sgroupbot.NewRedactLogger(std, "s3cret"), "shard", 0)
+
+	// 级别未开启时不拼接字段，也不替换密钥
+	logger.Debug("ws_message", "data", countStringer{&formatted})
+	if formatted != 0 {
+		t.Errorf("debug disabled but formatted %d times", formatted)
+	}
+	if le, ok := logger.(sgroupbot.LevelEnabler); !ok || le.Enabled(sgroupbot.LevelDebug) || !le.Enabled(sgroupbot.LevelWarn) {
+		t.Errorf("enabled: %v", ok)
+	}
+
+	// 没有实现 LevelEnabler 的 Logger 视为全部开启
+	sgroupbot.NewRedactLogger(&mem, "s3cret").Debug("ws_message", "data", countStringer{&formatted})
+	if formatted != 1 || !strings.Contains(mem.String(), "[REDACTED]") {
+		t.Errorf("formatted %d: %s", formatted, mem.String())
+	}
+}
+
+func TestAPILogCached(t *testing.T) {
+	var mem memLogger
+	api := sgroupbot.API{Ticket: sgroupbot.Ticket{Token: "tok-1"}, Logger: &mem}
+	if api.Log() != api.Log() {
+		t.Error("logger should be built once")
+	}
+
+	// 凭证变化之后重新创建
+	api.Ticket.Token = "tok-2"
+	api.Log().Info("identify", "body", "tok-1 tok-2")
+	if out := mem.String(); strings.Contains(out, "tok-2") || !strings.Contains(out, "tok-1") {
+		t.Errorf("ticket changed: %s", out)
+	}
+
+	var other memLogger
+	api.Logger = &other
+	api.Log().Info("identify")
+	if len(other.String()) == 0 {
+		t.Error("logger changed")
+	}
+}
@@ -0,0 +1,327 @@
+package sgroupbot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 指标类型
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+// DefaultBuckets 耗时直方图默认的分桶，单位秒
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type series struct {
+	labels  []string
+	value   float64
+	buckets []uint64 // 直方图各个分桶的计数，不累加
+	count   uint64
+}
+
+// metric 一个指标，按照标签的取值区分不同的序列
+type metric struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+	fn      func() float64 // GaugeFunc 在输出时取值
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (m *metric) get(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: want %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		if m.typ == metricHistogram {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Counter 只增不减的计数
+type Counter struct{ m *metric }
+
+// Inc 计数加一，values 为各个标签的取值
+func (c *Counter) Inc(values ...string) { c.Add(1, values...) }
+
+func (c *Counter) Add(v float64, values ...string) {
+	c.m.mu.Lock()
+	c.m.get(values).value += v
+	c.m.mu.Unlock()
+}
+
+// Gauge 可增可减的数值
+type Gauge struct{ m *metric }
+
+func (g *Gauge) Set(v float64, values ...string) {
+	g.m.mu.Lock()
+	g.m.get(values).value = v
+	g.m.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64, values ...string) {
+	g.m.mu.Lock()
+	g.m.get(values).value += v
+	g.m.mu.Unlock()
+}
+
+// Histogram 分布统计，如请求耗时
+type Histogram struct{ m *metric }
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	s := h.m.get(values)
+	s.value += v
+	s.count++
+	if i := sort.SearchFloat64s(h.m.buckets, v); i < len(s.buckets) {
+		s.buckets[i]++
+	}
+}
+
+// ObserveSince 记录从 start 到现在的秒数
+func (h *Histogram) ObserveSince(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// Registry 指标的集合，实现了 http.Handler，以 Prometheus 文本格式输出
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+	names   map[string]*metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]*metric)}
+}
+
+// register 同名的指标只注册一次，重复注册时返回已有的指标
+func (r *Registry) register(m *metric) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.names[m.name]; ok {
+		if old.typ != m.typ {
+			panic(fmt.Sprintf("metric %s registered as %s", m.name, old.typ))
+		}
+		return old
+	}
+	m.series = make(map[string]*series)
+	r.names[m.name] = m
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(&metric{name: name, help: help, typ: metricCounter, labels: labels})}
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(&metric{name: name, help: help, typ: metricGauge, labels: labels})}
+}
+
+// GaugeFunc 输出时调用 f 取值，适合线程池容量、会话数量等已有的状态
+func (r *Registry) GaugeFunc(name, help string, f func() float64) {
+	r.register(&metric{name: name, help: help, typ: metricGauge, fn: f})
+}
+
+// Histogram buckets 为空时使用 DefaultBuckets
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.register(&metric{name: name, help: help, typ: metricHistogram, labels: labels, buckets: buckets})}
+}
+
+// WriteTo 以 Prometheus 文本格式输出全部指标
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]*metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	for _, m := range metrics {
+		m.write(cw)
+	}
+	if err := cw.w.Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+func (m *metric) write(w *countWriter) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+	if m.fn != nil {
+		fmt.Fprintf(w, "%s %s\n", m.name, formatFloat(m.fn()))
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := m.series[k]
+		if m.typ != metricHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.labels, "", ""), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, le := range m.buckets {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labels, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labels, "", ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labels, "", ""), s.count)
+	}
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && len(extraName) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(names[i])
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabel(values[i]))
+		sb.WriteByte('"')
+	}
+	if len(extraName) > 0 {
+		if len(names) > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(extraName)
+		sb.WriteString(`="`)
+		sb.WriteString(extraValue)
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+// countWriter 统计写入的字节数，记录第一个错误
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
+
+// Metrics 网关与接口调用的指标，通过 API.EnableMetrics 开启
+type Metrics struct {
+	GatewayReconnects *Counter   // 网关重连次数
+	HeartbeatLatency  *Histogram // 心跳发送到收到 ACK 的耗时
+	GatewayEvents     *Counter   // 按事件类型统计下发的事件
+	RESTLatency       *Histogram // 按接口与方法统计请求耗时
+	RESTErrors        *Counter   // 按接口与错误码统计失败的请求
+}
+
+// EnableMetrics 在 reg 中注册网关与接口调用的指标
+func (a *API) EnableMetrics(reg *Registry) *Metrics {
+	a.Metrics = &Metrics{
+		GatewayReconnects: reg.Counter("sgroupbot_gateway_reconnects_total", "Gateway reconnect attempts."),
+		HeartbeatLatency:  reg.Histogram("sgroupbot_gateway_heartbeat_latency_seconds", "Latency between heartbeat and heartbeat ack.", nil),
+		GatewayEvents:     reg.Counter("sgroupbot_gateway_events_total", "Gateway dispatch events by type.", "type"),
+		RESTLatency:       reg.Histogram("sgroupbot_rest_request_duration_seconds", "REST request latency by endpoint.", nil, "method", "endpoint"),
+		RESTErrors:        reg.Counter("sgroupbot_rest_errors_total", "Failed REST requests by endpoint and code.", "method", "endpoint", "code"),
+	}
+	return a.Metrics
+}
+
+// Endpoint 将接口路径中的ID替换为 {id}，避免指标的序列数随ID增长，
+// 除了 v2 这样的版本号，包含数字的路径段都视为ID，如 /channels/{id}/messages
+func Endpoint(api string) string {
+	if i := strings.IndexByte(api, '?'); i >= 0 {
+		api = api[:i]
+	}
+	if strings.HasPrefix(api, "http") {
+		// 完整的地址，去掉 scheme 与 host
+		if i := strings.Index(api, "://"); i >= 0 {
+			api = api[i+3:]
+			if j := strings.IndexByte(api, '/'); j >= 0 {
+				api = api[j:]
+			} else {
+				api = "/"
+			}
+		}
+	}
+	parts := strings.Split(api, "/")
+	for i, p := range parts {
+		if strings.IndexAny(p, "0123456789") >= 0 && !isVersion(p) {
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func isVersion(p string) bool {
+	if len(p) < 2 || p[0] != 'v' {
+		return false
+	}
+	_, err := strconv.Atoi(p[1:])
+	return err == nil
+}
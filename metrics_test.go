@@ -0,0 +1,126 @@
+package sgroupbot_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sgroupbot"
+	"sgroupbot/sgroupbottest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	reg := sgroupbot.NewRegistry()
+	c := reg.Counter("test_total", "Test counter.", "type")
+	c.Inc("a")
+	c.Add(2, `b"c`)
+	h := reg.Histogram("test_seconds", "Test histogram.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+	reg.GaugeFunc("test_gauge", "Test gauge.", func() float64 { return 3 })
+
+	var sb strings.Builder
+	if _, err := reg.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	out := sb.String()
+	for _, want := range []string{
+		"# TYPE test_total counter\n",
+		`test_total{type="a"} 1` + "\n",
+		`test_total{type="b\"c"} 2` + "\n",
+		`test_seconds_bucket{le="0.1"} 1` + "\n",
+		`test_seconds_bucket{le="1"} 2` + "\n",
+		`test_seconds_bucket{le="+Inf"} 3` + "\n",
+		"test_seconds_sum 5.55\n",
+		"test_seconds_count 3\n",
+		"test_gauge 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+// sampleLine 文本格式中一行样本：指标名，可选的标签，值
+var sampleLine = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*` +
+	`(\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*"(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*")*\})?` +
+	` (?:[-+0-9.eE]+|\+Inf|-Inf|NaN)$`)
+
+func TestRegistryExposition(t *testing.T) {
+	reg := sgroupbot.NewRegistry()
+	c := reg.Counter("test_total", "Help with \\ backslash\nand newline.", "path", "err")
+	c.Inc(`C:\idioms`, "line1\nline2")
+	c.Inc(`say "hi"`, `\"`)
+	h := reg.Histogram("test_seconds", "Test histogram.", []float64{1}, "endpoint")
+	h.Observe(0.5, "/channels/{id}/\"messages\"")
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type: %s", ct)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		"# HELP test_total Help with \\\\ backslash\\nand newline.\n",
+		`test_total{path="C:\\idioms",err="line1\nline2"} 1` + "\n",
+		`test_total{path="say \"hi\"",err="\\\""} 1` + "\n",
+		`test_seconds_bucket{endpoint="/channels/{id}/\"messages\"",le="1"} 1` + "\n",
+		`test_seconds_count{endpoint="/channels/{id}/\"messages\""} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+
+	// 每一行都是注释或者完整的样本，标签值中的换行与引号不会破坏格式
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		if !sampleLine.MatchString(line) {
+			t.Errorf("malformed line %q", line)
+		}
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	for api, want := range map[string]string{
+		"/channels/1419773/messages":            "/channels/{id}/messages",
+		"/v2/groups/9F2C3A7E1B/messages":        "/v2/groups/{id}/messages",
+		"/users/@me/guilds?limit=100":           "/users/@me/guilds",
+		"https://api.sgroup.qq.com/gateway":     "/gateway",
+		"/channels/1/messages/m1/reactions/1/2": "/channels/{id}/messages/{id}/reactions/{id}/{id}",
+	} {
+		if got := sgroupbot.Endpoint(api); got != want {
+			t.Errorf("Endpoint(%q) = %q, want %q", api, got, want)
+		}
+	}
+}
+
+func TestRESTMetrics(t *testing.T) {
+	srv := sgroupbottest.NewServer()
+	defer srv.Close()
+	srv.AddGuild(sgroupbot.Guild{ID: "100010"})
+
+	api := srv.API()
+	reg := sgroupbot.NewRegistry()
+	api.EnableMetrics(reg)
+
+	srv.FailNext(1, http.StatusInternalServerError, 50000)
+	api.GetGuild("100010")
+	api.GetGuild("100010")
+
+	var sb strings.Builder
+	reg.WriteTo(&sb)
+	out := sb.String()
+	for _, want := range []string{
+		`sgroupbot_rest_errors_total{method="GET",endpoint="/guilds/{id}",code="50000"} 1`,
+		`sgroupbot_rest_request_duration_seconds_count{method="GET",endpoint="/guilds/{id}"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package sgroupbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	ReactionAPI = "/channels/%s/messages/%s/reactions/%d/%s" // channel_id, message_id, emoji type, emoji id
+)
+
+// 表情类型
+const (
+	EmojiTypeSystem = 1 // 系统表情
+	EmojiTypeEmoji  = 2 // emoji 表情，id 为 unicode 码点的十进制
+)
+
+// 常用的 emoji 表情ID
+const (
+	EmojiCheckMark = "9989"   // ✅
+	EmojiCrossMark = "10060"  // ❌
+	EmojiThumbsUp  = "128077" // 👍
+)
+
+// 表态对象类型
+const (
+	ReactionTargetMessage = 0 // 消息
+	ReactionTargetThread  = 1 // 帖子
+	ReactionTargetPost    = 2 // 评论
+	ReactionTargetReply   = 3 // 回复
+)
+
+type ReactionEmoji struct {
+	ID   string `json:"id"`
+	Type int    `json:"type"`
+}
+
+//	{
+//	    "user_id": "1234",
+//	    "guild_id": "18700000000001",
+//	    "channel_id": "100010",
+//	    "target": {"id": "08e092eeb983afef9e0110f9fb2718dfb3b3a506480f5a", "type": 0},
+//	    "emoji": {"id": "9989", "type": 2}
+//	}
+//
+// MessageReaction MESSAGE_REACTION_ADD/MESSAGE_REACTION_REMOVE 的数据
+type MessageReaction struct {
+	UserID    string `json:"user_id"`
+	GuildID   string `json:"guild_id"`
+	ChannelID string `json:"channel_id"`
+	Target    struct {
+		ID   string `json:"id"`
+		Type int    `json:"type"`
+	} `json:"target"`
+	Emoji ReactionEmoji `json:"emoji"`
+}
+
+// ReactionHandler 处理表情表态事件，added 为 false 表示取消表态
+type ReactionHandler func(reaction *MessageReaction, added bool)
+
+// HandleReaction 将 ReactionHandler 包装为 EventHandler，需要设置 IntentGuildMessageReactions
+func HandleReaction(h ReactionHandler) EventHandler {
+	return func(wm WsMessage) {
+		var reaction MessageReaction
+		if err := json.Unmarshal(wm.Data, &reaction); err != nil {
+			DefaultLogger.Warn("reaction_event", "event", wm.Type, "err", err)
+			return
+		}
+		h(&reaction, wm.Type == EventMessageReactionAdd)
+	}
+}
+
+func reactionAPI(channelID, messageID string, emoji ReactionEmoji) string {
+	return fmt.Sprintf(ReactionAPI, channelID, messageID, emoji.Type, emoji.ID)
+}
+
+// PutReaction 对消息发表表情表态
+func (a *API) PutReaction(channelID, messageID string, emoji ReactionEmoji) error {
+	method := http.MethodPut
+	api := reactionAPI(channelID, messageID, emoji)
+	var result CreateMessageResposne
+	if err := a.doSimpleRequest(method, api, nil, &result); err != nil {
+		return err
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("code: %d, msg: %s", result.Code, result.Message)
+	}
+
+	return nil
+}
+
+// DeleteReaction 删除机器人发表的表情表态
+func (a *API) DeleteReaction(channelID, messageID string, emoji ReactionEmoji) error {
+	method := http.MethodDelete
+	api := reactionAPI(channelID, messageID, emoji)
+	var result CreateMessageResposne
+	if err := a.doSimpleRequest(method, api, nil, &result); err != nil {
+		return err
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("code: %d, msg: %s", result.Code, result.Message)
+	}
+
+	return nil
+}
+
+type ReactionUsersRequest struct {
+	Cookie string // 上一次请求返回的 cookie，第一次请求为空
+	Limit  int    // 每页数量，最大50，默认20
+}
+
+type ReactionUsersResponse struct {
+	Users []User `json:"users"`
+	// 下一页的 cookie
+	Cookie string `json:"cookie"`
+	// 是否已经是最后一页
+	IsEnd bool `json:"is_end"`
+
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// GetReactionUsers 分页拉取对消息发表了指定表情表态的用户
+func (a *API) GetReactionUsers(channelID, messageID string, emoji ReactionEmoji, request ReactionUsersRequest) (*ReactionUsersResponse, error) {
+	method := http.MethodGet
+	query := url.Values{}
+	if len(request.Cookie) > 0 {
+		query.Set("cookie", request.Cookie)
+	}
+	if request.Limit > 0 {
+		query.Set("limit", strconv.Itoa(request.Limit))
+	}
+	api := reactionAPI(channelID, messageID, emoji)
+	if len(query) > 0 {
+		api += "?" + query.Encode()
+	}
+
+	var result ReactionUsersResponse
+	if err := a.doSimpleRequest(method, api, nil, &result); err != nil {
+		return nil, err
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("code: %d, msg: %s", result.Code, result.Message)
+	}
+
+	return &result, nil
+}
+
+// RangeReactionUsers 遍历所有发表了指定表情表态的用户，f 返回 false 时停止
+func (a *API) RangeReactionUsers(channelID, messageID string, emoji ReactionEmoji, f func(User) bool) error {
+	var request = ReactionUsersRequest{Limit: 50}
+	for {
+		page, err := a.GetReactionUsers(channelID, messageID, emoji, request)
+		if err != nil {
+			return err
+		}
+		for i := range page.Users {
+			if !f(page.Users[i]) {
+				return nil
+			}
+		}
+		if page.IsEnd || len(page.Cookie) == 0 {
+			return nil
+		}
+		request.Cookie = page.Cookie
+	}
+}
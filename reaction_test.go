@@ -0,0 +1,113 @@
+package sgroupbot_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sgroupbot"
+	"testing"
+)
+
+func reactionEvent(eventType, data string) sgroupbot.WsMessage {
+	var wm sgroupbot.WsMessage
+	wm.Type = eventType
+	wm.Data = json.RawMessage(data)
+	return wm
+}
+
+func TestHandleReaction(t *testing.T) {
+	var got *sgroupbot.MessageReaction
+	var added bool
+	handler := sgroupbot.HandleReaction(func(r *sgroupbot.MessageReaction, add bool) {
+		got, added = r, add
+	})
+	data := `{"user_id":"1234","guild_id":"18700000000001","channel_id":"100010",
+		"target":{"id":"08e092eeb983afef9e0110f9fb2718dfb3b3a506480f5a","type":0},"emoji":{"id":"9989","type":2}}`
+
+	for _, tt := range []struct {
+		event string
+		added bool
+	}{
+		{sgroupbot.EventMessageReactionAdd, true},
+		{sgroupbot.EventMessageReactionRemove, false},
+	} {
+		got = nil
+		handler(reactionEvent(tt.event, data))
+		if got == nil || added != tt.added {
+			t.Fatalf("%s: %+v %v", tt.event, got, added)
+		}
+		if got.UserID != "1234" || got.ChannelID != "100010" || got.Target.Type != sgroupbot.ReactionTargetMessage ||
+			got.Target.ID != "08e092eeb983afef9e0110f9fb2718dfb3b3a506480f5a" ||
+			got.Emoji != (sgroupbot.ReactionEmoji{ID: sgroupbot.EmojiCheckMark, Type: sgroupbot.EmojiTypeEmoji}) {
+			t.Errorf("%s: %+v", tt.event, got)
+		}
+	}
+
+	// 解析失败时不调用
+	got = nil
+	handler(reactionEvent(sgroupbot.EventMessageReactionAdd, `[]`))
+	if got != nil {
+		t.Errorf("bad event: %+v", got)
+	}
+}
+
+func TestPutDeleteReaction(t *testing.T) {
+	var methods []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/channels/100010/messages/m1/reactions/2/128077", func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/channels/100011/messages/m1/reactions/2/128077", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"code":11264,"message":"no permission"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	api := sgroupbot.API{Target: srv.URL}
+
+	emoji := sgroupbot.ReactionEmoji{ID: sgroupbot.EmojiThumbsUp, Type: sgroupbot.EmojiTypeEmoji}
+	if err := api.PutReaction("100010", "m1", emoji); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.DeleteReaction("100010", "m1", emoji); err != nil {
+		t.Fatal(err)
+	}
+	if len(methods) != 2 || methods[0] != http.MethodPut || methods[1] != http.MethodDelete {
+		t.Errorf("methods: %v", methods)
+	}
+
+	if err := api.PutReaction("100011", "m1", emoji); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestGetReactionUsers(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/channels/100010/messages/m1/reactions/1/4", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("method: %s", r.Method)
+		}
+		if r.URL.Query().Get("cookie") == "" {
+			w.Write([]byte(`{"users":[{"id":"u1","username":"张三"}],"cookie":"next","is_end":false}`))
+			return
+		}
+		if r.URL.Query().Get("limit") != "50" {
+			t.Errorf("query: %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"users":[{"id":"u2","username":"李四"}],"is_end":true}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	api := sgroupbot.API{Target: srv.URL}
+	emoji := sgroupbot.ReactionEmoji{ID: "4", Type: sgroupbot.EmojiTypeSystem}
+	page, err := api.GetReactionUsers("100010", "m1", emoji, sgroupbot.ReactionUsersRequest{})
+	if err != nil || len(page.Users) != 1 || page.Users[0].ID != "u1" || page.IsEnd || page.Cookie != "next" {
+		t.Fatalf("first page: %+v %v", page, err)
+	}
+	page, err = api.GetReactionUsers("100010", "m1", emoji, sgroupbot.ReactionUsersRequest{Cookie: page.Cookie, Limit: 50})
+	if err != nil || len(page.Users) != 1 || page.Users[0].Username != "李四" || !page.IsEnd {
+		t.Errorf("last page: %+v %v", page, err)
+	}
+}
@@ -0,0 +1,157 @@
+package sgroupbot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// 录制帧的方向
+const (
+	FrameIn  = "in"  // 网关下发
+	FrameOut = "out" // 客户端发送
+)
+
+// Frame 录制的一条网关消息，按行写入 jsonl
+type Frame struct {
+	Time  time.Time       `json:"time"`
+	Dir   string          `json:"dir"`
+	Shard int             `json:"shard"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// Recorder 将网关的收发消息录制为 jsonl，通过 API.Recorder 开启
+type Recorder struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	c      io.Closer
+	closed bool
+}
+
+// NewRecorder 录制到 w，如果 w 实现了 io.Closer，Close 时会一起关闭
+func NewRecorder(w io.Writer) *Recorder {
+	r := &Recorder{w: bufio.NewWriter(w)}
+	if c, ok := w.(io.Closer); ok {
+		r.c = c
+	}
+	return r
+}
+
+// Record 录制一帧，每帧写完立即 flush，避免进程异常退出时丢失
+func (r *Recorder) Record(dir string, shard int, data []byte) error {
+	frame := Frame{
+		Time:  time.Now(),
+		Dir:   dir,
+		Shard: shard,
+		Data:  json.RawMessage(data),
+	}
+	b, err := json.Marshal(&frame)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return io.ErrClosedPipe
+	}
+	if _, err := r.w.Write(b); err != nil {
+		return err
+	}
+	if err := r.w.WriteByte('\n'); err != nil {
+		return err
+	}
+	return r.w.Flush()
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+	if r.c != nil {
+		return r.c.Close()
+	}
+	return nil
+}
+
+// redactFrame 去掉 identify 与 resume 中的 token
+func redactFrame(v interface{}) interface{} {
+	switch m := v.(type) {
+	case *IdentifyMessage:
+		c := *m
+		c.Data.Token = redacted
+		return &c
+	case *ResumeMessage:
+		c := *m
+		c.Data.Token = redacted
+		return &c
+	}
+	return v
+}
+
+const redacted = "[REDACTED]"
+
+// ReplayOptions 回放的参数
+type ReplayOptions struct {
+	// Speed 回放速度，1 为原速，2 为两倍速，小于等于0 时不等待，尽快回放
+	Speed float64
+	// Shard 只回放指定分片，小于0 时回放所有分片
+	Shard int
+}
+
+// Replay 将录制的网关消息重新分发给 Handlers，只回放下发的 dispatch 消息，
+// 处理函数中调用的接口仍然会发往 Target，回放时应当指向测试环境
+func (a *API) Replay(ctx context.Context, r io.Reader, opts ReplayOptions) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	var last time.Time
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var frame Frame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return fmt.Errorf("replay line %d: %w", line, err)
+		}
+		if frame.Dir != FrameIn || (opts.Shard >= 0 && frame.Shard != opts.Shard) {
+			continue
+		}
+		var msg WsMessage
+		if err := json.Unmarshal(frame.Data, &msg); err != nil {
+			return fmt.Errorf("replay line %d: %w", line, err)
+		}
+		if msg.Op != OpDispatch {
+			continue
+		}
+
+		// 按照录制时的间隔等待
+		if opts.Speed > 0 && !last.IsZero() {
+			wait := time.Duration(float64(frame.Time.Sub(last)) / opts.Speed)
+			if wait > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+		}
+		last = frame.Time
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		a.dispatch(msg)
+	}
+	return scanner.Err()
+}
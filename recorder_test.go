@@ -0,0 +1,78 @@
+package sgroupbot_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sgroupbot"
+	"sgroupbot/sgroupbottest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	srv := sgroupbottest.NewServer()
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	rec := sgroupbot.NewRecorder(&buf)
+
+	api := srv.API()
+	api.Intents = sgroupbot.IntentGroupAndC2CEvent
+	api.Recorder = rec
+	received := make(chan struct{}, 16)
+	api.Handlers[sgroupbot.EventC2CMessageCreate] = func(wm sgroupbot.WsMessage) {
+		received <- struct{}{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- api.StartWs(ctx)
+	}()
+	if err := srv.WaitReady(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		srv.Dispatch(sgroupbot.EventC2CMessageCreate, map[string]interface{}{"content": "成语接龙"})
+		<-received
+	}
+	cancel()
+	<-done
+	rec.Close()
+
+	if strings.Contains(buf.String(), sgroupbottest.Ticket.Token) {
+		t.Error("token should be redacted")
+	}
+	var dirs = map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var frame sgroupbot.Frame
+		if err := json.Unmarshal([]byte(line), &frame); err != nil {
+			t.Fatal(err)
+		}
+		dirs[frame.Dir]++
+	}
+	// hello + ready + 2 个事件，identify + 心跳
+	if dirs[sgroupbot.FrameIn] < 4 || dirs[sgroupbot.FrameOut] < 2 {
+		t.Errorf("frames: %v", dirs)
+	}
+
+	// 回放到新的 API
+	var events []string
+	replay := sgroupbot.API{Handlers: map[string]sgroupbot.EventHandler{
+		sgroupbot.EventC2CMessageCreate: func(wm sgroupbot.WsMessage) {
+			events = append(events, wm.ID)
+		},
+	}}
+	err := replay.Replay(context.Background(), &buf, sgroupbot.ReplayOptions{Speed: 100, Shard: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0] != "C2C_MESSAGE_CREATE:2" {
+		t.Errorf("replayed events: %v", events)
+	}
+	if replay.BotID != sgroupbottest.BotUser.ID {
+		t.Errorf("replayed bot id: %s", replay.BotID)
+	}
+}
@@ -0,0 +1,328 @@
+package sgroupbottest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sgroupbot"
+
+	"github.com/gorilla/websocket"
+)
+
+// 事件对应的 intent，没有订阅的事件不会下发
+var eventIntents = map[string]int{
+	sgroupbot.EventGuildCreate:           sgroupbot.IntentGuilds,
+	sgroupbot.EventGuildUpdate:           sgroupbot.IntentGuilds,
+	sgroupbot.EventGuildDelete:           sgroupbot.IntentGuilds,
+	sgroupbot.EventChannelCreate:         sgroupbot.IntentGuilds,
+	sgroupbot.EventChannelUpdate:         sgroupbot.IntentGuilds,
+	sgroupbot.EventChannelDelete:         sgroupbot.IntentGuilds,
+	sgroupbot.EventGuildMemberAdd:        sgroupbot.IntentGuildMembers,
+	sgroupbot.EventGuildMemberUpdate:     sgroupbot.IntentGuildMembers,
+	sgroupbot.EventGuildMemberRemove:     sgroupbot.IntentGuildMembers,
+	sgroupbot.EventMessageCreate:         sgroupbot.IntentGuildMessages,
+	sgroupbot.EventMessageDelete:         sgroupbot.IntentGuildMessages,
+	sgroupbot.EventMessageReactionAdd:    sgroupbot.IntentGuildMessageReactions,
+	sgroupbot.EventMessageReactionRemove: sgroupbot.IntentGuildMessageReactions,
+	sgroupbot.EventDirectMessageCreate:   sgroupbot.IntentDriectMessage,
+	sgroupbot.EventDirectMessageDelete:   sgroupbot.IntentDriectMessage,
+	sgroupbot.EventC2CMessageCreate:      sgroupbot.IntentGroupAndC2CEvent,
+	sgroupbot.EventGroupAtMessageCreate:  sgroupbot.IntentGroupAndC2CEvent,
+	sgroupbot.EventGroupAddRobot:         sgroupbot.IntentGroupAndC2CEvent,
+	sgroupbot.EventGroupDelRobot:         sgroupbot.IntentGroupAndC2CEvent,
+	sgroupbot.EventGroupMsgReject:        sgroupbot.IntentGroupAndC2CEvent,
+	sgroupbot.EventGroupMsgReceive:       sgroupbot.IntentGroupAndC2CEvent,
+	sgroupbot.EventFriendAdd:             sgroupbot.IntentGroupAndC2CEvent,
+	sgroupbot.EventFriendDel:             sgroupbot.IntentGroupAndC2CEvent,
+	sgroupbot.EventC2CMsgReject:          sgroupbot.IntentGroupAndC2CEvent,
+	sgroupbot.EventC2CMsgReceive:         sgroupbot.IntentGroupAndC2CEvent,
+	sgroupbot.EventInteractionCreate:     sgroupbot.IntentInteraction,
+	sgroupbot.EventMessageAuditPass:      sgroupbot.IntentMessageAudit,
+	sgroupbot.EventMessageAuditReject:    sgroupbot.IntentMessageAudit,
+	sgroupbot.EventAudioStart:            sgroupbot.IntentAudioAction,
+	sgroupbot.EventAudioFinish:           sgroupbot.IntentAudioAction,
+	sgroupbot.EventAudioOnMic:            sgroupbot.IntentAudioAction,
+	sgroupbot.EventAudioOffMic:           sgroupbot.IntentAudioAction,
+	sgroupbot.EventAtMessageCreate:       sgroupbot.IntentPublicGuildMessages,
+	sgroupbot.EventPublicMessageDelete:   sgroupbot.IntentPublicGuildMessages,
+}
+
+var ErrNoSession = errors.New("sgroupbottest: no gateway session")
+
+// gatewaySession 网关会话，连接断开后保留，用于 resume
+type gatewaySession struct {
+	id      string
+	intents int
+
+	mu   sync.Mutex // 同一时间只能有一个写入
+	conn *websocket.Conn
+}
+
+func (gs *gatewaySession) write(v interface{}) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.conn == nil {
+		return ErrNoSession
+	}
+	return gs.conn.WriteJSON(v)
+}
+
+var upgrader = websocket.Upgrader{}
+
+func (s *Server) serveGateway(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// 1. hello
+	var hello struct {
+		sgroupbot.MessageHeader
+		Data sgroupbot.HelloData `json:"d"`
+	}
+	hello.Op = sgroupbot.OpHello
+	hello.Data.HeartbeatInterval = int(s.HeartbeatInterval / time.Millisecond)
+	if err := conn.WriteJSON(&hello); err != nil {
+		return
+	}
+
+	// 2. identify or resume
+	var first sgroupbot.WsMessage
+	if err := conn.ReadJSON(&first); err != nil {
+		return
+	}
+	var gs *gatewaySession
+	switch first.Op {
+	case sgroupbot.OpIdentify:
+		gs = s.identify(conn, first.Data)
+	case sgroupbot.OpResume:
+		gs = s.resume(conn, first.Data)
+	}
+	if gs == nil {
+		conn.WriteJSON(&sgroupbot.WsMessage{MessageHeader: sgroupbot.MessageHeader{Op: sgroupbot.OpInvalid}})
+		return
+	}
+	defer func() {
+		gs.mu.Lock()
+		if gs.conn == conn {
+			gs.conn = nil
+		}
+		gs.mu.Unlock()
+	}()
+
+	select {
+	case s.readyCh <- struct{}{}:
+	default:
+	}
+
+	// 3. heartbeat
+	for {
+		var msg sgroupbot.WsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		if msg.Op == sgroupbot.OpHeartbeat {
+			var ack sgroupbot.WsMessage
+			ack.Op = sgroupbot.OpHeartbeatAck
+			if err := gs.write(&ack); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) identify(conn *websocket.Conn, data json.RawMessage) *gatewaySession {
+	var identify sgroupbot.IdentifyData
+	if err := json.Unmarshal(data, &identify); err != nil || identify.Token != sgroupbot.BotToken(Ticket) {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.identifies++
+	gs := &gatewaySession{
+		id:      fmt.Sprintf("session-%d", s.identifies),
+		intents: identify.Intents,
+		conn:    conn,
+	}
+	s.sessions[gs.id] = gs
+	s.seq++
+	seq := s.seq
+	s.mu.Unlock()
+
+	var ready sgroupbot.ReadyMessage
+	ready.Version = 1
+	ready.SessionID = gs.id
+	ready.User.ID = BotUser.ID
+	ready.User.Username = BotUser.Username
+	ready.User.Bot = true
+	ready.Shard = identify.Shard[:]
+	d, _ := json.Marshal(&ready)
+
+	var msg sgroupbot.WsMessage
+	msg.Op = sgroupbot.OpDispatch
+	msg.Seq = seq
+	msg.Type = sgroupbot.EventReady
+	msg.Data = d
+	if err := gs.write(&msg); err != nil {
+		return nil
+	}
+	return gs
+}
+
+func (s *Server) resume(conn *websocket.Conn, data json.RawMessage) *gatewaySession {
+	var resume sgroupbot.ResumeData
+	if err := json.Unmarshal(data, &resume); err != nil || resume.Token != sgroupbot.BotToken(Ticket) {
+		return nil
+	}
+
+	s.mu.Lock()
+	gs, ok := s.sessions[resume.SessionID]
+	if !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	s.resumes++
+	// 补发断线期间的事件，持有锁保证补发的事件在新事件之前
+	defer s.mu.Unlock()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.conn = conn
+	for _, e := range s.events {
+		if e.Seq > resume.Seq && subscribed(gs.intents, e.Type) {
+			if err := conn.WriteJSON(&e); err != nil {
+				return nil
+			}
+		}
+	}
+
+	s.seq++
+	var msg sgroupbot.WsMessage
+	msg.Op = sgroupbot.OpDispatch
+	msg.Seq = s.seq
+	msg.Type = sgroupbot.EventResumed
+	msg.Data = json.RawMessage(`""`)
+	if err := conn.WriteJSON(&msg); err != nil {
+		return nil
+	}
+	return gs
+}
+
+func subscribed(intents int, eventType string) bool {
+	intent, ok := eventIntents[eventType]
+	return !ok || intents&intent != 0
+}
+
+// Dispatch 向所有订阅了该事件的会话下发事件，data 会被编码为 json，
+// 断线中的会话会在 resume 时补发
+func (s *Server) Dispatch(eventType string, data interface{}) error {
+	d, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if len(s.sessions) == 0 {
+		s.mu.Unlock()
+		return ErrNoSession
+	}
+	s.seq++
+	var msg sgroupbot.WsMessage
+	msg.Op = sgroupbot.OpDispatch
+	msg.Seq = s.seq
+	msg.Type = eventType
+	msg.ID = fmt.Sprintf("%s:%d", eventType, s.seq)
+	msg.Data = d
+	s.events = append(s.events, msg)
+	var sessions []*gatewaySession
+	for _, gs := range s.sessions {
+		if subscribed(gs.intents, eventType) {
+			sessions = append(sessions, gs)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, gs := range sessions {
+		// 写入失败说明连接已经断开，事件会在 resume 时补发
+		gs.write(&msg)
+	}
+	return nil
+}
+
+// WaitReady 等待下一次 identify 或 resume 成功
+func (s *Server) WaitReady(timeout time.Duration) error {
+	select {
+	case <-s.readyCh:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("sgroupbottest: gateway not ready")
+	}
+}
+
+// Disconnect 直接断开所有网关连接，会话保留，客户端可以 resume
+func (s *Server) Disconnect() {
+	for _, gs := range s.gatewaySessions() {
+		gs.mu.Lock()
+		if gs.conn != nil {
+			gs.conn.Close()
+			gs.conn = nil
+		}
+		gs.mu.Unlock()
+	}
+}
+
+// Reconnect 下发 op 7，要求客户端重连
+func (s *Server) Reconnect() {
+	var msg sgroupbot.WsMessage
+	msg.Op = sgroupbot.OpReconnect
+	for _, gs := range s.gatewaySessions() {
+		gs.write(&msg)
+	}
+}
+
+// InvalidateSessions 删除所有会话并断开连接，客户端只能重新 identify
+func (s *Server) InvalidateSessions() {
+	s.Disconnect()
+	s.mu.Lock()
+	s.sessions = make(map[string]*gatewaySession)
+	s.mu.Unlock()
+}
+
+// Identifies 返回 identify 成功的次数
+func (s *Server) Identifies() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.identifies
+}
+
+// Resumes 返回 resume 成功的次数
+func (s *Server) Resumes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resumes
+}
+
+// Intents 返回最近一次 identify 订阅的 intents
+func (s *Server) Intents() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gs, ok := s.sessions[fmt.Sprintf("session-%d", s.identifies)]
+	if !ok {
+		return 0
+	}
+	return gs.intents
+}
+
+func (s *Server) gatewaySessions() []*gatewaySession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sessions []*gatewaySession
+	for _, gs := range s.sessions {
+		sessions = append(sessions, gs)
+	}
+	return sessions
+}
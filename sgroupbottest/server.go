@@ -0,0 +1,311 @@
+// Package sgroupbottest 提供进程内的假 QQ 机器人开放平台，用于离线测试。
+//
+// Server 实现了 /gateway、websocket 网关（Hello/Identify/Ready/心跳/Resume），
+// 以及消息、子频道相关的接口，测试可以通过 Dispatch 注入事件，通过 Requests
+// 与 NextRequest 检查机器人发出的请求，并通过 FailNext、HoldForAudit、Disconnect
+// 模拟平台的各种异常。
+package sgroupbottest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"sgroupbot"
+)
+
+// Ticket 假平台接受的机器人凭证
+var Ticket = sgroupbot.Ticket{
+	AppID: 102000000,
+	Token: "sgroupbottest",
+}
+
+// BotUser READY 事件中下发的机器人信息
+var BotUser = sgroupbot.User{
+	ID:       "6158788878435714165",
+	Username: "成语接龙测试机器人",
+	Bot:      true,
+}
+
+// Request 机器人发往平台的接口请求
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   json.RawMessage
+}
+
+// Message 将请求内容解析为发送消息的请求
+func (r *Request) Message() (*sgroupbot.CreateMessageRequest, error) {
+	var msg sgroupbot.CreateMessageRequest
+	if err := json.Unmarshal(r.Body, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// 接口失败的脚本
+type failure struct {
+	status  int
+	code    int
+	auditID string // 不为空时，进入审核
+}
+
+// Server 进程内的假平台
+type Server struct {
+	*httptest.Server
+
+	// HeartbeatInterval 在 Hello 中下发的心跳周期
+	HeartbeatInterval time.Duration
+
+	mu       sync.Mutex
+	guilds   []sgroupbot.Guild
+	channels map[string][]sgroupbot.Channel // guild_id -> channels
+
+	requests []Request
+	sent     chan Request
+	failures []failure
+
+	nextID   int
+	auditSeq int
+
+	// 网关状态
+	sessions   map[string]*gatewaySession // session_id -> session
+	events     []sgroupbot.WsMessage      // 已下发的事件，用于 resume 补发
+	seq        uint32
+	identifies int
+	resumes    int
+	readyCh    chan struct{}
+}
+
+// NewServer 启动假平台，测试结束时需要调用 Close
+func NewServer() *Server {
+	s := &Server{
+		HeartbeatInterval: time.Second,
+		channels:          make(map[string][]sgroupbot.Channel),
+		sent:              make(chan Request, 1024),
+		sessions:          make(map[string]*gatewaySession),
+		readyCh:           make(chan struct{}, 16),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// API 返回连接到假平台的 API
+func (s *Server) API() *sgroupbot.API {
+	return &sgroupbot.API{
+		Target:   s.URL,
+		Ticket:   Ticket,
+		Handlers: make(map[string]sgroupbot.EventHandler),
+	}
+}
+
+// AddGuild 添加机器人加入的频道及其子频道
+func (s *Server) AddGuild(guild sgroupbot.Guild, channels ...sgroupbot.Channel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.guilds = append(s.guilds, guild)
+	for i := range channels {
+		channels[i].GuildID = guild.ID
+	}
+	s.channels[guild.ID] = append(s.channels[guild.ID], channels...)
+}
+
+// Requests 返回到目前为止收到的全部接口请求，不包括 /gateway
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// NextRequest 等待下一个接口请求，不包括 /gateway
+func (s *Server) NextRequest(timeout time.Duration) (Request, error) {
+	select {
+	case r := <-s.sent:
+		return r, nil
+	case <-time.After(timeout):
+		return Request{}, errors.New("sgroupbottest: no request received")
+	}
+}
+
+// FailNext 接下来的 n 个接口请求返回 status，错误码为 code，code 为 0 时没有返回内容，如网关返回的 502
+func (s *Server) FailNext(n int, status, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{status: status, code: code})
+	}
+}
+
+// HoldForAudit 下一条发送的消息进入审核，返回审核ID，之后通过 PassAudit 或 RejectAudit 下发审核结果
+func (s *Server) HoldForAudit() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auditSeq++
+	auditID := fmt.Sprintf("audit-%d", s.auditSeq)
+	s.failures = append(s.failures, failure{auditID: auditID})
+	return auditID
+}
+
+// PassAudit 下发 MESSAGE_AUDIT_PASS 事件
+func (s *Server) PassAudit(auditID, channelID, messageID string) error {
+	return s.Dispatch(sgroupbot.EventMessageAuditPass, sgroupbot.MessageAudited{
+		AuditID:   auditID,
+		ChannelID: channelID,
+		MessageID: messageID,
+	})
+}
+
+// RejectAudit 下发 MESSAGE_AUDIT_REJECT 事件
+func (s *Server) RejectAudit(auditID, channelID string) error {
+	return s.Dispatch(sgroupbot.EventMessageAuditReject, sgroupbot.MessageAudited{
+		AuditID:   auditID,
+		ChannelID: channelID,
+	})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/websocket" {
+		s.serveGateway(w, r)
+		return
+	}
+
+	if r.Header.Get("Authorization") != sgroupbot.BotToken(Ticket) {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"code": 11241, "message": "wrong token"})
+		return
+	}
+
+	if r.URL.Path == sgroupbot.GatewayAPI {
+		url := "ws" + strings.TrimPrefix(s.URL, "http") + "/websocket"
+		writeJSON(w, http.StatusOK, map[string]interface{}{"url": url})
+		return
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, _ = sgroupbot.ReadAll(nil, r.Body)
+	}
+	req := Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Header: r.Header.Clone(),
+		Body:   json.RawMessage(body),
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	var fail *failure
+	if len(s.failures) > 0 {
+		fail = &s.failures[0]
+		s.failures = s.failures[1:]
+	}
+	s.nextID++
+	id := s.nextID
+	s.mu.Unlock()
+	select {
+	case s.sent <- req:
+	default: // 缓冲已满，丢弃
+	}
+
+	var auditID string
+	if fail != nil {
+		if len(fail.auditID) == 0 && fail.code == 0 {
+			w.WriteHeader(fail.status)
+			return
+		}
+		if len(fail.auditID) == 0 {
+			writeJSON(w, fail.status, map[string]interface{}{"code": fail.code, "message": "scripted failure"})
+			return
+		}
+		auditID = fail.auditID
+	}
+
+	s.route(w, &req, id, auditID)
+}
+
+func (s *Server) route(w http.ResponseWriter, req *Request, id int, auditID string) {
+	parts := strings.Split(strings.Trim(req.Path, "/"), "/")
+	match := func(method string, pattern ...string) bool {
+		if req.Method != method || len(parts) != len(pattern) {
+			return false
+		}
+		for i := range pattern {
+			if pattern[i] != "*" && pattern[i] != parts[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case match(http.MethodPost, "channels", "*", "messages"),
+		match(http.MethodPost, "dms", "*", "messages"),
+		match(http.MethodPost, "v2", "groups", "*", "messages"),
+		match(http.MethodPost, "v2", "users", "*", "messages"):
+		if len(auditID) > 0 {
+			data, _ := json.Marshal(map[string]interface{}{
+				"message_audit": map[string]string{"audit_id": auditID},
+			})
+			writeJSON(w, http.StatusAccepted, map[string]interface{}{
+				"code":    304023,
+				"message": "push message is waiting for audit now",
+				"data":    json.RawMessage(data),
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":        fmt.Sprintf("msg-%d", id),
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+	case match(http.MethodGet, "users", "@me", "guilds"):
+		s.mu.Lock()
+		guilds := append([]sgroupbot.Guild{}, s.guilds...)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, guilds)
+	case match(http.MethodGet, "guilds", "*"):
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, g := range s.guilds {
+			if g.ID == parts[1] {
+				writeJSON(w, http.StatusOK, g)
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"code": 50001, "message": "guild not found"})
+	case match(http.MethodGet, "guilds", "*", "channels"):
+		s.mu.Lock()
+		channels := append([]sgroupbot.Channel{}, s.channels[parts[1]]...)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, channels)
+	case match(http.MethodPost, "guilds", "*", "channels"):
+		var channel sgroupbot.Channel
+		if err := json.Unmarshal(req.Body, &channel.ChannelInfo); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"code": 40001, "message": err.Error()})
+			return
+		}
+		channel.ID = fmt.Sprintf("channel-%d", id)
+		channel.GuildID = parts[1]
+		s.mu.Lock()
+		s.channels[channel.GuildID] = append(s.channels[channel.GuildID], channel)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, channel)
+	case req.Method == http.MethodPut || req.Method == http.MethodDelete:
+		// 表态、互动回应、上下麦等没有返回内容的接口
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"code": 11404, "message": "not found"})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,230 @@
+package sgroupbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type cachedGuild struct {
+	guild    Guild
+	expireAt time.Time
+}
+
+type cachedChannels struct {
+	channels map[string]Channel // channel_id -> channel
+	expireAt time.Time
+}
+
+type cachedMember struct {
+	member   GuildMember
+	expireAt time.Time
+}
+
+// StateCache 频道、子频道与成员的内存缓存，由网关事件更新，缓存缺失或过期时通过接口拉取
+type StateCache struct {
+	api *API
+	ttl time.Duration
+
+	mu       sync.RWMutex
+	guilds   map[string]*cachedGuild
+	channels map[string]*cachedChannels          // guild_id -> channels
+	members  map[string]map[string]*cachedMember // guild_id -> user_id -> member
+}
+
+// EnableState 开启状态缓存，并订阅频道与成员相关的事件
+func (a *API) EnableState(ttl time.Duration) *StateCache {
+	a.State = NewStateCache(a, ttl)
+	a.Intents |= IntentGuilds | IntentGuildMembers
+	return a.State
+}
+
+func NewStateCache(api *API, ttl time.Duration) *StateCache {
+	return &StateCache{
+		api:      api,
+		ttl:      ttl,
+		guilds:   make(map[string]*cachedGuild),
+		channels: make(map[string]*cachedChannels),
+		members:  make(map[string]map[string]*cachedMember),
+	}
+}
+
+// Load 拉取机器人加入的频道列表，在 READY 之后调用
+func (c *StateCache) Load() {
+	var request = GuildListRequest{Limit: 100}
+	for {
+		list, err := c.api.GetGuildList(request)
+		if err != nil {
+			c.api.Log().Warn("state_load", "err", err)
+			return
+		}
+		for i := range list {
+			c.putGuild(list[i])
+		}
+		if len(list) < request.Limit {
+			return
+		}
+		request.After = list[len(list)-1].ID
+	}
+}
+
+// HandleEvent 根据频道、子频道与成员事件更新缓存
+func (c *StateCache) HandleEvent(wm WsMessage) {
+	switch wm.Type {
+	case EventGuildCreate, EventGuildUpdate, EventGuildDelete:
+		var guild Guild
+		if err := json.Unmarshal(wm.Data, &guild); err != nil {
+			c.api.Log().Warn("state_event", "event", wm.Type, "err", err)
+			return
+		}
+		if wm.Type == EventGuildDelete {
+			c.deleteGuild(guild.ID)
+		} else {
+			c.putGuild(guild)
+		}
+	case EventChannelCreate, EventChannelUpdate, EventChannelDelete:
+		var channel Channel
+		if err := json.Unmarshal(wm.Data, &channel); err != nil {
+			c.api.Log().Warn("state_event", "event", wm.Type, "err", err)
+			return
+		}
+		c.updateChannel(channel, wm.Type == EventChannelDelete)
+	case EventGuildMemberAdd, EventGuildMemberUpdate, EventGuildMemberRemove:
+		var member GuildMember
+		if err := json.Unmarshal(wm.Data, &member); err != nil {
+			c.api.Log().Warn("state_event", "event", wm.Type, "err", err)
+			return
+		}
+		if wm.Type == EventGuildMemberRemove {
+			c.deleteMember(member.GuildID, member.User.ID)
+		} else {
+			c.putMember(member)
+		}
+	}
+}
+
+// Guild 获取频道信息
+func (c *StateCache) Guild(guildID string) (*Guild, error) {
+	now := time.Now()
+	c.mu.RLock()
+	cached, ok := c.guilds[guildID]
+	c.mu.RUnlock()
+	if ok && now.Before(cached.expireAt) {
+		guild := cached.guild
+		return &guild, nil
+	}
+
+	guild, err := c.api.GetGuild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	// 出错时接口可能仍返回 200 和错误码，没有 ID 的结果不能缓存
+	if len(guild.ID) == 0 {
+		return nil, fmt.Errorf("guild %s: empty response", guildID)
+	}
+	c.putGuild(*guild)
+	return guild, nil
+}
+
+// Channels 获取频道下的子频道列表
+func (c *StateCache) Channels(guildID string) ([]Channel, error) {
+	now := time.Now()
+	c.mu.RLock()
+	cached, ok := c.channels[guildID]
+	var list []Channel
+	if ok && now.Before(cached.expireAt) {
+		list = make([]Channel, 0, len(cached.channels))
+		for _, ch := range cached.channels {
+			list = append(list, ch)
+		}
+	}
+	c.mu.RUnlock()
+	if list != nil {
+		return list, nil
+	}
+
+	list, err := c.api.GetChannels(guildID)
+	if err != nil {
+		return nil, err
+	}
+	channels := make(map[string]Channel, len(list))
+	for i := range list {
+		if len(list[i].ID) == 0 {
+			return nil, fmt.Errorf("channels %s: empty channel in response", guildID)
+		}
+		channels[list[i].ID] = list[i]
+	}
+	c.mu.Lock()
+	c.channels[guildID] = &cachedChannels{channels: channels, expireAt: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return list, nil
+}
+
+// Member 获取频道成员信息
+func (c *StateCache) Member(guildID, userID string) (*GuildMember, error) {
+	now := time.Now()
+	c.mu.RLock()
+	cached, ok := c.members[guildID][userID]
+	c.mu.RUnlock()
+	if ok && now.Before(cached.expireAt) {
+		member := cached.member
+		return &member, nil
+	}
+
+	member, err := c.api.GetGuildMember(guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(member.User.ID) == 0 {
+		return nil, fmt.Errorf("member %s/%s: empty response", guildID, userID)
+	}
+	c.putMember(*member)
+	return member, nil
+}
+
+func (c *StateCache) putGuild(guild Guild) {
+	c.mu.Lock()
+	c.guilds[guild.ID] = &cachedGuild{guild: guild, expireAt: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+}
+
+func (c *StateCache) deleteGuild(guildID string) {
+	c.mu.Lock()
+	delete(c.guilds, guildID)
+	delete(c.channels, guildID)
+	delete(c.members, guildID)
+	c.mu.Unlock()
+}
+
+// updateChannel 只更新已经缓存了完整列表的频道，避免列表不完整
+func (c *StateCache) updateChannel(channel Channel, deleted bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.channels[channel.GuildID]
+	if !ok {
+		return
+	}
+	if deleted {
+		delete(cached.channels, channel.ID)
+	} else {
+		cached.channels[channel.ID] = channel
+	}
+}
+
+func (c *StateCache) putMember(member GuildMember) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	members, ok := c.members[member.GuildID]
+	if !ok {
+		members = make(map[string]*cachedMember)
+		c.members[member.GuildID] = members
+	}
+	members[member.User.ID] = &cachedMember{member: member, expireAt: time.Now().Add(c.ttl)}
+}
+
+func (c *StateCache) deleteMember(guildID, userID string) {
+	c.mu.Lock()
+	delete(c.members[guildID], userID)
+	c.mu.Unlock()
+}
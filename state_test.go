@@ -0,0 +1,134 @@
+package sgroupbot_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sgroupbot"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStateCache(t *testing.T) {
+	var requests int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/guilds/g1", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"id":"g1","name":"成语接龙"}`))
+	})
+	mux.HandleFunc("/guilds/g1/channels", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`[{"id":"c1","guild_id":"g1","name":"大厅"}]`))
+	})
+	mux.HandleFunc("/guilds/g1/members/u1", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"user":{"id":"u1","username":"张三"},"nick":"小张","roles":["1"]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	api := sgroupbot.API{Target: srv.URL}
+	state := api.EnableState(time.Minute)
+
+	guild, err := state.Guild("g1")
+	if err != nil || guild.Name != "成语接龙" {
+		t.Fatalf("guild: %+v %v", guild, err)
+	}
+	if _, err := state.Guild("g1"); err != nil || atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("guild should be cached, requests %d", requests)
+	}
+
+	event := func(eventType, data string) {
+		var wm sgroupbot.WsMessage
+		wm.Type = eventType
+		wm.Data = json.RawMessage(data)
+		state.HandleEvent(wm)
+	}
+
+	if list, err := state.Channels("g1"); err != nil || len(list) != 1 {
+		t.Fatalf("channels: %+v %v", list, err)
+	}
+	event(sgroupbot.EventChannelCreate, `{"id":"c2","guild_id":"g1","name":"接龙","op_user_id":"u1"}`)
+	event(sgroupbot.EventChannelDelete, `{"id":"c1","guild_id":"g1","op_user_id":"u1"}`)
+	if list, _ := state.Channels("g1"); len(list) != 1 || list[0].ID != "c2" {
+		t.Errorf("channels after events: %+v", list)
+	}
+
+	member, err := state.Member("g1", "u1")
+	if err != nil || member.Nick != "小张" || member.GuildID != "g1" {
+		t.Fatalf("member: %+v %v", member, err)
+	}
+	event(sgroupbot.EventGuildMemberUpdate, `{"guild_id":"g1","user":{"id":"u1"},"nick":"大张","op_user_id":"u1"}`)
+	if member, _ := state.Member("g1", "u1"); member.Nick != "大张" {
+		t.Errorf("member after update: %+v", member)
+	}
+
+	event(sgroupbot.EventGuildUpdate, `{"id":"g1","name":"成语接龙2"}`)
+	if guild, _ := state.Guild("g1"); guild.Name != "成语接龙2" {
+		t.Errorf("guild after update: %+v", guild)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("requests: %d", n)
+	}
+}
+
+func TestStateCacheFallbackFailed(t *testing.T) {
+	var requests, fail int32 = 0, 1
+	mux := http.NewServeMux()
+	mux.HandleFunc("/guilds/g1", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			// 出错时也可能返回 200 和错误码
+			w.Write([]byte(`{"code":50001,"message":"system error"}`))
+			return
+		}
+		w.Write([]byte(`{"id":"g1","name":"成语接龙"}`))
+	})
+	mux.HandleFunc("/guilds/g1/channels", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`[{"id":"c1","guild_id":"g1","name":"大厅"}]`))
+	})
+	mux.HandleFunc("/guilds/g1/members/u1", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			w.Write([]byte(`{"code":50001,"message":"system error"}`))
+			return
+		}
+		w.Write([]byte(`{"user":{"id":"u1","username":"张三"},"nick":"小张"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	api := sgroupbot.API{Target: srv.URL}
+	state := api.EnableState(time.Minute)
+
+	if guild, err := state.Guild("g1"); err == nil {
+		t.Errorf("guild should fail: %+v", guild)
+	}
+	if list, err := state.Channels("g1"); err == nil {
+		t.Errorf("channels should fail: %+v", list)
+	}
+	if member, err := state.Member("g1", "u1"); err == nil {
+		t.Errorf("member should fail: %+v", member)
+	}
+
+	// 失败的结果不会被缓存，恢复后重新请求
+	atomic.StoreInt32(&fail, 0)
+	if guild, err := state.Guild("g1"); err != nil || guild.Name != "成语接龙" {
+		t.Errorf("guild: %+v %v", guild, err)
+	}
+	if list, err := state.Channels("g1"); err != nil || len(list) != 1 {
+		t.Errorf("channels: %+v %v", list, err)
+	}
+	if member, err := state.Member("g1", "u1"); err != nil || member.Nick != "小张" {
+		t.Errorf("member: %+v %v", member, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 6 {
+		t.Errorf("requests: %d", n)
+	}
+}
@@ -3,7 +3,12 @@ package sgroupbot
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -59,6 +64,15 @@ const (
 	EventInteractionCreate     string = "INTERACTION_CREATE"
 	EventGroupAtMessageCreate         = "GROUP_AT_MESSAGE_CREATE"
 	EventC2CMessageCreate             = "C2C_MESSAGE_CREATE"
+	EventGroupAddRobot                = "GROUP_ADD_ROBOT"
+	EventGroupDelRobot                = "GROUP_DEL_ROBOT"
+	EventGroupMsgReject               = "GROUP_MSG_REJECT"
+	EventGroupMsgReceive              = "GROUP_MSG_RECEIVE"
+	EventFriendAdd                    = "FRIEND_ADD"
+	EventFriendDel                    = "FRIEND_DEL"
+	EventC2CMsgReject                 = "C2C_MSG_REJECT"
+	EventC2CMsgReceive                = "C2C_MSG_RECEIVE"
+	EventResumed                      = "RESUMED"
 )
 
 //	{
@@ -73,23 +87,144 @@ const (
 //	    "timestamp": "12024-09-04T13:12:43+08:00"
 //	}
 type Message struct {
-	Author struct {
-		ID           string `json:"id"`
-		MemberOpenID string `json:"member_openid"`
-		UserOpenID   string `json:"user_openid"`
-		Bot          bool   `json:"bot"`
-		Username     string `json:"username"`
-	} `json:"author"`
+	Author    User   `json:"author"`
 	ID        string `json:"id"`
 	Timestamp string `json:"timestamp"`
 	Content   string `json:"content"`
 
 	// 频道字段
-	ChannelID string `json:"channel_id"`
-	GuildID   string `json:"guild_id"`
+	ChannelID        string      `json:"channel_id"`
+	GuildID          string      `json:"guild_id"`
+	Member           *Member     `json:"member,omitempty"`
+	SeqInChannel     string      `json:"seq_in_channel,omitempty"`
+	Mentions         []User      `json:"mentions,omitempty"`
+	EditedAt         Timestamp   `json:"edited_timestamp,omitempty"`
+	SrcGuildID       string      `json:"src_guild_id,omitempty"` // 私信消息的来源频道
+	MessageReference *MessageRef `json:"message_reference,omitempty"`
 	// 群聊字段
 	GroupID     string `json:"group_id"`
 	GroupOpenID string `json:"group_openid"`
+
+	Attachments []MessageAttachment `json:"attachments,omitempty"`
+}
+
+// DisplayName 展示用的名字，优先使用频道昵称
+func (m *Message) DisplayName() string {
+	if m.Member != nil && len(m.Member.Nick) > 0 {
+		return m.Member.Nick
+	}
+	return m.Author.Username
+}
+
+// Time 解析消息的发送时间
+func (m *Message) Time() (time.Time, error) {
+	return ParseTimestamp(m.Timestamp)
+}
+
+// Images 返回消息中的图片附件
+func (m *Message) Images() []MessageAttachment {
+	var images []MessageAttachment
+	for i := range m.Attachments {
+		if m.Attachments[i].IsImage() {
+			images = append(images, m.Attachments[i])
+		}
+	}
+	return images
+}
+
+// User 消息的发送者或被@的用户，频道使用 id/username，群聊使用 member_openid，单聊使用 user_openid
+type User struct {
+	ID           string `json:"id"`
+	MemberOpenID string `json:"member_openid,omitempty"`
+	UserOpenID   string `json:"user_openid,omitempty"`
+	Bot          bool   `json:"bot"`
+	Username     string `json:"username,omitempty"`
+	Avatar       string `json:"avatar,omitempty"`
+}
+
+// Member 频道成员信息，仅频道消息有
+type Member struct {
+	Nick     string    `json:"nick"`
+	Roles    []string  `json:"roles"`
+	JoinedAt Timestamp `json:"joined_at"`
+}
+
+// MessageRef 消息中携带的引用消息
+type MessageRef struct {
+	MessageID string `json:"message_id"`
+}
+
+//	{
+//	    "content_type": "image/jpeg",
+//	    "filename": "7AE5B4D5DB0B1B5D2BA3F9BCE5AAC9B8.jpg",
+//	    "height": 1080,
+//	    "size": 95301,
+//	    "url": "https://multimedia.nt.qq.com.cn/download?appid=1407&fileid=...",
+//	    "width": 1080
+//	}
+type MessageAttachment struct {
+	ID          string `json:"id,omitempty"`
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename"`
+	Height      int    `json:"height,omitempty"`
+	Width       int    `json:"width,omitempty"`
+	Size        int    `json:"size,omitempty"`
+	URL         string `json:"url"`
+}
+
+// IsImage 附件是否为图片，频道的附件可能缺少 content_type，按文件后缀判断
+func (a *MessageAttachment) IsImage() bool {
+	if len(a.ContentType) > 0 {
+		return strings.HasPrefix(a.ContentType, "image/")
+	}
+	switch strings.ToLower(path.Ext(a.Filename)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp":
+		return true
+	}
+	return false
+}
+
+// Timestamp 平台下发的时间，ISO8601 格式，如 "2024-09-04T13:12:43+08:00"，
+// 群聊与单聊的通知事件下发的是秒级时间戳数字
+type Timestamp string
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] != '"' {
+		if string(data) == "null" {
+			return nil
+		}
+		if _, err := strconv.ParseInt(string(data), 10, 64); err != nil {
+			return fmt.Errorf("parse timestamp %s: %w", data, err)
+		}
+		*t = Timestamp(data)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*t = Timestamp(s)
+	return nil
+}
+
+// Time 解析时间，兼容秒级的时间戳字符串，空值返回零值
+func (t Timestamp) Time() (time.Time, error) {
+	return ParseTimestamp(string(t))
+}
+
+// ParseTimestamp 解析平台下发的时间
+func ParseTimestamp(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse timestamp %q: %w", s, err)
+	}
+	return t, nil
 }
 
 const (
@@ -144,7 +279,7 @@ const (
 	// MESSAGE_AUDIT (1 << 27)
 	// - MESSAGE_AUDIT_PASS     // 消息审核通过
 	// - MESSAGE_AUDIT_REJECT   // 消息审核不通过
-	IntentMessageAudit = 1 << 28
+	IntentMessageAudit = 1 << 27
 
 	// FORUMS_EVENT (1 << 28)  // 论坛事件，仅 *私域* 机器人能够设置此 intents。
 	//   - FORUM_THREAD_CREATE     // 当用户创建主题时
@@ -173,6 +308,7 @@ const (
 
 type WsMessage struct {
 	MessageHeader
+	ID   string          `json:"id,omitempty"` // 事件ID，可用于被动回复
 	Data json.RawMessage `json:"d"`
 }
 
@@ -212,6 +348,32 @@ type ReadyMessage struct {
 	Shard []int `json:"shard"`
 }
 
+// HelloData 网关建立连接之后下发的第一条消息
+type HelloData struct {
+	HeartbeatInterval int `json:"heartbeat_interval"` // 心跳周期，单位毫秒
+}
+
+type ResumeData struct {
+	Token     string `json:"token"`
+	SessionID string `json:"session_id"`
+	Seq       uint32 `json:"seq"`
+}
+
+type ResumeMessage struct {
+	MessageHeader
+	Data ResumeData `json:"d"`
+}
+
+var (
+	errReconnect      = errors.New("gateway requests reconnect")
+	errInvalidSession = errors.New("invalid session")
+)
+
+// 重连的最大等待时间
+const maxReconnectDelay = 30 * time.Second
+
+// StartWs 连接网关，连接断开之后自动重连，并尽量通过 resume 恢复会话，
+// 直到 ctx 结束，或者第一次连接就无法建立会话才返回
 func (a *API) StartWs(ctx context.Context) error {
 	gw, err := a.Gateway()
 	if err != nil {
@@ -220,92 +382,206 @@ func (a *API) StartWs(ctx context.Context) error {
 
 	url := gw.URL
 
-	// token := fmt.Sprintf("Bot %d.%s", a.Ticket.AppID, a.Ticket.Token)
-	// header := http.Header{}
-	// header.Set("Authorization", token)
-	// d := websocket.Dialer{}
-	// ws, _, err := d.Dial(url, nil)
-	// if err != nil {
-	// 	return err
-	// }
-
-	// var sessions = make(chan Session)
-	// for {
-	// 	select {
-	// 	case <-ctx.Done():
-	// 	case <-sessions:
-
-	// 	}
-	// }
-	return a.runSession(ctx, url, 0, 1, a.Intents)
+	var session Session
+	var established bool // 是否成功建立过会话
+	var delay = time.Second
+	for {
+		ready, err := a.runSession(ctx, url, &session, 0, 1, a.Intents)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if ready {
+			established = true
+			delay = time.Second
+		}
+		if !established {
+			// 从来没有建立过会话，通常是配置错误，不再重试
+			return err
+		}
+		if err == errInvalidSession {
+			// 会话无法恢复，重新 identify
+			session.ID = ""
+		}
+		if a.Metrics != nil {
+			a.Metrics.GatewayReconnects.Inc()
+		}
+		a.Log().Warn("ws_reconnect", "shard", 0, "session", session.ID, "err", err, "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
 }
 
+// Session 网关会话，断线之后使用 ID 与 Seq 恢复
 type Session struct {
+	ID   string
 	Seq  uint32
 	Conn *websocket.Conn
+
+	shard       int
+	heartbeatAt int64      // 最近一次发送心跳的时间，unix 纳秒，用于统计心跳延迟
+	mu          sync.Mutex // 同一时间只能有一个写入
 }
 
-func (a *API) runSession(ctx context.Context, gateway string, shardIndex, shardTotal int, intent int) error {
-	// 1. connect
-	d := websocket.Dialer{}
-	ws, _, err := d.Dial(gateway, nil)
+// writeFrame 发送消息，开启录制时同时录制
+func (a *API) writeFrame(session *Session, v interface{}) error {
+	data, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
+	if a.Recorder != nil {
+		rec := data
+		if r := redactFrame(v); r != v {
+			rec, _ = json.Marshal(r)
+		}
+		a.Recorder.Record(FrameOut, session.shard, rec)
+	}
 
-	// 2. identify
-	var identify IdentifyMessage
-	identify.Op = OpIdentify
-	identify.Data.Token = BotToken(a.Ticket)
-	identify.Data.Intents = intent
-	// identify.Data.Intents = math.MaxInt32
-	identify.Data.Shard = [2]int{shardIndex, shardTotal}
+	if _, ok := v.(*HeartbeatMessage); ok {
+		atomic.StoreInt64(&session.heartbeatAt, time.Now().UnixNano())
+	}
 
-	if err := ws.WriteJSON(&identify); err != nil {
-		return err
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.Conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// runSession 运行一次网关连接，返回连接期间是否成功建立了会话
+func (a *API) runSession(ctx context.Context, gateway string, session *Session, shardIndex, shardTotal int, intent int) (bool, error) {
+	// 1. connect
+	d := websocket.Dialer{}
+	ws, _, err := d.DialContext(ctx, gateway, nil)
+	if err != nil {
+		return false, err
+	}
+	defer ws.Close()
+	session.Conn = ws
+	session.shard = shardIndex
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// ctx 结束时关闭连接，打断阻塞的读
+		<-ctx.Done()
+		ws.Close()
+	}()
+
+	// 2. hello
+	var hello struct {
+		MessageHeader
+		Data HelloData `json:"d"`
+	}
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		return false, err
+	}
+	if a.Recorder != nil {
+		a.Recorder.Record(FrameIn, shardIndex, data)
+	}
+	if err := json.Unmarshal(data, &hello); err != nil {
+		return false, err
+	}
+	if hello.Op != OpHello {
+		return false, fmt.Errorf("unexpected op %d, want hello", hello.Op)
 	}
 
-	var session = Session{
-		Conn: ws,
+	// 3. identify or resume
+	if len(session.ID) > 0 {
+		var resume ResumeMessage
+		resume.Op = OpResume
+		resume.Data.Token = BotToken(a.Ticket)
+		resume.Data.SessionID = session.ID
+		resume.Data.Seq = atomic.LoadUint32(&session.Seq)
+		if err := a.writeFrame(session, &resume); err != nil {
+			return false, err
+		}
+	} else {
+		var identify IdentifyMessage
+		identify.Op = OpIdentify
+		identify.Data.Token = BotToken(a.Ticket)
+		identify.Data.Intents = intent
+		// identify.Data.Intents = math.MaxInt32
+		identify.Data.Shard = [2]int{shardIndex, shardTotal}
+
+		if err := a.writeFrame(session, &identify); err != nil {
+			return false, err
+		}
+		atomic.StoreUint32(&session.Seq, 0)
 	}
 
-	go a.heartBeat(ctx, &session)
+	interval := time.Duration(hello.Data.HeartbeatInterval) * time.Millisecond
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	go a.heartBeat(ctx, session, interval)
 
+	var ready bool
 	for {
 		_, data, err := ws.ReadMessage()
 		if err != nil {
-			return err
+			return ready, err
 		}
 
-		log.Println("ws_message", string(data))
+		a.Log().Debug("ws_message", "shard", shardIndex, "data", data)
+		if a.Recorder != nil {
+			a.Recorder.Record(FrameIn, shardIndex, data)
+		}
 		var msg WsMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
-			return err
+			return ready, err
 		}
 
-		if msg.Seq > 0 {
-			atomic.StoreUint32(&session.Seq, msg.Seq)
+		switch msg.Op {
+		case OpDispatch:
+			if msg.Seq > 0 {
+				atomic.StoreUint32(&session.Seq, msg.Seq)
+			}
+			switch msg.Type {
+			case EventReady:
+				var r ReadyMessage
+				if err := json.Unmarshal(msg.Data, &r); err == nil {
+					session.ID = r.SessionID
+				}
+				ready = true
+			case EventResumed:
+				ready = true
+			}
+			a.dispatch(msg)
+		case OpHeartbeat: // 服务端要求立即发送心跳
+			var heartBeat HeartbeatMessage
+			heartBeat.Op = OpHeartbeat
+			heartBeat.Seq = atomic.LoadUint32(&session.Seq)
+			if err := a.writeFrame(session, &heartBeat); err != nil {
+				return ready, err
+			}
+		case OpHeartbeatAck:
+			if at := atomic.LoadInt64(&session.heartbeatAt); at > 0 && a.Metrics != nil {
+				a.Metrics.HeartbeatLatency.ObserveSince(time.Unix(0, at))
+			}
+		case OpReconnect:
+			return ready, errReconnect
+		case OpInvalid:
+			return ready, errInvalidSession
+		default:
+			// 忽略其它类型的消息
 		}
-
-		a.dispatch(msg)
-		// switch msg.Op {
-		// case OpDispatch:
-		// default:
-		// 	// 忽略其它类型的消息
-		// }
 	}
-
-	return nil
 }
 
-func (a *API) heartBeat(ctx context.Context, session *Session) error {
+func (a *API) heartBeat(ctx context.Context, session *Session, interval time.Duration) error {
 	var heartBeat HeartbeatMessage
 	heartBeat.Op = OpHeartbeat
-	var ws = session.Conn
-	if err := ws.WriteJSON(&heartBeat); err != nil {
+	heartBeat.Seq = atomic.LoadUint32(&session.Seq)
+	if err := a.writeFrame(session, &heartBeat); err != nil {
 		return err
 	}
-	t := time.NewTicker(10 * time.Second)
+	t := time.NewTicker(interval)
 	defer t.Stop()
 	for {
 		select {
@@ -313,8 +589,7 @@ func (a *API) heartBeat(ctx context.Context, session *Session) error {
 			return nil
 		case <-t.C:
 			heartBeat.Seq = atomic.LoadUint32(&session.Seq)
-			log.Println("heartbeat", &heartBeat)
-			if err := ws.WriteJSON(&heartBeat); err != nil {
+			if err := a.writeFrame(session, &heartBeat); err != nil {
 				return err
 			}
 		}
@@ -322,19 +597,25 @@ func (a *API) heartBeat(ctx context.Context, session *Session) error {
 }
 
 func (a *API) dispatch(msg WsMessage) {
-	if len(a.Handlers) == 0 {
-		return
+	if a.Metrics != nil {
+		a.Metrics.GatewayEvents.Inc(msg.Type)
 	}
-
 	if msg.Type == EventReady {
 		var ready ReadyMessage
 		if err := json.Unmarshal(msg.Data, &ready); err != nil {
 			return
 		}
 		a.BotID = ready.User.ID
+		if a.State != nil {
+			go a.State.Load()
+		}
 		return
 	}
 
+	if a.State != nil {
+		a.State.HandleEvent(msg)
+	}
+
 	if h, ok := a.Handlers[msg.Type]; ok {
 		h(msg)
 	}
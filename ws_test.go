@@ -0,0 +1,52 @@
+package sgroupbot_test
+
+import (
+	"encoding/json"
+	"sgroupbot"
+	"testing"
+)
+
+func TestMessageDecode(t *testing.T) {
+	data := `{
+		"author": {"avatar": "https://qqchannel-profile-1251316161.file.myqcloud.com/xxx", "bot": false, "id": "1234", "username": "张三"},
+		"attachments": [{"content_type": "image/png", "filename": "A.png", "height": 100, "width": 200, "size": 1024, "url": "gchat.qpic.cn/A"}],
+		"channel_id": "100010",
+		"content": "<@!6158788878435714165> 一马当先",
+		"guild_id": "18700000000001",
+		"id": "08e092eeb983afef9e0110f9fb2718dfb3b3a506480f5a",
+		"member": {"joined_at": "2021-04-12T16:34:36+08:00", "nick": "小张", "roles": ["1", "4"]},
+		"message_reference": {"message_id": "088de19cbeb883e7e97c"},
+		"seq_in_channel": "26",
+		"timestamp": "2021-05-20T15:14:58+08:00"
+	}`
+
+	var msg sgroupbot.Message
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.DisplayName() != "小张" || len(msg.Member.Roles) != 2 {
+		t.Errorf("member: %+v", msg.Member)
+	}
+	if len(msg.Images()) != 1 || msg.Images()[0].Width != 200 {
+		t.Errorf("attachments: %+v", msg.Attachments)
+	}
+	if msg.SeqInChannel != "26" || msg.MessageReference == nil || msg.MessageReference.MessageID != "088de19cbeb883e7e97c" {
+		t.Errorf("seq/reference: %s %+v", msg.SeqInChannel, msg.MessageReference)
+	}
+
+	ts, err := msg.Time()
+	if err != nil || ts.Unix() != 1621494898 {
+		t.Errorf("timestamp: %v %v", ts, err)
+	}
+	joined, err := msg.Member.JoinedAt.Time()
+	if err != nil || joined.Year() != 2021 {
+		t.Errorf("joined_at: %v %v", joined, err)
+	}
+
+	if ts, err := sgroupbot.ParseTimestamp("1725426763"); err != nil || ts.Unix() != 1725426763 {
+		t.Errorf("unix timestamp: %v %v", ts, err)
+	}
+	if _, err := sgroupbot.ParseTimestamp("yesterday"); err == nil {
+		t.Error("invalid timestamp should fail")
+	}
+}